@@ -0,0 +1,138 @@
+// Package audit records who changed which entity when, with the entity before and after the change.
+// The repositories write an Entry per change into the audit_log table, in the same transaction as the change.
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// System is the actor of changes made without one in the context, e.g. by the purge job
+const System = "system"
+
+// Entry is one change of an entity
+type Entry struct {
+	ID         uint      `gorm:"primarykey"`
+	Actor      string    `gorm:"index"`
+	EntityType string    `gorm:"index:idx_audit_log_entity"`
+	EntityID   string    `gorm:"index:idx_audit_log_entity"`
+	Action     string    // a publisher.Action: created, updated, upserted, deleted, restored or purged
+	Before     []byte    `gorm:"type:jsonb"` // null for a create
+	After      []byte    `gorm:"type:jsonb"` // null for a purge
+	Diff       []byte    `gorm:"type:jsonb"` // changed fields: {"Title": {"before": "a", "after": "b"}}
+	CreatedAt  time.Time `gorm:"index"`
+}
+
+func (Entry) TableName() string {
+	return "audit_log"
+}
+
+// MarshalJSON writes the snapshots and the diff as JSON instead of base64
+func (e Entry) MarshalJSON() ([]byte, error) {
+	type entry Entry // without this method
+	return json.Marshal(struct {
+		entry
+		Before json.RawMessage
+		After  json.RawMessage
+		Diff   json.RawMessage
+	}{entry(e), raw(e.Before), raw(e.After), raw(e.Diff)})
+}
+
+func raw(data []byte) json.RawMessage {
+	if len(data) == 0 {
+		return json.RawMessage("null")
+	}
+	return data
+}
+
+// Change is the difference of one field
+type Change struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+type actorKey struct{}
+
+// WithActor returns a context whose changes are recorded as made by actor, e.g. a user name
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFrom returns the actor of ctx, System when there is none
+func ActorFrom(ctx context.Context) string {
+	if ctx != nil {
+		if actor, ok := ctx.Value(actorKey{}).(string); ok && actor != "" {
+			return actor
+		}
+	}
+	return System
+}
+
+// NewEntry describes a change of an entity, before or after is nil when the entity was created or purged
+func NewEntry(ctx context.Context, entityType, entityID, action string, before, after interface{}) (Entry, error) {
+	entry := Entry{
+		Actor:      ActorFrom(ctx),
+		EntityType: entityType,
+		EntityID:   entityID,
+		Action:     action,
+		CreatedAt:  time.Now(),
+	}
+
+	var beforeFields, afterFields map[string]interface{}
+	var err error
+	if entry.Before, beforeFields, err = snapshot(before); err != nil {
+		return entry, err
+	}
+	if entry.After, afterFields, err = snapshot(after); err != nil {
+		return entry, err
+	}
+	entry.Diff, err = json.Marshal(Diff(beforeFields, afterFields))
+	return entry, err
+}
+
+// Write stores entries through db, e.g. the transaction of the change
+func Write(db *gorm.DB, entries []Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	return db.Session(&gorm.Session{NewDB: true}).Create(&entries).Error
+}
+
+// Diff returns the top level fields that differ between two JSON objects, nil ones are missing
+func Diff(before, after map[string]interface{}) map[string]Change {
+	diff := map[string]Change{}
+	for key, value := range after {
+		if old, ok := before[key]; !ok || !reflect.DeepEqual(old, value) {
+			diff[key] = Change{Before: old, After: value}
+		}
+	}
+	for key, value := range before {
+		if _, ok := after[key]; !ok {
+			diff[key] = Change{Before: value}
+		}
+	}
+	return diff
+}
+
+// snapshot encodes entity as JSON, nil entities as nil
+func snapshot(entity interface{}) ([]byte, map[string]interface{}, error) {
+	if entity == nil || (reflect.ValueOf(entity).Kind() == reflect.Ptr && reflect.ValueOf(entity).IsNil()) {
+		return nil, nil, nil
+	}
+	data, err := json.Marshal(entity)
+	if err != nil {
+		return nil, nil, err
+	}
+	var fields map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber() // keep large IDs exact
+	if err := decoder.Decode(&fields); err != nil {
+		return nil, nil, err
+	}
+	return data, fields, nil
+}
@@ -0,0 +1,61 @@
+// Command migrationdiff compares the models with the database of DATABASE_URL and scaffolds a migration
+// adding what the database is missing, e.g.
+//
+//	go run ./cmd/migrationdiff -name add_post_slug
+//
+// writes migrations/sql/<version>_add_post_slug.up.sql and .down.sql. Review them before committing,
+// renamed and dropped columns only show up as comments.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"gorepository/migrations"
+
+	"github.com/joho/godotenv"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func main() {
+	name := flag.String("name", "", "name of the migration, e.g. add_post_slug")
+	dir := flag.String("dir", "migrations/sql", "directory of the SQL migrations")
+	printSQL := flag.Bool("print", false, "print the SQL instead of writing the migration")
+	flag.Parse()
+	if *name == "" && !*printSQL {
+		log.Fatal("-name is required")
+	}
+
+	_ = godotenv.Load() // DATABASE_URL may come from the environment as well
+	db, err := gorm.Open(postgres.Open(os.Getenv("DATABASE_URL")), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	up, down, err := migrations.Diff(db, migrations.Models...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(up) == 0 {
+		log.Print("the database matches the models")
+		return
+	}
+	if *printSQL {
+		for _, sql := range up {
+			fmt.Println(sql)
+		}
+		return
+	}
+
+	paths, err := migrations.Scaffold(*dir, *name, up, down)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, path := range paths {
+		log.Printf("wrote %s", path)
+	}
+}
@@ -0,0 +1,47 @@
+// Command schemagen writes the JSON Schemas of the models and their events into a directory, e.g.
+//
+//	go run ./cmd/schemagen -out schemas
+//
+// writes schemas/post.json (the data of an event) and schemas/post.event.json (the whole event) for model.Post.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+
+	"gorepository/eventschema"
+	"gorepository/model"
+	"gorepository/publisher"
+)
+
+// models are the entities published by the repositories
+var models = []interface{}{model.User{}, model.Post{}}
+
+func main() {
+	out := flag.String("out", "schemas", "directory to write the schemas to")
+	prefix := flag.String("prefix", "gorepository", "topic prefix of the event types, see PUBLISHER_PREFIX")
+	flag.Parse()
+
+	if err := os.MkdirAll(*out, 0o755); err != nil {
+		log.Fatal(err)
+	}
+	for _, m := range models {
+		name := publisher.EntityType(m)
+		write(filepath.Join(*out, name+".json"), eventschema.For(m))
+		write(filepath.Join(*out, name+".event.json"), eventschema.Event(m, *prefix))
+	}
+}
+
+func write(path string, schema *eventschema.Schema) {
+	data, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("wrote %s", path)
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"gorepository/migrations"
+	"gorepository/model"
+	"gorepository/publisher"
+	"gorepository/repository"
+	"gorepository/stream"
+	"gorepository/validation"
+)
+
+func migrate(args []string) error {
+	if len(args) == 0 || (args[0] != "up" && args[0] != "down" && args[0] != "status") {
+		return errors.New("expected up, down or status")
+	}
+	flags := flag.NewFlagSet("migrate "+args[0], flag.ExitOnError)
+	steps := flags.Int("steps", 1, "how many migrations down reverts")
+	dryRun := flags.Bool("dry-run", false, "print the SQL instead of running it")
+	flags.Parse(args[1:])
+
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	migrator, err := migrations.New(db)
+	if err != nil {
+		return err
+	}
+	migrator.DryRun = *dryRun
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		n, err := migrator.Up(ctx)
+		log.Printf("%d migrations applied", n)
+		return err
+	case "down":
+		n, err := migrator.Down(ctx, *steps)
+		log.Printf("%d migrations reverted", n)
+		return err
+	}
+
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+	for _, status := range statuses {
+		applied := "pending"
+		if status.AppliedAt != nil {
+			applied = status.AppliedAt.Format(time.RFC3339)
+		}
+		if status.Unknown {
+			applied += " (not in this binary)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", status.Version, status.Name, applied)
+	}
+	return w.Flush()
+}
+
+func seed(args []string) error {
+	flags := flag.NewFlagSet("seed", flag.ExitOnError)
+	users := flags.Int("users", 10, "how many users to create")
+	posts := flags.Int("posts", 3, "how many posts to create per user")
+	flags.Parse(args)
+
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	repos := newRepositories(db, txPublisher(db)).WithContext(cliContext())
+
+	var count int64
+	if err := repos.UserRepo.CountWithConditions(&count, nil, repository.WithTrashed()); err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("the database has %d users already, seed only fills an empty one", count)
+	}
+
+	return repos.Transaction(func(tx *repository.Repositories) error {
+		seededUsers := make([]model.User, *users)
+		for i := range seededUsers {
+			seededUsers[i] = model.User{Name: fmt.Sprintf("User %d", i+1), Email: fmt.Sprintf("user%d@example.com", i+1)}
+		}
+		seededUsers, err := tx.UserRepo.CreateMany(seededUsers, 100)
+		if err != nil {
+			return err
+		}
+
+		var seededPosts []model.Post
+		for _, user := range seededUsers {
+			for i := 0; i < *posts; i++ {
+				seededPosts = append(seededPosts, model.Post{
+					Title:     fmt.Sprintf("Post %d of %s", i+1, user.Name),
+					Content:   "Lorem ipsum dolor sit amet.",
+					UserID:    user.ID,
+					Published: i%2 == 0,
+				})
+			}
+		}
+		if _, err := tx.PostRepo.CreateMany(seededPosts, 100); err != nil {
+			return err
+		}
+
+		log.Printf("seeded %d users and %d posts", len(seededUsers), len(seededPosts))
+		return nil
+	})
+}
+
+// listRoutes prints the routes of newApp, which needs no database to be registered
+func listRoutes(args []string) error {
+	app := newApp(repository.NewMemoryRepositories(nil), publisher.NewDeadLetterStore(nil, nil), publisher.NewWebhooks(nil), stream.NewHub())
+
+	routes := app.GetRoutes(true)
+	sort.SliceStable(routes, func(i, j int) bool {
+		if routes[i].Path != routes[j].Path {
+			return routes[i].Path < routes[j].Path
+		}
+		return routes[i].Method < routes[j].Method
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "METHOD\tPATH")
+	for _, route := range routes {
+		if route.Method == "HEAD" {
+			continue // every GET answers HEAD as well
+		}
+		fmt.Fprintf(w, "%s\t%s\n", route.Method, route.Path)
+	}
+	return w.Flush()
+}
+
+func user(args []string) error {
+	if len(args) == 0 || (args[0] != "create" && args[0] != "disable") {
+		return errors.New("expected create or disable")
+	}
+	if args[0] == "create" {
+		return createUser(args[1:])
+	}
+	return disableUser(args[1:])
+}
+
+func createUser(args []string) error {
+	var newUser model.User
+	flags := flag.NewFlagSet("user create", flag.ExitOnError)
+	flags.StringVar(&newUser.Name, "name", "", "name of the user")
+	flags.StringVar(&newUser.Email, "email", "", "email of the user")
+	flags.Parse(args)
+	if err := validation.New().Validate(context.Background(), validation.Create, &newUser); err != nil {
+		return err
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	repos := newRepositories(db, txPublisher(db)).WithContext(cliContext())
+
+	created, err := repos.UserRepo.Create(newUser)
+	if err != nil {
+		return err
+	}
+	log.Printf("created user %d", created.ID)
+	return nil
+}
+
+// disableUser soft deletes the user, POST /users/:id/restore enables them again
+func disableUser(args []string) error {
+	if len(args) != 1 {
+		return errors.New("expected the ID of the user")
+	}
+	id, err := strconv.ParseUint(args[0], 10, 0)
+	if err != nil {
+		return fmt.Errorf("invalid user ID %q", args[0])
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	repos := newRepositories(db, txPublisher(db)).WithContext(cliContext())
+
+	// Delete does not tell whether there was a user to delete
+	if _, err := repos.UserRepo.FindByID(uint(id)); errors.Is(err, repository.ErrNotFound) {
+		return fmt.Errorf("no user %d, or they are disabled already", id)
+	} else if err != nil {
+		return err
+	}
+	if err := repos.UserRepo.Delete(uint(id)); err != nil {
+		return err
+	}
+	log.Printf("disabled user %d", id)
+	return nil
+}
+
+// replayDeadLetters replays the events the broker relay gave up on, see /admin/dead-letters
+func replayDeadLetters(args []string) error {
+	if len(args) == 0 || args[0] != "replay" {
+		return errors.New("expected replay")
+	}
+	var ids []uint
+	for _, arg := range args[1:] {
+		id, err := strconv.ParseUint(arg, 10, 0)
+		if err != nil {
+			return fmt.Errorf("invalid dead letter ID %q", arg)
+		}
+		ids = append(ids, uint(id))
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	// straight to the broker, like the replays of /admin/dead-letters
+	broker, err := publisher.Open(publisher.BrokerConfigFromEnv())
+	if err != nil {
+		return err
+	}
+	defer publisher.Close(broker)
+
+	replayed, err := publisher.NewDeadLetterStore(db, broker).Replay(context.Background(), ids...)
+	log.Printf("replayed %d events", replayed)
+	return err
+}
@@ -0,0 +1,199 @@
+// Package eventschema generates JSON Schemas (draft 2020-12) of the models and of the events published about them,
+// so consumers can validate what they receive. cmd/schemagen writes them into schemas/.
+package eventschema
+
+import (
+	"encoding/json"
+	"gorepository/publisher"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const draft = "https://json-schema.org/draft/2020-12/schema"
+
+// Schema is the subset of JSON Schema the generator writes
+type Schema struct {
+	Schema      string             `json:"$schema,omitempty"`
+	Title       string             `json:"title,omitempty"`
+	Description string             `json:"description,omitempty"`
+	Type        interface{}        `json:"type,omitempty"` // a type name, or a list of them for nullable values
+	Format      string             `json:"format,omitempty"`
+	Enum        []interface{}      `json:"enum,omitempty"`
+	Const       interface{}        `json:"const,omitempty"`
+	MinLength   *int               `json:"minLength,omitempty"`
+	MaxLength   *int               `json:"maxLength,omitempty"`
+	Minimum     *float64           `json:"minimum,omitempty"`
+	Maximum     *float64           `json:"maximum,omitempty"`
+	Items       *Schema            `json:"items,omitempty"`
+	Properties  map[string]*Schema `json:"properties,omitempty"`
+	Required    []string           `json:"required,omitempty"`
+
+	AdditionalProperties interface{} `json:"additionalProperties,omitempty"` // false or a *Schema
+}
+
+// For returns the schema of model as encoding/json writes it, e.g. For(model.Post{}). Embedded structs like
+// gorm.Model are flattened and the validate tags (required, email, min, max and oneof) become constraints.
+func For(model interface{}) *Schema {
+	t := reflect.TypeOf(model)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	schema := typeSchema(t)
+	schema.Schema = draft
+	schema.Title = t.Name()
+	return schema
+}
+
+// Event returns the schema of the events published about model, whose data is For(model)
+func Event(model interface{}, prefix string) *Schema {
+	entityType := publisher.EntityType(model)
+	actions := publisher.Actions
+	types := make([]interface{}, len(actions))
+	actionNames := make([]interface{}, len(actions))
+	for i, action := range actions {
+		types[i] = publisher.Topic(prefix, entityType, string(action))
+		actionNames[i] = string(action)
+	}
+
+	data := For(model)
+	data.Schema = ""
+
+	str := func() *Schema { return &Schema{Type: "string"} }
+	return &Schema{
+		Schema:      draft,
+		Title:       entityType + " event",
+		Description: "a CloudEvents 1.0 event in structured mode about a change of a " + entityType,
+		Type:        "object",
+		Properties: map[string]*Schema{
+			"specversion":     {Const: "1.0"},
+			"id":              str(),
+			"source":          {Const: "/" + prefix + "/" + entityType},
+			"type":            {Enum: types},
+			"subject":         {Type: "string", Description: "the " + entityType + " ID"},
+			"time":            {Type: "string", Format: "date-time"},
+			"datacontenttype": {Const: "application/json"},
+			"dataschema":      {Type: "string", Format: "uri"},
+			"data":            data,
+			"entitytype":      {Const: entityType},
+			"action":          {Enum: actionNames},
+		},
+		Required: []string{"specversion", "id", "source", "type", "subject", "time", "datacontenttype", "data", "entitytype", "action"},
+	}
+}
+
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	deletedAtType = reflect.TypeOf(gorm.DeletedAt{})
+	marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+func typeSchema(t reflect.Type) *Schema {
+	switch t {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case deletedAtType:
+		return &Schema{Type: []string{"string", "null"}, Format: "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		schema := typeSchema(t.Elem())
+		if name, ok := schema.Type.(string); ok {
+			schema.Type = []string{name, "null"}
+		}
+		return schema
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Schema{Type: "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer", Minimum: float(0)}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"} // base64
+		}
+		return &Schema{Type: "array", Items: typeSchema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: typeSchema(t.Elem())}
+	case reflect.Struct:
+		if t.Implements(marshalerType) || reflect.PointerTo(t).Implements(marshalerType) {
+			return &Schema{} // any value, it writes its own JSON
+		}
+		schema := &Schema{Type: "object", Properties: map[string]*Schema{}, AdditionalProperties: false}
+		addFields(schema, t)
+		return schema
+	default:
+		return &Schema{}
+	}
+}
+
+// addFields adds the exported fields of t to schema, those of embedded structs as if they were t's own
+func addFields(schema *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, options, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			addFields(schema, f.Type)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		property := typeSchema(f.Type)
+		constrain(property, f.Tag.Get("validate"))
+		schema.Properties[name] = property
+		if !strings.Contains(options, "omitempty") {
+			schema.Required = append(schema.Required, name) // encoding/json always writes it
+		}
+	}
+}
+
+// constrain adds the rules of a validate tag that hold for every stored entity, rules of one set only
+// (create: or update:) and registered ones are left out
+func constrain(schema *Schema, tag string) {
+	isString := schema.Type == "string" || reflect.DeepEqual(schema.Type, []string{"string", "null"})
+	for _, item := range strings.Split(tag, ",") {
+		name, param, _ := strings.Cut(strings.TrimSpace(item), "=")
+		n, err := strconv.ParseFloat(param, 64)
+		switch {
+		case name == "required" && isString:
+			schema.MinLength = length(1)
+		case name == "required" && schema.Type == "integer":
+			schema.Minimum = float(1) // not zero, IDs start at 1
+		case name == "email":
+			schema.Format = "email"
+		case name == "oneof":
+			for _, value := range strings.Fields(param) {
+				schema.Enum = append(schema.Enum, value)
+			}
+		case (name == "min" || name == "max") && err == nil:
+			if isString && name == "min" {
+				schema.MinLength = length(int(n))
+			} else if isString {
+				schema.MaxLength = length(int(n))
+			} else if name == "min" {
+				schema.Minimum = float(n)
+			} else {
+				schema.Maximum = float(n)
+			}
+		}
+	}
+}
+
+func length(n int) *int        { return &n }
+func float(n float64) *float64 { return &n }
@@ -1,46 +1,133 @@
+//go:generate go run ./cmd/schemagen -out schemas
+
+// The gorepository binary serves the API and runs the maintenance tasks around it:
+//
+//	gorepository serve [-port 3000] [-migrate=true]
+//	gorepository migrate up|down|status [-steps 1] [-dry-run]
+//	gorepository seed [-users 10] [-posts 3]
+//	gorepository routes
+//	gorepository user create -name Ann -email ann@example.com
+//	gorepository user disable <id>
+//	gorepository dead-letters replay [id...]
+//
+// The configuration comes from the environment, and from .env when there is one.
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
 	"os"
+	"sort"
 
-	"gorepository/model"
+	"gorepository/audit"
+	"gorepository/publisher"
 	"gorepository/repository"
-	"gorepository/routes"
 
 	"github.com/joho/godotenv"
-
-	"github.com/gofiber/fiber/v2"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
+// command is a subcommand, run gets the arguments after its name
+type command struct {
+	args string
+	help string
+	run  func(args []string) error
+}
+
+var commands = map[string]command{
+	"serve":        {"[-port 3000] [-migrate=true]", "start the HTTP server", serve},
+	"migrate":      {"up|down|status [-steps 1] [-dry-run]", "apply, revert or list the migrations", migrate},
+	"seed":         {"[-users 10] [-posts 3]", "fill an empty database with sample users and posts", seed},
+	"routes":       {"", "list the routes of the API", listRoutes},
+	"user":         {"create -name <name> -email <email> | disable <id>", "add a user, or soft delete one", user},
+	"dead-letters": {"replay [id...]", "publish the dead lettered events again, all of them without ids", replayDeadLetters},
+}
+
 func main() {
-	// Load environment variables from .env file
-	err := godotenv.Load()
-	if err != nil {
-		panic("Failed to load environment variables")
+	log.SetFlags(0)
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
 	}
 
-	app := fiber.New(fiber.Config{
-		AppName:           "identity front end api",
-		EnablePrintRoutes: true,
-	})
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
 
-	// Retrieve connection string from environment variables
-	dsn := os.Getenv("DATABASE_URL")
+	// Load environment variables from .env file, those already set take precedence
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Fatalf("failed to load .env: %v", err)
+	}
+
+	if err := cmd.run(flag.Args()[1:]); err != nil {
+		log.Fatalf("%s: %v", flag.Arg(0), err)
+	}
+}
 
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(os.Stderr, "usage: %s <command> [arguments]\n\ncommands:\n", os.Args[0])
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n               %s\n", name, commands[name].args, commands[name].help)
+	}
+}
+
+// openDB connects to DATABASE_URL
+func openDB() (*gorm.DB, error) {
+	dsn := os.Getenv("DATABASE_URL")
+	if dsn == "" {
+		return nil, errors.New("DATABASE_URL is not set")
+	}
 	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
 	if err != nil {
-		panic("failed to connect database")
+		return nil, fmt.Errorf("failed to connect database: %w", err)
 	}
+	return db, nil
+}
+
+// The outbox targets, each relayed by serve on its own
+const (
+	targetBroker   = "" // the rows from before there were targets
+	targetWebhooks = "webhooks"
+)
 
-	// Migrate the schema
-	db.AutoMigrate(&model.User{}, &model.Post{})
+// outboxTargets are the targets every event is stored for
+var outboxTargets = []string{targetBroker, targetWebhooks}
 
-	repos := repository.NewRepositories(db)
+// txPublisher is what the changes are published through unless serve publishes asynchronously: the outbox the
+// relays of every serve deliver from, and pg_notify to the change streams of every serve
+func txPublisher(db *gorm.DB) publisher.Publisher {
+	outbox := publisher.NewOutbox(db)
+	outbox.Targets = outboxTargets
+	return publisher.TxFanout{outbox, publisher.NewNotify(db)}
+}
 
-	// Update the call to SetupRoutes to pass both repositories
-	routes.SetupRoutes(app, repos)
+// newRepositories wires the repositories of every command: every change is recorded in the audit log with
+// its actor, see routes.BearerAuth, and published through pub
+func newRepositories(db *gorm.DB, pub publisher.Publisher) *repository.Repositories {
+	return repository.NewRepositoriesWithPublisher(db, pub, repository.WithAudit(true))
+}
 
-	app.Listen(":3000")
+// cliContext is the context of the commands other than serve, their changes are audited as made by cli:$USER
+func cliContext() context.Context {
+	actor := "cli"
+	if name := os.Getenv("USER"); name != "" {
+		actor += ":" + name
+	}
+	return audit.WithActor(context.Background(), actor)
 }
@@ -0,0 +1,36 @@
+package migrations
+
+import (
+	"gorepository/publisher"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// events stored in the outbox before it kept their ID get one, so consumers can deduplicate them
+func init() {
+	Register(Migration{
+		Version: "20261018000003",
+		Name:    "backfill_outbox_event_ids",
+		Up: func(tx *gorm.DB) error {
+			for {
+				var ids []uint
+				err := tx.Model(&publisher.OutboxEvent{}).
+					Where("event_id IS NULL OR event_id = ''").
+					Limit(1000).
+					Pluck("id", &ids).Error
+				if err != nil || len(ids) == 0 {
+					return err
+				}
+				for _, id := range ids {
+					if err := tx.Model(&publisher.OutboxEvent{}).Where("id = ?", id).Update("event_id", uuid.NewString()).Error; err != nil {
+						return err
+					}
+				}
+			}
+		},
+		Down: func(tx *gorm.DB) error {
+			return nil // the IDs do no harm
+		},
+	})
+}
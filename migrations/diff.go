@@ -0,0 +1,157 @@
+package migrations
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gorepository/audit"
+	"gorepository/model"
+	"gorepository/publisher"
+
+	"gorm.io/gorm"
+)
+
+// Models are the models whose tables the migrations keep, those Diff compares with the database
+var Models = []interface{}{
+	&model.User{}, &model.Post{}, &audit.Entry{},
+	&publisher.OutboxEvent{}, &publisher.DeadLetter{},
+	&publisher.WebhookSubscription{}, &publisher.WebhookDelivery{},
+}
+
+// Diff compares the tables of models with the database and returns the SQL that adds what is missing, tables,
+// columns and indexes, and the SQL undoing it. Columns the models no longer have or whose type changed are
+// only pointed out in comments, dropping or converting data is for a human to write.
+func Diff(db *gorm.DB, models ...interface{}) (up, down []string, err error) {
+	var buf bytes.Buffer
+	dryRun := db.Session(&gorm.Session{DryRun: true, Logger: sqlWriter{&buf}})
+	capture := func(fn func(m gorm.Migrator) error) ([]string, error) {
+		buf.Reset()
+		if err := fn(dryRun.Migrator()); err != nil {
+			return nil, err
+		}
+		return strings.Split(strings.TrimSpace(buf.String()), "\n"), nil
+	}
+	// down undoes up backwards
+	undo := func(sql string, args ...interface{}) {
+		down = append([]string{fmt.Sprintf(sql, args...)}, down...)
+	}
+
+	live := db.Migrator()
+	for _, model := range models {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return nil, nil, err
+		}
+		table := stmt.Schema.Table
+
+		if !live.HasTable(model) {
+			sqls, err := capture(func(m gorm.Migrator) error { return m.CreateTable(model) })
+			if err != nil {
+				return nil, nil, err
+			}
+			up = append(up, sqls...)
+			undo(`DROP TABLE IF EXISTS %q;`, table)
+			continue
+		}
+
+		columnTypes, err := live.ColumnTypes(model)
+		if err != nil {
+			return nil, nil, err
+		}
+		columns := map[string]gorm.ColumnType{}
+		for _, column := range columnTypes {
+			columns[column.Name()] = column
+		}
+
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName == "" || field.IgnoreMigration {
+				continue
+			}
+			column, ok := columns[field.DBName]
+			delete(columns, field.DBName)
+			if !ok {
+				sqls, err := capture(func(m gorm.Migrator) error { return m.AddColumn(model, field.Name) })
+				if err != nil {
+					return nil, nil, err
+				}
+				up = append(up, sqls...)
+				undo(`ALTER TABLE %q DROP COLUMN %q;`, table, field.DBName)
+				continue
+			}
+			modelType := strings.ToLower(dryRun.Migrator().FullDataTypeOf(field).SQL)
+			if dbType := strings.ToLower(column.DatabaseTypeName()); !sameType(dbType, modelType) {
+				up = append(up, fmt.Sprintf(`-- %q.%q is %s in the database and %s in the model`, table, field.DBName, dbType, modelType))
+			}
+		}
+
+		var dropped []string
+		for name := range columns {
+			dropped = append(dropped, name)
+		}
+		sort.Strings(dropped)
+		for _, name := range dropped {
+			up = append(up, fmt.Sprintf(`-- %q.%q is not in the model: ALTER TABLE %q DROP COLUMN %q;`, table, name, table, name))
+		}
+
+		indexes := stmt.Schema.ParseIndexes()
+		names := make([]string, 0, len(indexes))
+		for name := range indexes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if live.HasIndex(model, name) {
+				continue
+			}
+			sqls, err := capture(func(m gorm.Migrator) error { return m.CreateIndex(model, name) })
+			if err != nil {
+				return nil, nil, err
+			}
+			up = append(up, sqls...)
+			undo(`DROP INDEX IF EXISTS %q;`, name)
+		}
+	}
+	return up, down, nil
+}
+
+// sameType reports whether the type Postgres reports for a column is the type of the model, e.g. int8 and
+// bigint. Only the name of the type is compared, not its length or precision.
+func sameType(dbType, modelType string) bool {
+	aliases := map[string]string{
+		"int8": "bigint", "bigserial": "bigint", "int4": "integer", "serial": "integer", "int2": "smallint",
+		"bool": "boolean", "float8": "double", "float4": "real", "decimal": "numeric", "varchar": "character",
+	}
+	normalize := func(t string) string {
+		t, _, _ = strings.Cut(t, "(")
+		if fields := strings.Fields(t); len(fields) > 0 {
+			t = fields[0] // without NOT NULL, DEFAULT...
+		}
+		if alias, ok := aliases[t]; ok {
+			return alias
+		}
+		return t
+	}
+	return normalize(dbType) == normalize(modelType)
+}
+
+// Scaffold writes the up and down SQL of a new migration called name into dir and returns the paths
+func Scaffold(dir, name string, up, down []string) ([]string, error) {
+	version := time.Now().UTC().Format("20060102150405")
+	var paths []string
+	for _, file := range []struct {
+		direction string
+		sql       []string
+	}{{"up", up}, {"down", down}} {
+		path := filepath.Join(dir, version+"_"+name+"."+file.direction+".sql")
+		if err := os.WriteFile(path, []byte(strings.Join(file.sql, "\n")+"\n"), 0o644); err != nil {
+			return paths, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
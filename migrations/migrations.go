@@ -0,0 +1,301 @@
+// Package migrations versions the database schema. A migration is a pair of SQL files in sql/,
+// <version>_<name>.up.sql and <version>_<name>.down.sql, or Go functions added with Register. They run in the
+// order of their versions, each in a transaction, and schema_migrations records which ones are applied.
+// cmd/migrationdiff scaffolds the SQL of a new migration from the changes of the models.
+package migrations
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Migration changes the schema from the previous version to Version and back
+type Migration struct {
+	Version string // e.g. 20240101120000, versions sort as strings
+	Name    string
+
+	UpSQL   string
+	DownSQL string
+	// Up and Down run instead of the SQL when they are set
+	Up   func(tx *gorm.DB) error
+	Down func(tx *gorm.DB) error
+}
+
+func (m Migration) String() string {
+	return m.Version + "_" + m.Name
+}
+
+//go:embed sql/*.sql
+var sqlFiles embed.FS
+
+var registered []Migration
+
+// Register adds a migration written in Go, from the init function of its file in this package
+func Register(m Migration) {
+	registered = append(registered, m)
+}
+
+// All returns the migrations of sql/ and those registered, ordered by version
+func All() ([]Migration, error) {
+	byVersion := map[string]*Migration{}
+	add := func(m Migration) (*Migration, error) {
+		if existing, ok := byVersion[m.Version]; ok {
+			if existing.Name != m.Name || existing.Up != nil || m.Up != nil {
+				return nil, fmt.Errorf("migrations %s and %s have the same version", existing, m)
+			}
+			return existing, nil
+		}
+		byVersion[m.Version] = &m
+		return &m, nil
+	}
+
+	entries, err := fs.ReadDir(sqlFiles, "sql")
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		base, direction, ok := strings.Cut(strings.TrimSuffix(entry.Name(), ".sql"), ".")
+		version, name, hasName := strings.Cut(base, "_")
+		if !ok || !hasName || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("%s is not named <version>_<name>.up.sql or .down.sql", entry.Name())
+		}
+		content, err := fs.ReadFile(sqlFiles, path.Join("sql", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		m, err := add(Migration{Version: version, Name: name})
+		if err != nil {
+			return nil, err
+		}
+		if direction == "up" {
+			m.UpSQL = string(content)
+		} else {
+			m.DownSQL = string(content)
+		}
+	}
+	for _, r := range registered {
+		if _, err := add(r); err != nil {
+			return nil, err
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == nil && m.UpSQL == "" {
+			return nil, fmt.Errorf("migration %s has no up", m)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// SchemaMigration is an applied migration in the schema_migrations table
+type SchemaMigration struct {
+	Version   string `gorm:"primaryKey"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// Status is a migration and when it was applied, nil while it is pending
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+	Unknown   bool // applied, but not among the migrations of this binary
+}
+
+// Migrator applies migrations. It holds a Postgres advisory lock while it does, so replicas starting
+// at the same time run them once.
+type Migrator struct {
+	db         *gorm.DB
+	migrations []Migration
+
+	// DryRun writes the SQL of the migrations to Out instead of running it. Go migrations run against a
+	// dry run session, whatever they read is empty.
+	DryRun bool
+	Out    io.Writer // what is applied, the SQL of a dry run
+	LockID int64     // key of the advisory lock
+}
+
+// New returns a Migrator of All
+func New(db *gorm.DB) (*Migrator, error) {
+	migrations, err := All()
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations, Out: os.Stdout, LockID: 7263554}, nil
+}
+
+// Status lists every migration, and the applied ones this binary does not know of
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	applied, err := m.applied(m.db.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []Status
+	for _, migration := range m.migrations {
+		status := Status{Migration: migration}
+		if row, ok := applied[migration.Version]; ok {
+			status.AppliedAt = &row.AppliedAt
+			delete(applied, migration.Version)
+		}
+		statuses = append(statuses, status)
+	}
+	for _, row := range applied {
+		at := row.AppliedAt
+		statuses = append(statuses, Status{Migration: Migration{Version: row.Version, Name: row.Name}, AppliedAt: &at, Unknown: true})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
+
+// Up applies the pending migrations and returns how many it applied
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	count := 0
+	err := m.locked(ctx, func(conn *gorm.DB) error {
+		applied, err := m.applied(conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+			if err := m.run(conn, migration, true); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// Down reverts the last steps applied migrations and returns how many it reverted
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	count := 0
+	err := m.locked(ctx, func(conn *gorm.DB) error {
+		applied, err := m.applied(conn)
+		if err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0 && count < steps; i-- {
+			migration := m.migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+			if migration.Down == nil && migration.DownSQL == "" {
+				return fmt.Errorf("migration %s cannot be reverted, it has no down", migration)
+			}
+			if err := m.run(conn, migration, false); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// locked runs fn on a connection holding the advisory lock, waiting for another migrator to finish
+func (m *Migrator) locked(ctx context.Context, fn func(conn *gorm.DB) error) error {
+	return m.db.WithContext(ctx).Connection(func(conn *gorm.DB) error {
+		conn = conn.Session(&gorm.Session{NewDB: true}) // every statement on its own, still on the connection
+		if err := conn.Exec("SELECT pg_advisory_lock(?)", m.LockID).Error; err != nil {
+			return err
+		}
+		defer conn.Exec("SELECT pg_advisory_unlock(?)", m.LockID)
+
+		if !m.DryRun {
+			if err := conn.AutoMigrate(&SchemaMigration{}); err != nil {
+				return err
+			}
+		}
+		return fn(conn)
+	})
+}
+
+// applied returns the rows of schema_migrations by version, none while the table does not exist
+func (m *Migrator) applied(db *gorm.DB) (map[string]SchemaMigration, error) {
+	applied := map[string]SchemaMigration{}
+	if !db.Migrator().HasTable(&SchemaMigration{}) {
+		return applied, nil
+	}
+	var rows []SchemaMigration
+	if err := db.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
+
+// run applies or reverts migration in a transaction together with its schema_migrations row
+func (m *Migrator) run(conn *gorm.DB, migration Migration, up bool) error {
+	fn, sql, direction, verb := migration.Up, migration.UpSQL, "up", "applied"
+	if !up {
+		fn, sql, direction, verb = migration.Down, migration.DownSQL, "down", "reverted"
+	}
+
+	if m.DryRun {
+		fmt.Fprintf(m.Out, "-- %s %s\n", migration, direction)
+		if fn != nil {
+			return fn(conn.Session(&gorm.Session{DryRun: true, Logger: sqlWriter{m.Out}}))
+		}
+		fmt.Fprintln(m.Out, strings.TrimSpace(sql))
+		return nil
+	}
+
+	start := time.Now()
+	err := conn.Transaction(func(tx *gorm.DB) error {
+		if fn != nil {
+			if err := fn(tx); err != nil {
+				return err
+			}
+		} else if _, err := tx.Statement.ConnPool.ExecContext(tx.Statement.Context, sql); err != nil {
+			// straight to the connection, gorm would take ? and @name in the SQL for parameters
+			return err
+		}
+
+		if up {
+			return tx.Create(&SchemaMigration{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now()}).Error
+		}
+		return tx.Where("version = ?", migration.Version).Delete(&SchemaMigration{}).Error
+	})
+	if err != nil {
+		return fmt.Errorf("migration %s: %w", migration, err)
+	}
+	fmt.Fprintf(m.Out, "%s %s in %s\n", verb, migration, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// sqlWriter is a logger writing the statements of a dry run session
+type sqlWriter struct {
+	out io.Writer
+}
+
+func (w sqlWriter) LogMode(logger.LogLevel) logger.Interface      { return w }
+func (w sqlWriter) Info(context.Context, string, ...interface{})  {}
+func (w sqlWriter) Warn(context.Context, string, ...interface{})  {}
+func (w sqlWriter) Error(context.Context, string, ...interface{}) {}
+func (w sqlWriter) Trace(_ context.Context, _ time.Time, fc func() (string, int64), _ error) {
+	sql, _ := fc()
+	fmt.Fprintln(w.out, sql+";")
+}
@@ -0,0 +1,190 @@
+package migrations
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestAll(t *testing.T) {
+	migrations, err := All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 || migrations[0].Name != "baseline" {
+		t.Fatalf("migrations %v, want the baseline first", migrations)
+	}
+	for i, m := range migrations {
+		if i > 0 && m.Version <= migrations[i-1].Version {
+			t.Errorf("%s after %s", m, migrations[i-1])
+		}
+		if m.Down == nil && m.DownSQL == "" {
+			t.Errorf("%s has no down", m)
+		}
+	}
+}
+
+// testDB returns the database of TEST_DATABASE_URL without the tables of the migrations and of these tests,
+// the test is skipped without it. Point it at a scratch database.
+func testDB(t *testing.T) *gorm.DB {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tables := []string{"schema_migrations", "webhook_deliveries", "webhook_subscriptions", "audit_log",
+		"dead_letter_events", "outbox_events", "posts", "users", "migration_test"}
+	if err := db.Exec("DROP TABLE IF EXISTS " + strings.Join(tables, ", ") + " CASCADE").Error; err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestMigratorOrder(t *testing.T) {
+	db := testDB(t)
+	all := []Migration{
+		{Version: "1", Name: "create", UpSQL: "CREATE TABLE migration_test (id int);", DownSQL: "DROP TABLE migration_test;"},
+		{Version: "2", Name: "insert",
+			Up:   func(tx *gorm.DB) error { return tx.Exec("INSERT INTO migration_test VALUES (1)").Error },
+			Down: func(tx *gorm.DB) error { return tx.Exec("DELETE FROM migration_test").Error },
+		},
+		{Version: "3", Name: "add_name", UpSQL: "ALTER TABLE migration_test ADD COLUMN name text;", DownSQL: "ALTER TABLE migration_test DROP COLUMN name;"},
+	}
+	var out bytes.Buffer
+	m := &Migrator{db: db, migrations: all, Out: &out, LockID: 7263555}
+	ctx := context.Background()
+
+	applied := func(m *Migrator) string {
+		t.Helper()
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var states []string
+		for _, s := range statuses {
+			state := s.Version + ":pending"
+			switch {
+			case s.Unknown:
+				state = s.Version + ":unknown"
+			case s.AppliedAt != nil:
+				state = s.Version + ":applied"
+			}
+			states = append(states, state)
+		}
+		return strings.Join(states, " ")
+	}
+	// the lines of out without the durations of what was applied, out is reset
+	lines := func() string {
+		var lines []string
+		for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+			if strings.HasPrefix(line, "applied ") || strings.HasPrefix(line, "reverted ") {
+				line = line[:strings.LastIndex(line, " in ")]
+			}
+			lines = append(lines, line)
+		}
+		out.Reset()
+		return strings.Join(lines, "\n")
+	}
+
+	if got := applied(m); got != "1:pending 2:pending 3:pending" {
+		t.Fatalf("status before Up %s", got)
+	}
+	if n, err := m.Up(ctx); err != nil || n != 3 {
+		t.Fatalf("Up = %d, %v, want 3", n, err)
+	}
+	if got, want := lines(), "applied 1_create\napplied 2_insert\napplied 3_add_name"; got != want {
+		t.Fatalf("Up wrote\n%s\nwant\n%s", got, want)
+	}
+	if n, err := m.Up(ctx); err != nil || n != 0 {
+		t.Fatalf("second Up = %d, %v, want nothing to apply", n, err)
+	}
+
+	// Down reverts the last ones first
+	if n, err := m.Down(ctx, 2); err != nil || n != 2 {
+		t.Fatalf("Down(2) = %d, %v", n, err)
+	}
+	if got, want := lines(), "reverted 3_add_name\nreverted 2_insert"; got != want {
+		t.Fatalf("Down wrote\n%s\nwant\n%s", got, want)
+	}
+	if got := applied(m); got != "1:applied 2:pending 3:pending" {
+		t.Fatalf("status after Down %s", got)
+	}
+	var rows int64
+	if err := db.Table("migration_test").Count(&rows).Error; err != nil || rows != 0 {
+		t.Fatalf("%d rows left, %v, want the insert reverted", rows, err)
+	}
+
+	// a dry run writes the SQL of the pending ones, Go migrations included, and applies nothing
+	dryRun := &Migrator{db: db, migrations: all, Out: &out, LockID: m.LockID, DryRun: true}
+	if n, err := dryRun.Up(ctx); err != nil || n != 2 {
+		t.Fatalf("dry run Up = %d, %v, want 2", n, err)
+	}
+	want := "-- 2_insert up\nINSERT INTO migration_test VALUES (1);\n-- 3_add_name up\nALTER TABLE migration_test ADD COLUMN name text;"
+	if got := lines(); got != want {
+		t.Fatalf("dry run wrote\n%s\nwant\n%s", got, want)
+	}
+	if got := applied(m); got != "1:applied 2:pending 3:pending" {
+		t.Fatalf("status after the dry run %s", got)
+	}
+
+	// applied migrations this binary does not have are listed as unknown
+	if got := applied(&Migrator{db: db, migrations: all[1:]}); got != "1:unknown 2:pending 3:pending" {
+		t.Fatalf("status without the first migration %s", got)
+	}
+}
+
+// TestUpFromAutoMigrate runs the migrations on the schema AutoMigrate left before there were migrations
+func TestUpFromAutoMigrate(t *testing.T) {
+	db := testDB(t)
+	err := db.Exec(`
+CREATE TABLE "users" ("id" bigserial,"created_at" timestamptz,"updated_at" timestamptz,"deleted_at" timestamptz,"name" text,"email" text UNIQUE,"is_deleted" boolean,PRIMARY KEY ("id"));
+CREATE TABLE "posts" ("id" bigserial,"created_at" timestamptz,"updated_at" timestamptz,"deleted_at" timestamptz,"title" text,"content" text,"user_id" bigint,"published" boolean,"publish_date" timestamptz,PRIMARY KEY ("id"));
+CREATE TABLE "outbox_events" ("id" bigserial,"entity_type" text,"entity_id" text,"action" text,"payload" jsonb,"attempts" bigint,"last_error" text,"next_attempt_at" timestamptz,"delivered_at" timestamptz,"created_at" timestamptz,PRIMARY KEY ("id"));
+INSERT INTO "users" ("created_at","updated_at","name","email","is_deleted") VALUES (now(),now(),'ann','ann@example.com',false),(now(),now(),'bob','bob@example.com',true);
+INSERT INTO "posts" ("created_at","updated_at","title","user_id") VALUES (now(),now(),'hello',1);
+INSERT INTO "outbox_events" ("entity_type","entity_id","action","payload","next_attempt_at","created_at") VALUES ('post','1','created','{}',now(),now());
+`).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Out = &bytes.Buffer{}
+	if _, err := m.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// posts are versioned from 1 and can be updated with the version check
+	result := db.Exec(`UPDATE "posts" SET "title" = 'hi', "version" = "version" + 1 WHERE "id" = 1 AND "version" = 1`)
+	if result.Error != nil || result.RowsAffected != 1 {
+		t.Fatalf("update of the post = %d rows, %v", result.RowsAffected, result.Error)
+	}
+	var deleted []string
+	if err := db.Table("users").Where("deleted_at IS NOT NULL").Pluck("name", &deleted).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 1 || deleted[0] != "bob" || db.Migrator().HasColumn("users", "is_deleted") {
+		t.Fatalf("deleted users %v, want bob, and is_deleted dropped", deleted)
+	}
+	var missingIDs int64
+	if err := db.Table("outbox_events").Where("event_id IS NULL OR event_id = ''").Count(&missingIDs).Error; err != nil || missingIDs != 0 {
+		t.Fatalf("%d outbox events without an ID, %v", missingIDs, err)
+	}
+	for _, column := range []string{"dead_lettered_at", "target"} {
+		if !db.Migrator().HasColumn("outbox_events", column) {
+			t.Errorf("outbox_events has no %s", column)
+		}
+	}
+}
@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS "webhook_deliveries";
+DROP TABLE IF EXISTS "webhook_subscriptions";
+DROP TABLE IF EXISTS "audit_log";
+DROP TABLE IF EXISTS "dead_letter_events";
+DROP TABLE IF EXISTS "outbox_events";
+DROP TABLE IF EXISTS "posts";
+DROP TABLE IF EXISTS "users";
@@ -0,0 +1,31 @@
+-- the schema AutoMigrate kept up to date before there were migrations, existing tables get the columns added
+-- to their models since they were created
+CREATE TABLE IF NOT EXISTS "users" ("id" bigserial,"created_at" timestamptz,"updated_at" timestamptz,"deleted_at" timestamptz,"name" text,"email" text UNIQUE,PRIMARY KEY ("id"));
+CREATE INDEX IF NOT EXISTS "idx_users_deleted_at" ON "users" ("deleted_at");
+
+CREATE TABLE IF NOT EXISTS "posts" ("id" bigserial,"created_at" timestamptz,"updated_at" timestamptz,"deleted_at" timestamptz,"title" text,"content" text,"user_id" bigint,"published" boolean,"publish_date" timestamptz,"version" bigint NOT NULL DEFAULT 1,PRIMARY KEY ("id"));
+CREATE INDEX IF NOT EXISTS "idx_posts_deleted_at" ON "posts" ("deleted_at");
+ALTER TABLE "posts" ADD COLUMN IF NOT EXISTS "version" bigint NOT NULL DEFAULT 1;
+
+CREATE TABLE IF NOT EXISTS "outbox_events" ("id" bigserial,"event_id" text,"entity_type" text,"entity_id" text,"action" text,"payload" jsonb,"attempts" bigint,"last_error" text,"next_attempt_at" timestamptz,"delivered_at" timestamptz,"dead_lettered_at" timestamptz,"created_at" timestamptz,PRIMARY KEY ("id"));
+ALTER TABLE "outbox_events" ADD COLUMN IF NOT EXISTS "event_id" text;
+ALTER TABLE "outbox_events" ADD COLUMN IF NOT EXISTS "dead_lettered_at" timestamptz;
+CREATE INDEX IF NOT EXISTS "idx_outbox_events_delivered_at" ON "outbox_events" ("delivered_at");
+CREATE INDEX IF NOT EXISTS "idx_outbox_events_next_attempt_at" ON "outbox_events" ("next_attempt_at");
+
+CREATE TABLE IF NOT EXISTS "dead_letter_events" ("id" bigserial,"event_id" text,"entity_type" text,"entity_id" text,"type" text,"event" jsonb,"error" text,"replays" bigint,"replayed_at" timestamptz,"created_at" timestamptz,"updated_at" timestamptz,PRIMARY KEY ("id"));
+CREATE INDEX IF NOT EXISTS "idx_dead_letter_events_replayed_at" ON "dead_letter_events" ("replayed_at");
+CREATE INDEX IF NOT EXISTS "idx_dead_letter_entity" ON "dead_letter_events" ("entity_type","entity_id");
+CREATE INDEX IF NOT EXISTS "idx_dead_letter_events_event_id" ON "dead_letter_events" ("event_id");
+
+CREATE TABLE IF NOT EXISTS "audit_log" ("id" bigserial,"actor" text,"entity_type" text,"entity_id" text,"action" text,"before" jsonb,"after" jsonb,"diff" jsonb,"created_at" timestamptz,PRIMARY KEY ("id"));
+CREATE INDEX IF NOT EXISTS "idx_audit_log_created_at" ON "audit_log" ("created_at");
+CREATE INDEX IF NOT EXISTS "idx_audit_log_entity" ON "audit_log" ("entity_type","entity_id");
+CREATE INDEX IF NOT EXISTS "idx_audit_log_actor" ON "audit_log" ("actor");
+
+CREATE TABLE IF NOT EXISTS "webhook_subscriptions" ("id" bigserial,"url" text,"secret" text,"events" text,"retry_schedule" text,"disable_after" bigint,"disabled" boolean,"disabled_reason" text,"failures" bigint,"created_at" timestamptz,"updated_at" timestamptz,PRIMARY KEY ("id"));
+
+CREATE TABLE IF NOT EXISTS "webhook_deliveries" ("id" bigserial,"subscription_id" bigint,"event_id" text,"event_type" text,"payload" jsonb,"status" text,"attempts" bigint,"response_status" bigint,"error" text,"next_attempt_at" timestamptz,"delivered_at" timestamptz,"created_at" timestamptz,"updated_at" timestamptz,PRIMARY KEY ("id"),CONSTRAINT "fk_webhook_subscriptions_deliveries" FOREIGN KEY ("subscription_id") REFERENCES "webhook_subscriptions"("id") ON DELETE CASCADE);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_webhook_delivery_event" ON "webhook_deliveries" ("subscription_id","event_id");
+CREATE INDEX IF NOT EXISTS "idx_webhook_deliveries_next_attempt_at" ON "webhook_deliveries" ("next_attempt_at");
+CREATE INDEX IF NOT EXISTS "idx_webhook_deliveries_status" ON "webhook_deliveries" ("status");
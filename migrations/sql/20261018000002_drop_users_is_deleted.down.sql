@@ -0,0 +1 @@
+-- nothing to do, deleted_at keeps what is_deleted said
@@ -0,0 +1,8 @@
+-- users.is_deleted was replaced by the soft delete column deleted_at
+DO $$
+BEGIN
+	IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'users' AND column_name = 'is_deleted') THEN
+		UPDATE "users" SET "deleted_at" = "updated_at" WHERE "is_deleted" AND "deleted_at" IS NULL;
+		ALTER TABLE "users" DROP COLUMN "is_deleted";
+	END IF;
+END $$;
@@ -0,0 +1,3 @@
+-- every relay target gets its own row of an event, the existing ones are for the default target ''
+ALTER TABLE "outbox_events" ADD COLUMN IF NOT EXISTS "target" text NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS "idx_outbox_events_target" ON "outbox_events" ("target");
@@ -0,0 +1,4 @@
+CREATE INDEX IF NOT EXISTS "idx_outbox_events_next_attempt_at" ON "outbox_events" ("next_attempt_at");
+CREATE INDEX IF NOT EXISTS "idx_outbox_events_target" ON "outbox_events" ("target");
+DROP INDEX IF EXISTS "idx_outbox_events_dead_lettered_at";
+DROP INDEX IF EXISTS "idx_outbox_events_pending";
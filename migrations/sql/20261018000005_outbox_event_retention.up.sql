@@ -0,0 +1,5 @@
+-- the relay looks for the pending rows of its target, the purge for those delivered or dead lettered long ago
+CREATE INDEX IF NOT EXISTS "idx_outbox_events_pending" ON "outbox_events" ("target","next_attempt_at") WHERE delivered_at IS NULL AND dead_lettered_at IS NULL;
+CREATE INDEX IF NOT EXISTS "idx_outbox_events_dead_lettered_at" ON "outbox_events" ("dead_lettered_at");
+DROP INDEX IF EXISTS "idx_outbox_events_target";
+DROP INDEX IF EXISTS "idx_outbox_events_next_attempt_at";
@@ -0,0 +1 @@
+-- nothing to do, the deleted rows were only for the change streams of the time
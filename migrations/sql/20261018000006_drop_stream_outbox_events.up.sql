@@ -0,0 +1,2 @@
+-- the change streams get the changes through pg_notify, nothing relays the rows of the stream target anymore
+DELETE FROM "outbox_events" WHERE "target" = 'stream';
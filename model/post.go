@@ -9,11 +9,12 @@ import (
 
 type Post struct {
 	gorm.Model
-	Title       string
-	Content     string
-	UserID      uint // Foreign key for User
+	Title       string `validate:"required,max=200"`
+	Content     string `validate:"max=10000"`
+	UserID      uint   `validate:"required,user_exists"` // Foreign key for User
 	Published   bool
 	PublishDate *time.Time
+	Version     uint `gorm:"not null;default:1"` // optimistic locking, sent as ETag
 }
 
 // BeforeCreate will set a record's publish date to now
@@ -4,8 +4,7 @@ import "gorm.io/gorm"
 
 type User struct {
 	gorm.Model
-	Name      string
-	Email     string `gorm:"unique"`
-	IsDeleted bool
+	Name  string `validate:"required,max=100"`
+	Email string `gorm:"unique" validate:"required,email,max=254"`
 	// Add other fields as needed
 }
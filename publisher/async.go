@@ -0,0 +1,194 @@
+package publisher
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrQueueFull is returned by an Async with the Fail policy while its queue is full
+	ErrQueueFull = errors.New("publisher: queue full")
+	// ErrClosed is returned by an Async after Shutdown
+	ErrClosed = errors.New("publisher: closed")
+)
+
+// OverflowPolicy is what Async does with an event when its queue is full
+type OverflowPolicy string
+
+const (
+	Block      OverflowPolicy = "block"       // wait until there is room, slowing the writer down
+	DropOldest OverflowPolicy = "drop_oldest" // drop the oldest queued event to make room, see Dropped
+	Fail       OverflowPolicy = "fail"        // return ErrQueueFull
+)
+
+// Async queues events and publishes them to next in the background, in batches of up to BatchSize events
+// or whatever arrived within FlushInterval. Publish only fails when the queue is full (with Fail) or closed,
+// errors of next go to OnError. Set the fields before the first Publish and call Shutdown before exiting,
+// it publishes what is still queued.
+type Async struct {
+	next  Publisher
+	queue chan Event
+
+	BatchSize     int
+	FlushInterval time.Duration
+	Overflow      OverflowPolicy
+	OnError       func(events []Event, err error) // logs by default
+
+	start   sync.Once
+	mu      sync.RWMutex // guards closed
+	closed  bool
+	closing chan struct{}  // closed by Shutdown, ends the Publish calls waiting for room
+	sending sync.WaitGroup // Publish calls between the closed check and the queue, Shutdown waits for them
+	done    chan struct{}
+	dropped atomic.Int64
+}
+
+// NewAsync returns an Async holding up to queueSize events, with the Block policy
+func NewAsync(next Publisher, queueSize int) *Async {
+	return &Async{
+		next:          next,
+		queue:         make(chan Event, queueSize),
+		BatchSize:     100,
+		FlushInterval: 100 * time.Millisecond,
+		Overflow:      Block,
+		OnError: func(events []Event, err error) {
+			log.Printf("publisher: %d events lost: %v", len(events), err)
+		},
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+func (a *Async) Publish(event Event) error {
+	a.start.Do(func() { go a.run() })
+
+	// the queue is closed once no Publish is sending to it, a full one is not waited for holding the lock
+	a.mu.RLock()
+	if a.closed {
+		a.mu.RUnlock()
+		return ErrClosed
+	}
+	a.sending.Add(1)
+	a.mu.RUnlock()
+	defer a.sending.Done()
+
+	switch a.Overflow {
+	case Fail:
+		select {
+		case a.queue <- event:
+			return nil
+		default:
+			return ErrQueueFull
+		}
+	case DropOldest:
+		for {
+			select {
+			case a.queue <- event:
+				return nil
+			default:
+			}
+			select {
+			case <-a.queue:
+				a.dropped.Add(1)
+			default: // the worker took one meanwhile
+			}
+		}
+	default:
+		select {
+		case a.queue <- event:
+			return nil
+		case <-a.closing:
+			return ErrClosed
+		}
+	}
+}
+
+// PublishBatch queues every event, it stops at the first that cannot be queued
+func (a *Async) PublishBatch(events []Event) error {
+	for _, event := range events {
+		if err := a.Publish(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Dropped returns how many events DropOldest has dropped
+func (a *Async) Dropped() int64 {
+	return a.dropped.Load()
+}
+
+// Len returns how many events are waiting in the queue
+func (a *Async) Len() int {
+	return len(a.queue)
+}
+
+// Shutdown stops accepting events and waits until the queued ones are published, or ctx is done.
+// A Publish waiting for room in the queue returns ErrClosed.
+func (a *Async) Shutdown(ctx context.Context) error {
+	a.start.Do(func() { go a.run() })
+
+	a.mu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.closing)
+		go func() {
+			a.sending.Wait()
+			close(a.queue)
+		}()
+	}
+	a.mu.Unlock()
+
+	select {
+	case <-a.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close shuts down without a deadline and closes next when it holds a connection
+func (a *Async) Close() error {
+	if err := a.Shutdown(context.Background()); err != nil {
+		return err
+	}
+	return Close(a.next)
+}
+
+func (a *Async) run() {
+	defer close(a.done)
+
+	ticker := time.NewTicker(a.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]Event, 0, a.BatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := PublishAll(a.next, batch); err != nil {
+			a.OnError(append([]Event{}, batch...), err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case event, ok := <-a.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, event)
+			if len(batch) >= a.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
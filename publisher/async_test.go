@@ -0,0 +1,164 @@
+package publisher
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// blockedAsync returns an Async of size 1 in front of rec whose worker is stuck publishing event 1 until
+// release is closed, so the queue fills up
+func blockedAsync(t *testing.T, overflow OverflowPolicy, rec *Recorder) (*Async, chan struct{}) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	a := NewAsync(publishFunc(func(event Event) error {
+		if event.Subject == "1" {
+			started <- struct{}{}
+			<-release
+		}
+		return rec.Publish(event)
+	}), 1)
+	a.BatchSize, a.Overflow = 1, overflow
+
+	if err := a.Publish(numbered(1)); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	if err := a.Publish(numbered(2)); err != nil {
+		t.Fatal(err)
+	}
+	return a, release
+}
+
+func numbered(n int) Event {
+	return NewEvent(post{ID: uint(n)}, Created, strconv.Itoa(n))
+}
+
+func subjects(events []Event) string {
+	var subjects []string
+	for _, event := range events {
+		subjects = append(subjects, event.Subject)
+	}
+	return strings.Join(subjects, " ")
+}
+
+func shutdown(t *testing.T, a *Async) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := a.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAsyncOverflow(t *testing.T) {
+	t.Run("fail", func(t *testing.T) {
+		rec := &Recorder{}
+		a, release := blockedAsync(t, Fail, rec)
+		if err := a.Publish(numbered(3)); !errors.Is(err, ErrQueueFull) {
+			t.Fatalf("Publish to a full queue = %v, want ErrQueueFull", err)
+		}
+		close(release)
+		shutdown(t, a)
+		if got := subjects(rec.Events()); got != "1 2" {
+			t.Fatalf("published %s, want 1 2", got)
+		}
+	})
+
+	t.Run("drop oldest", func(t *testing.T) {
+		rec := &Recorder{}
+		a, release := blockedAsync(t, DropOldest, rec)
+		if err := a.Publish(numbered(3)); err != nil {
+			t.Fatal(err)
+		}
+		close(release)
+		shutdown(t, a)
+		if got := subjects(rec.Events()); got != "1 3" || a.Dropped() != 1 {
+			t.Fatalf("published %s with %d dropped, want 1 3 and 2 dropped", got, a.Dropped())
+		}
+	})
+
+	t.Run("block", func(t *testing.T) {
+		rec := &Recorder{}
+		a, release := blockedAsync(t, Block, rec)
+		published := make(chan error, 1)
+		go func() { published <- a.Publish(numbered(3)) }()
+		select {
+		case err := <-published:
+			t.Fatalf("Publish to a full queue returned %v, want it to wait", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+		close(release)
+		if err := <-published; err != nil {
+			t.Fatal(err)
+		}
+		shutdown(t, a)
+		if got := subjects(rec.Events()); got != "1 2 3" {
+			t.Fatalf("published %s, want 1 2 3", got)
+		}
+	})
+}
+
+func TestAsyncShutdown(t *testing.T) {
+	// what is queued is published in batches on Shutdown, without waiting for the flush interval
+	rec := &Recorder{}
+	a := NewAsync(rec, 10)
+	a.BatchSize, a.FlushInterval = 3, time.Hour
+	for n := 1; n <= 5; n++ {
+		if err := a.Publish(numbered(n)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	shutdown(t, a)
+	if got := subjects(rec.Events()); got != "1 2 3 4 5" || rec.Calls() != 2 {
+		t.Fatalf("published %s in %d calls, want 1 2 3 4 5 in 2 batches", got, rec.Calls())
+	}
+	if err := a.Publish(numbered(6)); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Publish after Shutdown = %v, want ErrClosed", err)
+	}
+
+	// failed batches go to OnError
+	a = NewAsync(&Recorder{Err: errDown}, 10)
+	var lost []Event
+	a.OnError = func(events []Event, err error) {
+		if errors.Is(err, errDown) {
+			lost = append(lost, events...)
+		}
+	}
+	a.PublishBatch([]Event{numbered(1), numbered(2)})
+	shutdown(t, a)
+	if got := subjects(lost); got != "1 2" {
+		t.Fatalf("OnError got %s, want 1 2", got)
+	}
+}
+
+func TestAsyncShutdownDeadline(t *testing.T) {
+	rec := &Recorder{}
+	a, release := blockedAsync(t, Block, rec)
+	defer close(release)
+
+	published := make(chan error, 1)
+	go func() { published <- a.Publish(numbered(3)) }()
+	time.Sleep(50 * time.Millisecond)
+
+	// the worker is stuck: Shutdown gives up at the deadline and the waiting Publish is closed
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := a.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown = %v, want the deadline", err)
+	}
+	select {
+	case err := <-published:
+		if !errors.Is(err, ErrClosed) {
+			t.Fatalf("waiting Publish = %v, want ErrClosed", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Publish still waits after Shutdown")
+	}
+	if a.Len() != 1 {
+		t.Fatalf("%d events queued, want 2 left for the worker", a.Len())
+	}
+}
@@ -0,0 +1,49 @@
+package publisher
+
+import "gorm.io/gorm"
+
+// BatchPublisher is implemented by publishers that send many events in one call, e.g. one request to a broker.
+// Bulk repository operations publish through it instead of calling Publish per row.
+type BatchPublisher interface {
+	Publisher
+	PublishBatch(events []Event) error // an error means some of events may not have been delivered
+}
+
+// TxBatchPublisher is the TxPublisher counterpart of BatchPublisher
+type TxBatchPublisher interface {
+	TxPublisher
+	PublishBatchTx(tx *gorm.DB, events []Event) error
+}
+
+// PublishAll sends events with a single PublishBatch call when p is a BatchPublisher, one by one otherwise,
+// stopping at the first error
+func PublishAll(p Publisher, events []Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	if batch, ok := p.(BatchPublisher); ok && len(events) > 1 {
+		return batch.PublishBatch(events)
+	}
+	for _, event := range events {
+		if err := p.Publish(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PublishAllTx is PublishAll for a TxPublisher, writing through tx
+func PublishAllTx(p TxPublisher, tx *gorm.DB, events []Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	if batch, ok := p.(TxBatchPublisher); ok && len(events) > 1 {
+		return batch.PublishBatchTx(tx, events)
+	}
+	for _, event := range events {
+		if err := p.PublishTx(tx, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package publisher
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/segmentio/kafka-go"
+)
+
+type post struct {
+	ID    uint
+	Title string
+}
+
+var cloudEvents = BrokerConfig{Prefix: "test", Encoding: EncodingCloudEvents, Timeout: time.Second}
+
+// checkCloudEvent checks a message body published for event with the cloudEvents config
+func checkCloudEvent(t *testing.T, event Event, contentType string, body []byte) {
+	t.Helper()
+	if contentType != "application/cloudevents+json" {
+		t.Errorf("content type %q, want application/cloudevents+json", contentType)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatalf("body %s: %v", body, err)
+	}
+	want := map[string]interface{}{
+		"specversion": "1.0",
+		"id":          event.ID,
+		"type":        "test.post.updated",
+		"source":      "/test/post",
+		"subject":     "7",
+		"entitytype":  "post",
+		"action":      "updated",
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("%s = %v, want %v", key, got[key], value)
+		}
+	}
+	if data, _ := got["data"].(map[string]interface{}); data["Title"] != "hello" {
+		t.Errorf("data = %v, want the post", got["data"])
+	}
+}
+
+func testEvent() Event {
+	return NewEvent(post{ID: 7, Title: "hello"}, Updated, "7")
+}
+
+// fakeKafka records the messages written to it
+type fakeKafka struct {
+	messages []kafka.Message
+}
+
+func (w *fakeKafka) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	w.messages = append(w.messages, msgs...)
+	return nil
+}
+
+func (w *fakeKafka) Close() error { return nil }
+
+func TestKafkaPublisher(t *testing.T) {
+	writer := &fakeKafka{}
+	p := &KafkaPublisher{writer: writer, encoder: encoder{"test", EncodingCloudEvents}, timeout: time.Second}
+
+	event := testEvent()
+	if err := p.Publish(event); err != nil {
+		t.Fatal(err)
+	}
+	if len(writer.messages) != 1 {
+		t.Fatalf("wrote %d messages, want 1", len(writer.messages))
+	}
+	m := writer.messages[0]
+	if m.Topic != "test.post.updated" {
+		t.Errorf("topic %q, want test.post.updated", m.Topic)
+	}
+	if string(m.Key) != "7" {
+		t.Errorf("key %q, want the entity ID 7", m.Key)
+	}
+	headers := map[string]string{}
+	for _, h := range m.Headers {
+		headers[h.Key] = string(h.Value)
+	}
+	if headers[HeaderEventID] != event.ID || headers[HeaderEntityID] != "7" {
+		t.Errorf("headers %v", headers)
+	}
+	checkCloudEvent(t, event, headers["content-type"], m.Value)
+}
+
+// fakeChannel records the published messages and confirms them with ack
+type fakeChannel struct {
+	ack       bool
+	exchanges []string
+	keys      []string
+	messages  []amqp.Publishing
+}
+
+type fakeConfirmation bool
+
+func (c fakeConfirmation) WaitContext(ctx context.Context) (bool, error) { return bool(c), nil }
+
+func (c *fakeChannel) publish(ctx context.Context, exchange, key string, msg amqp.Publishing) (confirmation, error) {
+	c.exchanges = append(c.exchanges, exchange)
+	c.keys = append(c.keys, key)
+	c.messages = append(c.messages, msg)
+	return fakeConfirmation(c.ack), nil
+}
+
+func TestAMQPPublisher(t *testing.T) {
+	channel := &fakeChannel{ack: true}
+	p := &AMQPPublisher{channel: channel, exchange: "test", encoder: encoder{"test", EncodingCloudEvents}, timeout: time.Second}
+
+	event := testEvent()
+	if err := p.Publish(event); err != nil {
+		t.Fatal(err)
+	}
+	if len(channel.messages) != 1 {
+		t.Fatalf("published %d messages, want 1", len(channel.messages))
+	}
+	if channel.exchanges[0] != "test" || channel.keys[0] != "test.post.updated" {
+		t.Errorf("published to %s with routing key %s, want test and test.post.updated", channel.exchanges[0], channel.keys[0])
+	}
+	m := channel.messages[0]
+	if m.MessageId != event.ID || m.Headers[HeaderEntityID] != "7" || m.DeliveryMode != amqp.Persistent {
+		t.Errorf("message %+v", m)
+	}
+	checkCloudEvent(t, event, m.ContentType, m.Body)
+
+	channel.ack = false
+	if err := p.Publish(event); err == nil {
+		t.Fatal("no error for a message the broker did not confirm")
+	}
+}
+
+// natsServer speaks just enough of the NATS protocol for a publisher: it answers PINGs and passes on what
+// is published with HPUB
+func natsServer(t *testing.T) (string, <-chan *nats.Msg) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	msgs := make(chan *nats.Msg, 10)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		fmt.Fprintf(conn, "INFO {\"server_id\":\"test\",\"version\":\"2.10.0\",\"proto\":1,\"headers\":true,\"max_payload\":1048576}\r\n")
+
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			fields := strings.Fields(line)
+			switch {
+			case len(fields) == 0:
+			case fields[0] == "PING":
+				fmt.Fprint(conn, "PONG\r\n")
+			case fields[0] == "HPUB" && len(fields) == 4: // HPUB <subject> <header size> <total size>
+				headerSize, _ := strconv.Atoi(fields[2])
+				size, _ := strconv.Atoi(fields[3])
+				data := make([]byte, size+2) // and \r\n
+				if _, err := io.ReadFull(r, data); err != nil {
+					return
+				}
+				header, err := nats.DecodeHeadersMsg(data[:headerSize])
+				if err != nil {
+					t.Error(err)
+				}
+				msgs <- &nats.Msg{Subject: fields[1], Header: header, Data: data[headerSize:size]}
+			}
+		}
+	}()
+	return "nats://" + ln.Addr().String(), msgs
+}
+
+func TestNATSPublisher(t *testing.T) {
+	url, msgs := natsServer(t)
+	cfg := cloudEvents
+	cfg.URL = url
+	p, err := DialNATS(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	event := testEvent()
+	if err := p.Publish(event); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-msgs:
+		if msg.Subject != "test.post.updated" {
+			t.Errorf("subject %q, want test.post.updated", msg.Subject)
+		}
+		if msg.Header.Get(nats.MsgIdHdr) != event.ID || msg.Header.Get(HeaderEntityID) != "7" {
+			t.Errorf("header %v", msg.Header)
+		}
+		checkCloudEvent(t, event, msg.Header.Get("Content-Type"), msg.Data)
+	case <-time.After(time.Second):
+		t.Fatal("nothing published")
+	}
+}
@@ -0,0 +1,267 @@
+package publisher
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"sync"
+)
+
+// Bus delivers events to handlers subscribed in-process with Subscribe, without a broker.
+// Sync handlers run inside Publish, which returns their errors. Async handlers (WithAsync) run on Workers
+// background goroutines; the events of one entity always go to the same one, so they are handled in the order
+// they were published. A panicking handler only fails itself. Set the fields before the first Publish.
+type Bus struct {
+	Workers   int                          // goroutines running async handlers
+	QueueSize int                          // events waiting per worker, Publish blocks beyond
+	OnError   func(event Event, err error) // errors of async handlers, logged by default
+
+	mu     sync.RWMutex
+	subs   []*subscription
+	nextID int
+	closed bool
+
+	start   sync.Once
+	queues  []chan delivery
+	done    chan struct{}  // closed by Shutdown, ends the sends waiting for room in a queue
+	sending sync.WaitGroup // Publish calls sending to a queue
+	running sync.WaitGroup
+}
+
+type subscription struct {
+	id         int
+	entityType string
+	action     Action // empty for every action
+	async      bool
+	handle     func(event Event) error
+}
+
+// delivery is an event for the async handlers subscribed to it
+type delivery struct {
+	event Event
+	subs  []*subscription
+}
+
+func NewBus() *Bus {
+	return &Bus{
+		Workers:   4,
+		QueueSize: 1000,
+		OnError: func(event Event, err error) {
+			log.Printf("bus: %s %s: %v", event.Type, event.Subject, err)
+		},
+	}
+}
+
+// SubscribeOption configures a subscription
+type SubscribeOption func(*subscription)
+
+// WithAsync runs the handler in the background instead of inside Publish
+func WithAsync() SubscribeOption {
+	return func(s *subscription) {
+		s.async = true
+	}
+}
+
+// Subscribe calls handler with the entity of every event about an E with action, of every action when it is empty.
+// It returns a function that ends the subscription.
+//
+//	publisher.Subscribe(bus, publisher.Created, func(event publisher.Event, user model.User) error {
+//		return mailer.Welcome(user.Email)
+//	}, publisher.WithAsync())
+func Subscribe[E any](bus *Bus, action Action, handler func(event Event, entity E) error, opts ...SubscribeOption) (unsubscribe func()) {
+	sub := &subscription{
+		entityType: EntityType(new(E)),
+		action:     action,
+		handle: func(event Event) error {
+			entity, err := decodeEntity[E](event.Data)
+			if err != nil {
+				return fmt.Errorf("cannot decode %s: %w", event.EntityType, err)
+			}
+			return handler(event, entity)
+		},
+	}
+	for _, opt := range opts {
+		opt(sub)
+	}
+
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	bus.nextID++
+	sub.id = bus.nextID
+	bus.subs = append(bus.subs, sub)
+
+	return func() {
+		bus.mu.Lock()
+		defer bus.mu.Unlock()
+		for i, s := range bus.subs {
+			if s.id == sub.id {
+				bus.subs = append(bus.subs[:i:i], bus.subs[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+func (b *Bus) Publish(event Event) error {
+	b.start.Do(b.startWorkers)
+
+	// handlers run without the lock, they may subscribe or publish themselves
+	b.mu.RLock()
+	closed := b.closed
+	subs := append([]*subscription{}, b.subs...)
+	b.mu.RUnlock()
+	if closed {
+		return ErrClosed
+	}
+
+	var async []*subscription
+	var errs []error
+	for _, sub := range subs {
+		if sub.entityType != event.EntityType || (sub.action != "" && sub.action != event.Action) {
+			continue
+		}
+		if sub.async {
+			async = append(async, sub)
+			continue
+		}
+		if err := sub.call(event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(async) > 0 {
+		if err := b.enqueue(delivery{event: event, subs: async}); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// enqueue hands d to the worker of its entity, waiting while the queue is full. It waits without the lock,
+// so meanwhile handlers can subscribe and Shutdown can end the wait.
+func (b *Bus) enqueue(d delivery) error {
+	b.mu.RLock()
+	if b.closed {
+		b.mu.RUnlock()
+		return ErrClosed
+	}
+	b.sending.Add(1)
+	b.mu.RUnlock()
+	defer b.sending.Done()
+
+	select {
+	case b.queues[b.worker(d.event)] <- d:
+		return nil
+	case <-b.done:
+		return ErrClosed
+	}
+}
+
+// PublishBatch publishes every event, the errors of all of them are returned
+func (b *Bus) PublishBatch(events []Event) error {
+	var errs []error
+	for _, event := range events {
+		if err := b.Publish(event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Shutdown stops accepting events and waits until the async handlers handled the queued ones, or ctx is done
+func (b *Bus) Shutdown(ctx context.Context) error {
+	b.start.Do(b.startWorkers)
+
+	b.mu.Lock()
+	closing := !b.closed
+	if closing {
+		b.closed = true
+		close(b.done)
+	}
+	b.mu.Unlock()
+
+	// the queues are closed once no Publish sends to them anymore
+	if closing {
+		b.sending.Wait()
+		for _, queue := range b.queues {
+			close(queue)
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		b.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close shuts down without a deadline
+func (b *Bus) Close() error {
+	return b.Shutdown(context.Background())
+}
+
+func (b *Bus) startWorkers() {
+	b.queues = make([]chan delivery, b.Workers)
+	b.done = make(chan struct{})
+	for i := range b.queues {
+		b.queues[i] = make(chan delivery, b.QueueSize)
+		b.running.Add(1)
+		go func(queue chan delivery) {
+			defer b.running.Done()
+			for d := range queue {
+				for _, sub := range d.subs {
+					if err := sub.call(d.event); err != nil {
+						b.OnError(d.event, err)
+					}
+				}
+			}
+		}(b.queues[i])
+	}
+}
+
+// worker picks the worker of the entity of event
+func (b *Bus) worker(event Event) int {
+	h := fnv.New32a()
+	h.Write([]byte(event.EntityType + "/" + event.Subject))
+	return int(h.Sum32() % uint32(len(b.queues)))
+}
+
+// call runs the handler, turning a panic into an error
+func (s *subscription) call(event Event) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("handler panicked: %v", p)
+		}
+	}()
+	return s.handle(event)
+}
+
+// decodeEntity returns data as an E, data relayed from the outbox is JSON
+func decodeEntity[E any](data interface{}) (E, error) {
+	var entity E
+	switch d := data.(type) {
+	case E:
+		return d, nil
+	case *E:
+		if d != nil {
+			return *d, nil
+		}
+		return entity, nil
+	case json.RawMessage:
+		return entity, json.Unmarshal(d, &entity)
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return entity, err
+	}
+	return entity, json.Unmarshal(raw, &entity)
+}
@@ -0,0 +1,88 @@
+package publisher
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBusAsyncHandlerSubscribes(t *testing.T) {
+	bus := NewBus()
+	bus.Workers, bus.QueueSize = 1, 1
+
+	handled := make(chan uint, 10)
+	release := make(chan struct{})
+	Subscribe(bus, Created, func(event Event, p post) error {
+		<-release
+		// subscribing takes the lock a Publish waiting for room in the full queue must not hold
+		Subscribe(bus, Deleted, func(event Event, p post) error { return nil })
+		handled <- p.ID
+		return nil
+	}, WithAsync())
+
+	published := make(chan error, 1)
+	go func() {
+		// the first is handled, the second queued and the third waits for room
+		for id := uint(1); id <= 3; id++ {
+			if err := bus.Publish(NewEvent(post{ID: id}, Created, "1")); err != nil {
+				published <- err
+				return
+			}
+		}
+		published <- nil
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	select {
+	case err := <-published:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Publish and the handler deadlocked")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := bus.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(handled) != 3 {
+		t.Fatalf("handled %d events, want 3", len(handled))
+	}
+}
+
+func TestBusShutdownEndsWaitingPublish(t *testing.T) {
+	bus := NewBus()
+	bus.Workers, bus.QueueSize = 1, 1
+
+	release := make(chan struct{})
+	defer close(release)
+	Subscribe(bus, Created, func(event Event, p post) error {
+		<-release
+		return nil
+	}, WithAsync())
+
+	published := make(chan error, 1)
+	go func() {
+		var err error
+		for id := uint(1); id <= 3 && err == nil; id++ {
+			err = bus.Publish(NewEvent(post{ID: id}, Created, "1"))
+		}
+		published <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	bus.Shutdown(ctx) // the handler is still blocked
+	select {
+	case err := <-published:
+		if !errors.Is(err, ErrClosed) {
+			t.Fatalf("Publish = %v, want ErrClosed", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Publish still waits after Shutdown")
+	}
+}
@@ -0,0 +1,74 @@
+package publisher
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by a CircuitBreaker while it does not call its publisher
+var ErrCircuitOpen = errors.New("publisher: circuit open")
+
+// CircuitBreaker stops calling next after Threshold consecutive failures and fails fast with ErrCircuitOpen
+// for Cooldown. Then a single call is let through: its success closes the circuit, its failure opens it again.
+type CircuitBreaker struct {
+	next Publisher
+
+	Threshold int
+	Cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool // the call after the cooldown is running
+}
+
+func NewCircuitBreaker(next Publisher) *CircuitBreaker {
+	return &CircuitBreaker{next: next, Threshold: 5, Cooldown: 30 * time.Second}
+}
+
+func (c *CircuitBreaker) Publish(event Event) error {
+	return c.call(func() error { return c.next.Publish(event) })
+}
+
+func (c *CircuitBreaker) PublishBatch(events []Event) error {
+	return c.call(func() error { return PublishAll(c.next, events) })
+}
+
+// Open reports whether calls currently fail fast
+func (c *CircuitBreaker) Open() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.probing || time.Now().Before(c.openUntil)
+}
+
+func (c *CircuitBreaker) call(publish func() error) error {
+	c.mu.Lock()
+	if c.probing || time.Now().Before(c.openUntil) {
+		c.mu.Unlock()
+		return ErrCircuitOpen
+	}
+	probe := c.failures >= c.Threshold // the cooldown is over
+	c.probing = probe
+	c.mu.Unlock()
+
+	err := publish()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.probing = false
+	if err == nil {
+		c.failures = 0
+		return nil
+	}
+	c.failures++
+	if c.failures >= c.Threshold {
+		c.openUntil = time.Now().Add(c.Cooldown)
+	}
+	return err
+}
+
+// Close closes next when it holds a connection
+func (c *CircuitBreaker) Close() error {
+	return Close(c.next)
+}
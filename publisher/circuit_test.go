@@ -0,0 +1,78 @@
+package publisher
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	fail := true
+	calls := 0
+	var probing chan struct{} // closed by a call that then waits for release
+	release := make(chan struct{})
+	c := NewCircuitBreaker(publishFunc(func(Event) error {
+		calls++
+		if probing != nil {
+			close(probing)
+			<-release
+		}
+		if fail {
+			return errDown
+		}
+		return nil
+	}))
+	c.Threshold, c.Cooldown = 2, 50*time.Millisecond
+
+	publish := func(want error) {
+		t.Helper()
+		if err := c.Publish(testEvent()); !errors.Is(err, want) {
+			t.Fatalf("Publish = %v, want %v", err, want)
+		}
+	}
+
+	// closed until Threshold failures in a row
+	publish(errDown)
+	if c.Open() {
+		t.Fatal("open after one failure")
+	}
+	publish(errDown)
+	publish(ErrCircuitOpen)
+	if !c.Open() || calls != 2 {
+		t.Fatalf("open %v after %d calls, want open after 2", c.Open(), calls)
+	}
+
+	// after the cooldown one call probes, and opens the circuit again when it fails
+	time.Sleep(60 * time.Millisecond)
+	if c.Open() {
+		t.Fatal("still open after the cooldown")
+	}
+	publish(errDown)
+	publish(ErrCircuitOpen)
+	if calls != 3 {
+		t.Fatalf("%d calls, want the probe alone", calls)
+	}
+
+	// while the probe runs the others still fail fast, its success closes the circuit
+	time.Sleep(60 * time.Millisecond)
+	fail, probing = false, make(chan struct{})
+	result := make(chan error)
+	go func() { result <- c.Publish(testEvent()) }()
+	<-probing
+	publish(ErrCircuitOpen)
+	close(release)
+	if err := <-result; err != nil {
+		t.Fatalf("probe = %v", err)
+	}
+	probing = nil
+	if c.Open() {
+		t.Fatal("open after the probe succeeded")
+	}
+
+	// and forgets the failures before
+	fail = true
+	publish(errDown)
+	if c.Open() {
+		t.Fatal("open after one failure following a success")
+	}
+}
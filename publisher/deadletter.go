@@ -0,0 +1,127 @@
+package publisher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DeadLetter is an event that could not be delivered, waiting in the dead_letter_events table to be replayed
+type DeadLetter struct {
+	ID         uint   `gorm:"primarykey"`
+	EventID    string `gorm:"index"`
+	EntityType string `gorm:"index:idx_dead_letter_entity"`
+	EntityID   string `gorm:"index:idx_dead_letter_entity"`
+	Type       string
+	Event      []byte     `gorm:"type:jsonb"` // the whole Event
+	Error      string     // why the last attempt failed
+	Replays    int        // failed replays
+	ReplayedAt *time.Time `gorm:"index"` // set once a replay delivered it
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+func (DeadLetter) TableName() string {
+	return "dead_letter_events"
+}
+
+// MarshalJSON writes the event as JSON instead of base64
+func (d DeadLetter) MarshalJSON() ([]byte, error) {
+	type deadLetter DeadLetter // without this method
+	event := json.RawMessage(d.Event)
+	if len(event) == 0 {
+		event = json.RawMessage("null")
+	}
+	return json.Marshal(struct {
+		deadLetter
+		Event json.RawMessage
+	}{deadLetter(d), event})
+}
+
+// DeadLetterStore keeps events that exhausted their retries and publishes them to target again on Replay
+type DeadLetterStore struct {
+	db     *gorm.DB
+	target Publisher
+}
+
+func NewDeadLetterStore(db *gorm.DB, target Publisher) *DeadLetterStore {
+	return &DeadLetterStore{db: db, target: target}
+}
+
+// Add stores events that failed with cause
+func (s *DeadLetterStore) Add(events []Event, cause error) error {
+	return s.AddTx(s.db, events, cause)
+}
+
+// AddTx is Add through tx, e.g. the transaction that gives up on the events
+func (s *DeadLetterStore) AddTx(tx *gorm.DB, events []Event, cause error) error {
+	if len(events) == 0 {
+		return nil
+	}
+	rows := make([]DeadLetter, len(events))
+	for i, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		rows[i] = DeadLetter{
+			EventID:    event.ID,
+			EntityType: event.EntityType,
+			EntityID:   event.Subject,
+			Type:       event.Type,
+			Event:      data,
+			Error:      cause.Error(),
+		}
+	}
+	return tx.Session(&gorm.Session{NewDB: true}).Create(&rows).Error
+}
+
+// Replay publishes the dead letters with ids that were not replayed yet, all of them without ids, in the order
+// they were added, and returns how many were delivered. It stops at the first failure, which is recorded
+// on the dead letter and returned.
+func (s *DeadLetterStore) Replay(ctx context.Context, ids ...uint) (int, error) {
+	replayed := 0
+	var publishErr error
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		query := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("replayed_at IS NULL").
+			Order("id")
+		if len(ids) > 0 {
+			query = query.Where("id IN ?", ids)
+		}
+		var rows []DeadLetter
+		if err := query.Find(&rows).Error; err != nil {
+			return err
+		}
+
+		for _, row := range rows {
+			var event Event
+			if err := json.Unmarshal(row.Event, &event); err != nil {
+				return fmt.Errorf("dead letter %d: %w", row.ID, err)
+			}
+
+			if publishErr = s.target.Publish(event); publishErr != nil {
+				return tx.Model(&DeadLetter{}).Where("id = ?", row.ID).Updates(map[string]interface{}{
+					"replays": row.Replays + 1,
+					"error":   publishErr.Error(),
+				}).Error
+			}
+
+			now := time.Now()
+			if err := tx.Model(&DeadLetter{}).Where("id = ?", row.ID).Update("replayed_at", now).Error; err != nil {
+				return err
+			}
+			replayed++
+		}
+		return nil
+	})
+	if err != nil {
+		return replayed, err
+	}
+	return replayed, publishErr
+}
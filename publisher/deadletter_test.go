@@ -0,0 +1,68 @@
+package publisher
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestDeadLetterReplay(t *testing.T) {
+	db := testDB(t, &DeadLetter{})
+	var events []Event
+	for id := 1; id <= 3; id++ {
+		events = append(events, NewEvent(post{ID: uint(id)}, Created, strconv.Itoa(id)))
+	}
+
+	// the second replay fails, once
+	rec := &Recorder{}
+	calls := 0
+	store := NewDeadLetterStore(db, publishFunc(func(event Event) error {
+		if calls++; calls == 2 {
+			return errDown
+		}
+		return rec.Publish(event)
+	}))
+	if err := store.Add(events, errors.New("timeout")); err != nil {
+		t.Fatal(err)
+	}
+
+	letters := func() []DeadLetter {
+		t.Helper()
+		var letters []DeadLetter
+		if err := db.Order("id").Find(&letters).Error; err != nil {
+			t.Fatal(err)
+		}
+		return letters
+	}
+
+	if replayed, err := store.Replay(context.Background()); !errors.Is(err, errDown) || replayed != 1 {
+		t.Fatalf("Replay = %d, %v, want 1 and the failure", replayed, err)
+	}
+	l := letters()
+	if l[0].ReplayedAt == nil || l[1].ReplayedAt != nil || l[1].Replays != 1 || l[1].Error != errDown.Error() {
+		t.Fatalf("after the failed replay %+v, want the first replayed and the failure recorded on the second", l)
+	}
+	if l[2].ReplayedAt != nil || l[2].Replays != 0 || calls != 2 {
+		t.Fatalf("after %d calls the third is %+v, want it not tried", calls, l[2])
+	}
+
+	// the next replay starts at the failed one, IDs select some
+	if replayed, err := store.Replay(context.Background(), l[2].ID); err != nil || replayed != 1 {
+		t.Fatalf("Replay(%d) = %d, %v", l[2].ID, replayed, err)
+	}
+	if replayed, err := store.Replay(context.Background()); err != nil || replayed != 1 {
+		t.Fatalf("Replay = %d, %v, want the second", replayed, err)
+	}
+	var subjects []string
+	for _, event := range rec.Events() {
+		subjects = append(subjects, event.Subject)
+	}
+	if got := strings.Join(subjects, " "); got != "1 3 2" {
+		t.Fatalf("replayed %s, want 1 3 2", got)
+	}
+	if replayed, err := store.Replay(context.Background()); err != nil || replayed != 0 {
+		t.Fatalf("Replay = %d, %v, want nothing left", replayed, err)
+	}
+}
@@ -0,0 +1,90 @@
+package publisher
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Action is what happened to an entity, the last part of an event type
+type Action string
+
+const (
+	Created  Action = "created"
+	Updated  Action = "updated"
+	Upserted Action = "upserted" // created or updated by a bulk upsert
+	Deleted  Action = "deleted"  // soft deleted, it can be restored
+	Restored Action = "restored"
+	Purged   Action = "purged" // removed for good
+)
+
+// Actions are all actions in the order of a life cycle
+var Actions = []Action{Created, Updated, Upserted, Deleted, Restored, Purged}
+
+// ParseAction returns the action named name, in the past tense or not, e.g. "created" or "create"
+func ParseAction(name string) (Action, bool) {
+	for _, action := range Actions {
+		if name == string(action) || name+"d" == string(action) || name+"ed" == string(action) {
+			return action, true
+		}
+	}
+	return "", false
+}
+
+// Event is a change of an entity as a CloudEvents 1.0 event, e.g.
+//
+//	{"specversion": "1.0", "id": "…", "source": "/gorepository/post", "type": "gorepository.post.created",
+//	 "subject": "1", "time": "…", "datacontenttype": "application/json", "data": {"ID": 1, "Title": "…"},
+//	 "entitytype": "post", "action": "created"}
+//
+// The JSON Schema of data is schemas/<entitytype>.json, see cmd/schemagen.
+type Event struct {
+	SpecVersion     string      `json:"specversion"`
+	ID              string      `json:"id"`
+	Source          string      `json:"source"`
+	Type            string      `json:"type"`    // <prefix>.<entity type>.<action>
+	Subject         string      `json:"subject"` // the entity ID
+	Time            time.Time   `json:"time"`
+	DataContentType string      `json:"datacontenttype"`
+	DataSchema      string      `json:"dataschema,omitempty"`
+	Data            interface{} `json:"data"` // the entity, as it is after the change or before a purge
+
+	// extension attributes
+	EntityType string `json:"entitytype"`
+	Action     Action `json:"action"`
+}
+
+// UnmarshalJSON decodes an event with its data as json.RawMessage, so it is published again unchanged
+func (e *Event) UnmarshalJSON(data []byte) error {
+	type event Event // without this method
+	decoded := struct {
+		*event
+		Data json.RawMessage `json:"data"`
+	}{event: (*event)(e)}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	e.Data = decoded.Data
+	return nil
+}
+
+// NewEvent describes the change action of entity with id
+func NewEvent(entity interface{}, action Action, id string) Event {
+	return newEvent(EntityType(entity), action, id, entity)
+}
+
+func newEvent(entityType string, action Action, id string, data interface{}) Event {
+	return Event{
+		SpecVersion:     "1.0",
+		ID:              uuid.NewString(),
+		Source:          "/" + defaultPrefix + "/" + entityType,
+		Type:            Topic(defaultPrefix, entityType, string(action)),
+		Subject:         id,
+		Time:            time.Now().UTC(),
+		DataContentType: "application/json",
+		Data:            data,
+		EntityType:      entityType,
+		Action:          action,
+	}
+}
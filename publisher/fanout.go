@@ -0,0 +1,73 @@
+package publisher
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// Fanout publishes every event to each of its publishers, e.g. a broker and a Bus. They all get the event
+// even when one fails, the errors of all of them are returned.
+type Fanout []Publisher
+
+func (f Fanout) Publish(event Event) error {
+	var errs []error
+	for _, p := range f {
+		if err := p.Publish(event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (f Fanout) PublishBatch(events []Event) error {
+	var errs []error
+	for _, p := range f {
+		if err := PublishAll(p, events); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes every publisher that holds a connection
+func (f Fanout) Close() error {
+	var errs []error
+	for _, p := range f {
+		if err := Close(p); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// TxFanout is Fanout for publishers storing events through the write transaction, e.g. an Outbox and a Notify
+type TxFanout []TxPublisher
+
+func (f TxFanout) Publish(event Event) error {
+	return f.PublishBatch([]Event{event})
+}
+
+func (f TxFanout) PublishBatch(events []Event) error {
+	var errs []error
+	for _, p := range f {
+		if err := PublishAll(p, events); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (f TxFanout) PublishTx(tx *gorm.DB, event Event) error {
+	return f.PublishBatchTx(tx, []Event{event})
+}
+
+// PublishBatchTx stops at the first error, the transaction is rolled back anyway
+func (f TxFanout) PublishBatchTx(tx *gorm.DB, events []Event) error {
+	for _, p := range f {
+		if err := PublishAllTx(p, tx, events); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package publisher
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// KafkaPublisher writes messages to Kafka topics named like Topic, partitioned by the hash of the entity ID
+type KafkaPublisher struct {
+	writer  kafkaWriter
+	encoder encoder
+	timeout time.Duration
+}
+
+// kafkaWriter is the part of *kafka.Writer the publisher uses, tests replace it
+type kafkaWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// NewKafkaPublisher writes through writer, whose Topic must be empty as every message names its own
+func NewKafkaPublisher(writer *kafka.Writer, cfg BrokerConfig) *KafkaPublisher {
+	cfg = cfg.withDefaults()
+	return &KafkaPublisher{writer: writer, encoder: encoder{cfg.Prefix, cfg.Encoding}, timeout: cfg.Timeout}
+}
+
+// DialKafka writes to the comma separated brokers of cfg.URL, localhost:9092 when it is empty.
+// Topics are created on first use when the brokers allow it.
+func DialKafka(cfg BrokerConfig) (*KafkaPublisher, error) {
+	url := cfg.URL
+	if url == "" {
+		url = "localhost:9092"
+	}
+	writer := &kafka.Writer{
+		Addr:                   kafka.TCP(strings.Split(url, ",")...),
+		Balancer:               &kafka.Hash{}, // the same key always goes to the same partition
+		RequiredAcks:           kafka.RequireAll,
+		AllowAutoTopicCreation: true,
+		BatchTimeout:           10 * time.Millisecond, // WriteMessages waits this long for more messages to batch
+	}
+	return NewKafkaPublisher(writer, cfg), nil
+}
+
+func (p *KafkaPublisher) Publish(event Event) error {
+	return p.PublishBatch([]Event{event})
+}
+
+// PublishBatch writes all messages with one WriteMessages call
+func (p *KafkaPublisher) PublishBatch(events []Event) error {
+	encoded, err := p.encoder.encodeAll(events)
+	if err != nil {
+		return err
+	}
+	records := make([]kafka.Message, len(encoded))
+	for i, m := range encoded {
+		headers := []kafka.Header{{Key: "content-type", Value: []byte(m.ContentType)}}
+		for key, value := range m.Headers {
+			headers = append(headers, kafka.Header{Key: key, Value: []byte(value)})
+		}
+		records[i] = kafka.Message{Topic: m.Topic, Key: []byte(m.Key), Value: m.Body, Headers: headers}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+	return p.writer.WriteMessages(ctx, records...)
+}
+
+// Close flushes pending writes and closes the writer
+func (p *KafkaPublisher) Close() error {
+	return p.writer.Close()
+}
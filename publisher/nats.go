@@ -0,0 +1,69 @@
+package publisher
+
+import (
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// NATSPublisher publishes messages on NATS subjects named like Topic
+type NATSPublisher struct {
+	conn    *nats.Conn
+	encoder encoder
+	timeout time.Duration
+	owned   bool // Close closes conn
+}
+
+// NewNATSPublisher publishes through conn, which the caller keeps and closes
+func NewNATSPublisher(conn *nats.Conn, cfg BrokerConfig) *NATSPublisher {
+	cfg = cfg.withDefaults()
+	return &NATSPublisher{conn: conn, encoder: encoder{cfg.Prefix, cfg.Encoding}, timeout: cfg.Timeout}
+}
+
+// DialNATS connects to cfg.URL, nats.DefaultURL when it is empty
+func DialNATS(cfg BrokerConfig) (*NATSPublisher, error) {
+	url := cfg.URL
+	if url == "" {
+		url = nats.DefaultURL
+	}
+	conn, err := nats.Connect(url, nats.Name(defaultPrefix), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, err
+	}
+	p := NewNATSPublisher(conn, cfg)
+	p.owned = true
+	return p, nil
+}
+
+func (p *NATSPublisher) Publish(event Event) error {
+	return p.PublishBatch([]Event{event})
+}
+
+// PublishBatch publishes every message and flushes once, so the server has them all when it returns
+func (p *NATSPublisher) PublishBatch(events []Event) error {
+	encoded, err := p.encoder.encodeAll(events)
+	if err != nil {
+		return err
+	}
+	for _, m := range encoded {
+		msg := nats.NewMsg(m.Topic)
+		msg.Data = m.Body
+		msg.Header.Set("Content-Type", m.ContentType)
+		msg.Header.Set(nats.MsgIdHdr, m.Headers[HeaderEventID]) // JetStream drops duplicates by it
+		for key, value := range m.Headers {
+			msg.Header.Set(key, value)
+		}
+		if err := p.conn.PublishMsg(msg); err != nil {
+			return err
+		}
+	}
+	return p.conn.FlushTimeout(p.timeout)
+}
+
+// Close drains the connection when DialNATS opened it
+func (p *NATSPublisher) Close() error {
+	if !p.owned {
+		return nil
+	}
+	return p.conn.Drain()
+}
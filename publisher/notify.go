@@ -0,0 +1,133 @@
+package publisher
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"gorm.io/gorm"
+)
+
+// maxNotifyPayload is below the 8000 bytes Postgres accepts by default
+const maxNotifyPayload = 7900
+
+// NotifyChannel returns the channel Notify uses for an entity type, e.g. "gorepository.post"
+func NotifyChannel(prefix, entityType string) string {
+	return prefix + "." + entityType
+}
+
+// Notify publishes events with pg_notify on the channel of their entity type, Listener receives them.
+// Through PublishTx they are sent when the transaction commits, not at all on rollback. The data of an event
+// that is too large for a notification is left out, the listener gets it with nil Data.
+// Notifications are not stored, whoever does not listen when they are sent misses them.
+type Notify struct {
+	db     *gorm.DB
+	Prefix string
+}
+
+func NewNotify(db *gorm.DB) *Notify {
+	return &Notify{db: db, Prefix: defaultPrefix}
+}
+
+func (n *Notify) Publish(event Event) error {
+	return n.PublishBatchTx(n.db, []Event{event})
+}
+
+func (n *Notify) PublishTx(tx *gorm.DB, event Event) error {
+	return n.PublishBatchTx(tx, []Event{event})
+}
+
+func (n *Notify) PublishBatch(events []Event) error {
+	return n.PublishBatchTx(n.db, events)
+}
+
+func (n *Notify) PublishBatchTx(tx *gorm.DB, events []Event) error {
+	tx = tx.Session(&gorm.Session{NewDB: true})
+	for _, event := range events {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		if len(payload) > maxNotifyPayload {
+			event.Data = nil
+			if payload, err = json.Marshal(event); err != nil {
+				return err
+			}
+		}
+		if err := tx.Exec("SELECT pg_notify(?, ?)", NotifyChannel(n.Prefix, event.EntityType), string(payload)).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Listener LISTENs on the Notify channels of entities on a connection of its own and publishes what it
+// receives to next, usually a Bus whose handlers then see the changes of every instance:
+//
+//	changes := publisher.NewBus()
+//	go publisher.NewListener(dsn, changes, model.User{}, model.Post{}).Run(ctx)
+//	publisher.Subscribe(changes, publisher.Updated, func(event publisher.Event, post model.Post) error {...})
+type Listener struct {
+	dsn         string
+	next        Publisher
+	entityTypes []string
+
+	Prefix    string
+	Reconnect time.Duration // wait before connecting again after the connection failed
+}
+
+func NewListener(dsn string, next Publisher, entities ...interface{}) *Listener {
+	l := &Listener{dsn: dsn, next: next, Prefix: defaultPrefix, Reconnect: 5 * time.Second}
+	for _, entity := range entities {
+		l.entityTypes = append(l.entityTypes, EntityType(entity))
+	}
+	return l
+}
+
+// Run listens until ctx is cancelled. Notifications sent while it reconnects are missed.
+func (l *Listener) Run(ctx context.Context) {
+	for {
+		if err := l.listen(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("listener: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(l.Reconnect):
+		}
+	}
+}
+
+func (l *Listener) listen(ctx context.Context) error {
+	conn, err := pgx.Connect(ctx, l.dsn)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	for _, entityType := range l.entityTypes {
+		channel := pgx.Identifier{NotifyChannel(l.Prefix, entityType)}.Sanitize()
+		if _, err := conn.Exec(ctx, "LISTEN "+channel); err != nil {
+			return err
+		}
+	}
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+
+		var event Event
+		if err := json.Unmarshal([]byte(notification.Payload), &event); err != nil {
+			log.Printf("listener: %s: %v", notification.Channel, err)
+			continue
+		}
+		if err := l.next.Publish(event); err != nil {
+			log.Printf("listener: %s %s: %v", event.Type, event.Subject, err)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package publisher
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// notification is a pg_notify call of a dry run session
+type notification struct {
+	channel string
+	payload string
+}
+
+// dryRunNotify returns a Notify whose pg_notify calls are recorded instead of sent, no database is needed
+func dryRunNotify(t *testing.T) (*Notify, *[]notification) {
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=127.0.0.1 port=1"}), &gorm.Config{
+		DryRun:               true,
+		DisableAutomaticPing: true,
+		Logger:               logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sent []notification
+	err = db.Callback().Raw().After("gorm:raw").Register("test:notify", func(db *gorm.DB) {
+		if vars := db.Statement.Vars; strings.Contains(db.Statement.SQL.String(), "pg_notify") && len(vars) == 2 {
+			sent = append(sent, notification{vars[0].(string), vars[1].(string)})
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewNotify(db), &sent
+}
+
+func TestNotifyPayload(t *testing.T) {
+	n, sent := dryRunNotify(t)
+	large := post{ID: 8, Title: strings.Repeat("x", maxNotifyPayload)}
+	events := []Event{testEvent(), NewEvent(large, Updated, "8")}
+	if err := n.PublishBatch(events); err != nil {
+		t.Fatal(err)
+	}
+	if len(*sent) != 2 {
+		t.Fatalf("sent %d notifications, want 2", len(*sent))
+	}
+
+	for i, s := range *sent {
+		if s.channel != "gorepository.post" {
+			t.Errorf("channel %q, want gorepository.post", s.channel)
+		}
+		if len(s.payload) > maxNotifyPayload {
+			t.Errorf("payload of %d bytes, more than %d", len(s.payload), maxNotifyPayload)
+		}
+		var event map[string]interface{}
+		if err := json.Unmarshal([]byte(s.payload), &event); err != nil {
+			t.Fatal(err)
+		}
+		if event["id"] != events[i].ID || event["subject"] != events[i].Subject {
+			t.Errorf("sent %v, want %+v", event, events[i])
+		}
+		// the data of the large post is left out, the small one keeps it
+		data, _ := event["data"].(map[string]interface{})
+		if i == 0 && data["Title"] != "hello" || i == 1 && event["data"] != nil {
+			t.Errorf("event %d sent with data %v", i, event["data"])
+		}
+	}
+}
+
+func TestNotifyListener(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := &Recorder{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go NewListener(dsn, rec, post{}).Run(ctx)
+
+	// notifications sent before the listener is connected are missed, send until one arrives
+	event := testEvent()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(rec.Events()) == 0 && time.Now().Before(deadline) {
+		if err := NewNotify(db).Publish(event); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	events := rec.Events()
+	if len(events) == 0 {
+		t.Fatal("nothing received")
+	}
+	if events[0].ID != event.ID || events[0].EntityType != "post" || events[0].Subject != "7" {
+		t.Fatalf("received %+v, want %+v", events[0], event)
+	}
+}
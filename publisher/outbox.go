@@ -0,0 +1,233 @@
+package publisher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// TxPublisher is implemented by publishers that store events through the same transaction as the entity change
+type TxPublisher interface {
+	Publisher
+	PublishTx(tx *gorm.DB, event Event) error
+}
+
+// OutboxEvent is an event waiting in the outbox_events table to be relayed
+type OutboxEvent struct {
+	ID             uint   `gorm:"primarykey"`
+	EventID        string // Event.ID, so a relayed event keeps the ID it was published with
+	Target         string `gorm:"not null;default:'';index:idx_outbox_events_pending,where:delivered_at IS NULL AND dead_lettered_at IS NULL"` // the relay the row is for, see Outbox.Targets
+	EntityType     string
+	EntityID       string
+	Action         string
+	Payload        []byte `gorm:"type:jsonb"` // Event.Data
+	Attempts       int
+	LastError      string
+	NextAttemptAt  time.Time  `gorm:"index:idx_outbox_events_pending"` // pending rows are found by target and due time
+	DeliveredAt    *time.Time `gorm:"index"`
+	DeadLetteredAt *time.Time `gorm:"index"` // set when the relay gave up on it, see OutboxRelay.DeadLetters
+	CreatedAt      time.Time  // Event.Time
+}
+
+func (OutboxEvent) TableName() string {
+	return "outbox_events"
+}
+
+// Outbox writes events into outbox_events instead of sending them, OutboxRelay delivers them later
+type Outbox struct {
+	db *gorm.DB
+
+	// Targets stores every event once for each relay with that OutboxRelay.Target, so each target keeps
+	// its own attempts and one that fails does not get the event to the others again. One row for the
+	// relay of target "" when empty.
+	Targets []string
+}
+
+func NewOutbox(db *gorm.DB) *Outbox {
+	return &Outbox{db: db}
+}
+
+// Publish stores the event outside of any transaction, the repository uses PublishTx instead
+func (o *Outbox) Publish(event Event) error {
+	return o.PublishTx(o.db, event)
+}
+
+func (o *Outbox) PublishTx(tx *gorm.DB, event Event) error {
+	return o.PublishBatchTx(tx, []Event{event})
+}
+
+func (o *Outbox) PublishBatch(events []Event) error {
+	return o.PublishBatchTx(o.db, events)
+}
+
+// PublishBatchTx stores all events for every target with one INSERT
+func (o *Outbox) PublishBatchTx(tx *gorm.DB, events []Event) error {
+	targets := o.Targets
+	if len(targets) == 0 {
+		targets = []string{""}
+	}
+	rows := make([]OutboxEvent, 0, len(events)*len(targets))
+	for _, event := range events {
+		payload, err := json.Marshal(event.Data)
+		if err != nil {
+			return err
+		}
+		for _, target := range targets {
+			rows = append(rows, OutboxEvent{
+				EventID:       event.ID,
+				Target:        target,
+				EntityType:    event.EntityType,
+				EntityID:      event.Subject,
+				Action:        string(event.Action),
+				Payload:       payload,
+				NextAttemptAt: time.Now(),
+				CreatedAt:     event.Time,
+			})
+		}
+	}
+	return tx.Session(&gorm.Session{NewDB: true}).Create(&rows).Error
+}
+
+// Purge deletes the rows delivered or dead lettered before the given time and returns how many, a dead
+// lettered event is still in dead_letter_events
+func (o *Outbox) Purge(ctx context.Context, before time.Time) (int64, error) {
+	return purgeWhere(o.db.WithContext(ctx), &OutboxEvent{}, "delivered_at < ? OR dead_lettered_at < ?", before, before)
+}
+
+// Event returns the event e was stored from, with its data as json.RawMessage
+func (e OutboxEvent) Event() Event {
+	event := newEvent(e.EntityType, Action(e.Action), e.EntityID, json.RawMessage(e.Payload))
+	if e.EventID != "" {
+		event.ID = e.EventID
+	}
+	event.Time = e.CreatedAt.UTC()
+	return event
+}
+
+// OutboxRelay polls outbox_events and hands every pending event of its Target to the next publisher
+type OutboxRelay struct {
+	db   *gorm.DB
+	next Publisher
+
+	Target string // the rows it delivers, see Outbox.Targets
+
+	Interval   time.Duration // time between two polls
+	BatchSize  int           // events claimed per poll
+	MinBackoff time.Duration // delay before the first retry, doubled on every failure
+	MaxBackoff time.Duration
+	Jitter     float64 // see Backoff
+
+	// DeadLetters receives the events that failed MaxAttempts times, without it they are retried forever
+	DeadLetters *DeadLetterStore
+	MaxAttempts int
+}
+
+func NewOutboxRelay(db *gorm.DB, next Publisher) *OutboxRelay {
+	return &OutboxRelay{
+		db:          db,
+		next:        next,
+		Interval:    time.Second,
+		BatchSize:   100,
+		MinBackoff:  time.Second,
+		MaxBackoff:  10 * time.Minute,
+		Jitter:      0.2,
+		MaxAttempts: 10,
+	}
+}
+
+// Run relays events until ctx is cancelled
+func (r *OutboxRelay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := r.RelayOnce(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("outbox relay: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RelayOnce claims one batch of pending events and returns how many were delivered.
+// Rows are locked with FOR UPDATE SKIP LOCKED so several relays can run side by side.
+func (r *OutboxRelay) RelayOnce(ctx context.Context) (int, error) {
+	delivered := 0
+
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var events []OutboxEvent
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("target = ? AND delivered_at IS NULL AND dead_lettered_at IS NULL AND next_attempt_at <= ?", r.Target, time.Now()).
+			Order("id").
+			Limit(r.BatchSize).
+			Find(&events).Error
+		if err != nil {
+			return err
+		}
+
+		for _, event := range events {
+			now := time.Now()
+			changes := map[string]interface{}{"attempts": event.Attempts + 1}
+
+			if err := r.deliver(event); err != nil {
+				changes["last_error"] = err.Error()
+				changes["next_attempt_at"] = now.Add(r.backoff(event.Attempts + 1))
+				if r.DeadLetters != nil && event.Attempts+1 >= r.MaxAttempts {
+					if err := r.DeadLetters.AddTx(tx, []Event{event.Event()}, err); err != nil {
+						return err
+					}
+					changes["dead_lettered_at"] = now
+				}
+			} else {
+				changes["last_error"] = ""
+				changes["delivered_at"] = now
+				delivered++
+			}
+
+			if err := tx.Model(&OutboxEvent{}).Where("id = ?", event.ID).Updates(changes).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	return delivered, err
+}
+
+func (r *OutboxRelay) deliver(event OutboxEvent) (err error) {
+	// a panicking publisher fails the event instead of the relay
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("publish panicked: %v", p)
+		}
+	}()
+
+	return r.next.Publish(event.Event())
+}
+
+func (r *OutboxRelay) backoff(attempts int) time.Duration {
+	return Backoff{Min: r.MinBackoff, Max: r.MaxBackoff, Jitter: r.Jitter}.Delay(attempts)
+}
+
+// EntityType returns the lower case type name of entity, e.g. "post" for model.Post
+func EntityType(entity interface{}) string {
+	t := reflect.TypeOf(entity)
+	for t != nil && (t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice) {
+		t = t.Elem()
+	}
+	if t == nil {
+		return ""
+	}
+	return strings.ToLower(t.Name())
+}
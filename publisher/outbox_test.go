@@ -0,0 +1,47 @@
+package publisher
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestOutboxRelayAndPurge(t *testing.T) {
+	db := testDB(t, &OutboxEvent{}, &DeadLetter{})
+	outbox := NewOutbox(db)
+	outbox.Targets = []string{"", "failing"}
+	if err := outbox.PublishBatch([]Event{testEvent(), testEvent()}); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := &Recorder{}
+	if delivered, err := NewOutboxRelay(db, rec).RelayOnce(context.Background()); err != nil || delivered != 2 {
+		t.Fatalf("RelayOnce = %d, %v, want the 2 events of target \"\"", delivered, err)
+	}
+
+	// the other target fails on its own, the first of its events is dead lettered
+	failing := NewOutboxRelay(db, &Recorder{Err: errors.New("down")})
+	failing.Target, failing.BatchSize = "failing", 1
+	failing.DeadLetters, failing.MaxAttempts = NewDeadLetterStore(db, nil), 1
+	if delivered, err := failing.RelayOnce(context.Background()); err != nil || delivered != 0 {
+		t.Fatalf("RelayOnce = %d, %v, want none delivered", delivered, err)
+	}
+	if n := len(rec.Events()); n != 2 {
+		t.Fatalf("target \"\" got %d events, want them once", n)
+	}
+
+	if purged, err := outbox.Purge(context.Background(), time.Now().Add(-time.Hour)); err != nil || purged != 0 {
+		t.Fatalf("Purge of an hour ago = %d, %v, want 0", purged, err)
+	}
+	if purged, err := outbox.Purge(context.Background(), time.Now().Add(time.Second)); err != nil || purged != 3 {
+		t.Fatalf("Purge = %d, %v, want the 2 delivered and the dead lettered rows", purged, err)
+	}
+	var pending []OutboxEvent
+	if err := db.Find(&pending).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].Target != "failing" || pending[0].DeliveredAt != nil {
+		t.Fatalf("left %+v, want the pending row", pending)
+	}
+}
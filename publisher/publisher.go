@@ -1,11 +1,65 @@
 package publisher
 
+import (
+	"errors"
+	"sync"
+)
+
+// Publisher sends events about entity changes, see Event. An error means the event was not delivered,
+// e.g. because the broker is down; wrap a publisher with NewRetrying to retry and dead letter it.
 type Publisher interface {
-	PublishMessage(entity interface{}, action string, id string)
+	Publish(event Event) error
 }
 
 type NoopPublisher struct{}
 
-func (n *NoopPublisher) PublishMessage(entity interface{}, action string, id string) {
+func (n *NoopPublisher) Publish(event Event) error {
 	// Do nothing
+	return nil
+}
+
+// BufferedPublisher holds events in memory until Flush is called, e.g. until a transaction commits
+type BufferedPublisher struct {
+	mu      sync.Mutex
+	next    Publisher
+	batches [][]Event // a Publish call is a batch of one
+}
+
+func NewBufferedPublisher(next Publisher) *BufferedPublisher {
+	return &BufferedPublisher{next: next}
+}
+
+func (b *BufferedPublisher) Publish(event Event) error {
+	return b.PublishBatch([]Event{event})
+}
+
+func (b *BufferedPublisher) PublishBatch(events []Event) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.batches = append(b.batches, events)
+	return nil
+}
+
+// Flush sends every buffered event to the wrapped publisher in the order they were published, batches stay batches.
+// A failing batch does not keep the later ones back, the errors of all of them are returned.
+func (b *BufferedPublisher) Flush() error {
+	b.mu.Lock()
+	batches := b.batches
+	b.batches = nil
+	b.mu.Unlock()
+
+	var errs []error
+	for _, events := range batches {
+		if err := PublishAll(b.next, events); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Discard drops every buffered event, e.g. when a transaction rolls back
+func (b *BufferedPublisher) Discard() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.batches = nil
 }
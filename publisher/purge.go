@@ -0,0 +1,59 @@
+package publisher
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Purger deletes the rows it is done with, Outbox the events it delivered and Webhooks the deliveries
+type Purger interface {
+	Purge(ctx context.Context, before time.Time) (int64, error)
+}
+
+// RunPurgeJob deletes, every interval, the rows purgers were done with more than retention ago.
+// It returns when ctx is cancelled.
+func RunPurgeJob(ctx context.Context, interval, retention time.Duration, purgers ...Purger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		before := time.Now().Add(-retention)
+		for _, purger := range purgers {
+			purged, err := purger.Purge(ctx, before)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("purge job: %v", err)
+			} else if purged > 0 {
+				log.Printf("purge job: purged %d rows done before %s", purged, before.Format(time.RFC3339))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// purgeBatchSize bounds the rows purgeWhere deletes at once
+const purgeBatchSize = 1000
+
+// purgeWhere deletes the rows of model matching the condition in batches, so no delete holds its locks for long
+func purgeWhere(db *gorm.DB, model interface{}, query string, args ...interface{}) (int64, error) {
+	var total int64
+	for {
+		batch := db.Session(&gorm.Session{NewDB: true}).Model(model).
+			Select("id").
+			Where(query, args...).
+			Order("id").
+			Limit(purgeBatchSize)
+		result := db.Session(&gorm.Session{NewDB: true}).Where("id IN (?)", batch).Delete(model)
+		total += result.RowsAffected
+		if result.Error != nil || result.RowsAffected < purgeBatchSize {
+			return total, result.Error
+		}
+	}
+}
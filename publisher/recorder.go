@@ -0,0 +1,65 @@
+package publisher
+
+import "sync"
+
+// Recorder keeps every published event, a fake Publisher for tests
+//
+//	rec := &publisher.Recorder{}
+//	repos := repository.NewMemoryRepositories(rec)
+//	...
+//	rec.Events() // [{Type: "gorepository.user.created", Subject: "1", Data: model.User{...}, ...}]
+type Recorder struct {
+	mu     sync.Mutex
+	events []Event
+	calls  int
+
+	Err error // returned by every publish instead of recording, e.g. to test a broker outage
+}
+
+func (r *Recorder) Publish(event Event) error {
+	return r.PublishBatch([]Event{event})
+}
+
+func (r *Recorder) PublishBatch(events []Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls++
+	if r.Err != nil {
+		return r.Err
+	}
+	r.events = append(r.events, events...)
+	return nil
+}
+
+// Calls returns how often Publish or PublishBatch was called, e.g. 1 for a bulk create of 100 users
+func (r *Recorder) Calls() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.calls
+}
+
+// Events returns a copy of the recorded events in the order they were published
+func (r *Recorder) Events() []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Event{}, r.events...)
+}
+
+// Actions returns the action of every recorded event, e.g. []Action{Created, Updated}
+func (r *Recorder) Actions() []Action {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	actions := make([]Action, len(r.events))
+	for i, event := range r.events {
+		actions[i] = event.Action
+	}
+	return actions
+}
+
+// Reset forgets every recorded event
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = nil
+	r.calls = 0
+}
@@ -0,0 +1,86 @@
+package publisher
+
+import (
+	"errors"
+	"log"
+	"math/rand"
+	"time"
+)
+
+// Backoff is the delay before a retry, doubled on every failed attempt from Min up to Max.
+// Jitter randomizes it by up to that fraction in either direction, so failed publishers do not retry in lockstep.
+type Backoff struct {
+	Min    time.Duration
+	Max    time.Duration
+	Jitter float64 // 0 to 1
+}
+
+// Delay returns the delay after the attempts-th failed attempt, 1 for the first
+func (b Backoff) Delay(attempts int) time.Duration {
+	delay := b.Min
+	for i := 1; i < attempts && delay < b.Max; i++ {
+		delay *= 2
+	}
+	if delay > b.Max {
+		delay = b.Max
+	}
+	if b.Jitter > 0 {
+		delay += time.Duration((rand.Float64()*2 - 1) * b.Jitter * float64(delay))
+	}
+	return delay
+}
+
+// Retrying publishes through next and retries failed events with Backoff. Events that still fail after
+// MaxAttempts, or as soon as the circuit of a CircuitBreaker is open, are added to DeadLetters and
+// count as published; without DeadLetters the last error is returned.
+type Retrying struct {
+	next Publisher
+
+	MaxAttempts int
+	Backoff     Backoff
+	DeadLetters *DeadLetterStore // may be nil
+
+	sleep func(time.Duration) // time.Sleep, replaced to retry without waiting
+}
+
+func NewRetrying(next Publisher, deadLetters *DeadLetterStore) *Retrying {
+	return &Retrying{
+		next:        next,
+		MaxAttempts: 5,
+		Backoff:     Backoff{Min: 100 * time.Millisecond, Max: 5 * time.Second, Jitter: 0.2},
+		DeadLetters: deadLetters,
+		sleep:       time.Sleep,
+	}
+}
+
+func (r *Retrying) Publish(event Event) error {
+	return r.PublishBatch([]Event{event})
+}
+
+// PublishBatch retries the whole batch, a BatchPublisher does not tell which of its events failed
+func (r *Retrying) PublishBatch(events []Event) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = PublishAll(r.next, events); err == nil {
+			return nil
+		}
+		if attempt >= r.MaxAttempts || errors.Is(err, ErrCircuitOpen) {
+			break
+		}
+		r.sleep(r.Backoff.Delay(attempt))
+	}
+
+	if r.DeadLetters == nil {
+		return err
+	}
+	if dlErr := r.DeadLetters.Add(events, err); dlErr != nil {
+		return errors.Join(err, dlErr)
+	}
+	log.Printf("publisher: %d events dead lettered: %v", len(events), err)
+	return nil
+}
+
+// Close closes next when it holds a connection
+func (r *Retrying) Close() error {
+	return Close(r.next)
+}
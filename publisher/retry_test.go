@@ -0,0 +1,112 @@
+package publisher
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var errDown = errors.New("broker down")
+
+// publishFunc is a Publisher calling itself, e.g. to fail the first attempts
+type publishFunc func(event Event) error
+
+func (f publishFunc) Publish(event Event) error { return f(event) }
+
+// failing returns a publisher failing the first n calls with errDown and passing the rest to rec, and how
+// often it was called
+func failing(n int, rec *Recorder) (Publisher, *int) {
+	calls := 0
+	return publishFunc(func(event Event) error {
+		calls++
+		if calls <= n {
+			return errDown
+		}
+		return rec.Publish(event)
+	}), &calls
+}
+
+func TestBackoff(t *testing.T) {
+	b := Backoff{Min: 10 * time.Millisecond, Max: 25 * time.Millisecond}
+	var delays []time.Duration
+	for attempts := 1; attempts <= 4; attempts++ {
+		delays = append(delays, b.Delay(attempts))
+	}
+	if want := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 25 * time.Millisecond, 25 * time.Millisecond}; !reflect.DeepEqual(delays, want) {
+		t.Fatalf("delays %v, want %v", delays, want)
+	}
+
+	b.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if delay := b.Delay(1); delay < 5*time.Millisecond || delay > 15*time.Millisecond {
+			t.Fatalf("delay %s with jitter 0.5 of 10ms", delay)
+		}
+	}
+}
+
+// retrying returns a Retrying of next that records its delays instead of sleeping
+func retrying(next Publisher, deadLetters *DeadLetterStore) (*Retrying, *[]time.Duration) {
+	r := NewRetrying(next, deadLetters)
+	r.MaxAttempts = 3
+	r.Backoff = Backoff{Min: 10 * time.Millisecond, Max: time.Second}
+	var slept []time.Duration
+	r.sleep = func(d time.Duration) { slept = append(slept, d) }
+	return r, &slept
+}
+
+func TestRetrying(t *testing.T) {
+	rec := &Recorder{}
+	next, calls := failing(2, rec)
+	r, slept := retrying(next, nil)
+	if err := r.Publish(testEvent()); err != nil {
+		t.Fatal(err)
+	}
+	if want := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond}; *calls != 3 || len(rec.Events()) != 1 || !reflect.DeepEqual(*slept, want) {
+		t.Fatalf("%d calls after %v, want the third to publish after %v", *calls, *slept, want)
+	}
+
+	// MaxAttempts fail, the last error is returned without dead letters
+	next, calls = failing(3, rec)
+	r, _ = retrying(next, nil)
+	if err := r.Publish(testEvent()); !errors.Is(err, errDown) || *calls != 3 {
+		t.Fatalf("Publish = %v after %d calls, want errDown after 3", err, *calls)
+	}
+
+	// an open circuit is not retried
+	calls = new(int)
+	r, slept = retrying(publishFunc(func(Event) error {
+		*calls++
+		return ErrCircuitOpen
+	}), nil)
+	if err := r.Publish(testEvent()); !errors.Is(err, ErrCircuitOpen) || *calls != 1 || len(*slept) != 0 {
+		t.Fatalf("Publish = %v after %d calls, want ErrCircuitOpen at once", err, *calls)
+	}
+}
+
+func TestRetryingDeadLetters(t *testing.T) {
+	db := testDB(t, &DeadLetter{})
+	rec := &Recorder{}
+	next, _ := failing(3, rec)
+	r, _ := retrying(next, NewDeadLetterStore(db, rec))
+
+	event := testEvent()
+	if err := r.Publish(event); err != nil {
+		t.Fatalf("Publish = %v, want the event dead lettered instead", err)
+	}
+	var letters []DeadLetter
+	if err := db.Find(&letters).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != 1 || letters[0].EventID != event.ID || letters[0].Error != errDown.Error() {
+		t.Fatalf("dead letters %+v, want the event with the last error", letters)
+	}
+
+	if replayed, err := r.DeadLetters.Replay(context.Background()); err != nil || replayed != 1 || len(rec.Events()) != 1 {
+		t.Fatalf("Replay = %d, %v, want the event published", replayed, err)
+	}
+	if got := rec.Events()[0]; got.ID != event.ID || got.Subject != "7" {
+		t.Fatalf("replayed %+v, want %+v", got, event)
+	}
+}
@@ -0,0 +1,433 @@
+package publisher
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Headers of a webhook request, its body is the event as CloudEvents JSON
+const (
+	HeaderWebhookID        = "Webhook-Id"        // the event ID, the same for every attempt
+	HeaderWebhookTimestamp = "Webhook-Timestamp" // unix seconds of the attempt
+	HeaderWebhookSignature = "Webhook-Signature" // see SignWebhook
+)
+
+const (
+	// DefaultRetrySchedule is the time between attempts of a subscription without its own RetrySchedule
+	DefaultRetrySchedule = "1m,5m,30m,2h,12h"
+	// DefaultDisableAfter is how many attempts in a row may fail before a subscription is disabled
+	DefaultDisableAfter = 20
+)
+
+// WebhookSubscription is an endpoint in the webhook_subscriptions table receiving the events matching Events
+type WebhookSubscription struct {
+	ID  uint   `gorm:"primarykey"`
+	URL string `validate:"required,max=2000"`
+	// Secret signs the requests, generated when empty. It is never written to JSON, the create response
+	// returns it once and the rotate endpoint sets a new one.
+	Secret string `validate:"max=200" json:"-"`
+	// Events are comma separated <entity type>.<action> patterns, * matching any part,
+	// e.g. "post.created,user.*". Every event matches when it is empty.
+	Events string
+	// RetrySchedule is the comma separated time before each retry, e.g. "1m,1h", DefaultRetrySchedule when empty
+	RetrySchedule string
+	// DisableAfter is how many attempts in a row may fail, DefaultDisableAfter when 0
+	DisableAfter int
+	// Disabled subscriptions receive nothing, their pending deliveries are sent once they are enabled again
+	Disabled       bool
+	DisabledReason string
+	Failures       int // attempts failed in a row
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+
+	Deliveries []WebhookDelivery `gorm:"foreignKey:SubscriptionID;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+func (WebhookSubscription) TableName() string {
+	return "webhook_subscriptions"
+}
+
+// BeforeCreate generates a missing secret, updates keep the stored one
+func (s *WebhookSubscription) BeforeCreate(tx *gorm.DB) error {
+	if s.Secret != "" {
+		return nil
+	}
+	var err error
+	s.Secret, err = NewWebhookSecret()
+	return err
+}
+
+// NewWebhookSecret returns a random secret for a subscription
+func NewWebhookSecret() (string, error) {
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+// Matches reports whether event is one of the Events of s
+func (s WebhookSubscription) Matches(event Event) bool {
+	if strings.TrimSpace(s.Events) == "" {
+		return true
+	}
+	for _, pattern := range strings.Split(s.Events, ",") {
+		entityType, action, _ := strings.Cut(strings.TrimSpace(pattern), ".")
+		if action == "" {
+			action = "*" // "post" is "post.*"
+		}
+		if (entityType == "*" || entityType == event.EntityType) && (action == "*" || action == string(event.Action)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Schedule parses RetrySchedule
+func (s WebhookSubscription) Schedule() ([]time.Duration, error) {
+	schedule := s.RetrySchedule
+	if strings.TrimSpace(schedule) == "" {
+		schedule = DefaultRetrySchedule
+	}
+	var delays []time.Duration
+	for _, item := range strings.Split(schedule, ",") {
+		delay, err := time.ParseDuration(strings.TrimSpace(item))
+		if err != nil {
+			return nil, err
+		}
+		if delay < 0 {
+			return nil, fmt.Errorf("negative delay %s", delay)
+		}
+		delays = append(delays, delay)
+	}
+	return delays, nil
+}
+
+func (s WebhookSubscription) disableAfter() int {
+	if s.DisableAfter > 0 {
+		return s.DisableAfter
+	}
+	return DefaultDisableAfter
+}
+
+// Status of a WebhookDelivery
+const (
+	WebhookPending   = "pending"
+	WebhookDelivered = "delivered"
+	WebhookFailed    = "failed" // every attempt of the schedule failed
+)
+
+// WebhookDelivery is an event for a subscription in the webhook_deliveries table, the log of its attempts
+type WebhookDelivery struct {
+	ID             uint   `gorm:"primarykey"`
+	SubscriptionID uint   `gorm:"uniqueIndex:idx_webhook_delivery_event"`
+	EventID        string `gorm:"uniqueIndex:idx_webhook_delivery_event"` // an event is delivered once per subscription
+	EventType      string
+	Payload        []byte `gorm:"type:jsonb"` // the request body
+	Status         string `gorm:"index"`
+	Attempts       int
+	ResponseStatus int       // HTTP status of the last attempt, 0 when there was no response
+	Error          string    // why the last attempt failed
+	NextAttemptAt  time.Time `gorm:"index"`
+	DeliveredAt    *time.Time
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+func (WebhookDelivery) TableName() string {
+	return "webhook_deliveries"
+}
+
+// MarshalJSON writes the payload as JSON instead of base64
+func (d WebhookDelivery) MarshalJSON() ([]byte, error) {
+	type delivery WebhookDelivery // without this method
+	payload := json.RawMessage(d.Payload)
+	if len(payload) == 0 {
+		payload = json.RawMessage("null")
+	}
+	return json.Marshal(struct {
+		delivery
+		Payload json.RawMessage
+	}{delivery(d), payload})
+}
+
+// SignWebhook returns the Webhook-Signature of a request, "sha256=" and the hex HMAC-SHA256 of
+// "<timestamp>.<body>" with secret. Receivers compute it again and compare with hmac.Equal,
+// rejecting old timestamps keeps a request from being replayed.
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook reports whether signature is the SignWebhook signature of timestamp and body
+func VerifyWebhook(secret, timestamp, signature string, body []byte) bool {
+	return hmac.Equal([]byte(signature), []byte(SignWebhook(secret, timestamp, body)))
+}
+
+// Webhooks stores a delivery for every subscription matching an event, Run sends them
+type Webhooks struct {
+	db *gorm.DB
+
+	Client    *http.Client
+	Interval  time.Duration // time between two polls
+	BatchSize int           // deliveries claimed per poll
+	// ClaimFor is how long claimed deliveries are left to one DeliverOnce, it stops sending after it and
+	// another poll takes the rest
+	ClaimFor time.Duration
+}
+
+func NewWebhooks(db *gorm.DB) *Webhooks {
+	return &Webhooks{
+		db:        db,
+		Client:    &http.Client{Timeout: 10 * time.Second},
+		Interval:  time.Second,
+		BatchSize: 100,
+		ClaimFor:  5 * time.Minute,
+	}
+}
+
+func (w *Webhooks) Publish(event Event) error {
+	return w.PublishBatchTx(w.db, []Event{event})
+}
+
+func (w *Webhooks) PublishTx(tx *gorm.DB, event Event) error {
+	return w.PublishBatchTx(tx, []Event{event})
+}
+
+func (w *Webhooks) PublishBatch(events []Event) error {
+	return w.PublishBatchTx(w.db, events)
+}
+
+// PublishBatchTx stores the deliveries of events, an event stored before for a subscription is skipped
+func (w *Webhooks) PublishBatchTx(tx *gorm.DB, events []Event) error {
+	tx = tx.Session(&gorm.Session{NewDB: true})
+
+	var subscriptions []WebhookSubscription
+	if err := tx.Where("disabled = ?", false).Find(&subscriptions).Error; err != nil {
+		return err
+	}
+
+	var deliveries []WebhookDelivery
+	for _, event := range events {
+		var payload []byte
+		for _, subscription := range subscriptions {
+			if !subscription.Matches(event) {
+				continue
+			}
+			if payload == nil {
+				var err error
+				if payload, err = json.Marshal(event); err != nil {
+					return err
+				}
+			}
+			deliveries = append(deliveries, WebhookDelivery{
+				SubscriptionID: subscription.ID,
+				EventID:        event.ID,
+				EventType:      event.Type,
+				Payload:        payload,
+				Status:         WebhookPending,
+				NextAttemptAt:  time.Now(),
+			})
+		}
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&deliveries).Error
+}
+
+// Run sends pending deliveries until ctx is cancelled
+func (w *Webhooks) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := w.DeliverOnce(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("webhooks: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DeliverOnce claims one batch of pending deliveries of enabled subscriptions and returns how many were delivered.
+// A failed attempt is retried after the next delay of the subscription's RetrySchedule, the delivery fails
+// once there is none left. The subscription is disabled after DisableAfter failed attempts in a row.
+// The requests are sent outside of any transaction: claiming moves the next attempt ClaimFor ahead, so
+// other polls skip the deliveries meanwhile, and each outcome is recorded on its own.
+func (w *Webhooks) DeliverOnce(ctx context.Context) (int, error) {
+	deliveries, subscriptions, err := w.claim(ctx)
+	if err != nil || len(deliveries) == 0 {
+		return 0, err
+	}
+
+	sendCtx, cancel := context.WithTimeout(ctx, w.ClaimFor)
+	defer cancel()
+
+	delivered := 0
+	for _, d := range deliveries {
+		if sendCtx.Err() != nil {
+			break // the rest is sent once the claim expired
+		}
+		subscription := subscriptions[d.SubscriptionID]
+		status, sendErr := w.send(sendCtx, subscription, d.EventID, d.Payload)
+		if sendErr == nil {
+			delivered++
+		}
+		err := w.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			return w.record(tx, subscription, d, status, sendErr)
+		})
+		if err != nil {
+			return delivered, err
+		}
+	}
+	return delivered, nil
+}
+
+// Purge deletes the deliveries that were delivered or failed before the given time and returns how many,
+// the pending ones are kept however old they are
+func (w *Webhooks) Purge(ctx context.Context, before time.Time) (int64, error) {
+	return purgeWhere(w.db.WithContext(ctx), &WebhookDelivery{}, "status IN ? AND updated_at < ?", []string{WebhookDelivered, WebhookFailed}, before)
+}
+
+// claim locks one batch of due deliveries and their subscriptions and moves their next attempt ClaimFor ahead
+func (w *Webhooks) claim(ctx context.Context) ([]WebhookDelivery, map[uint]WebhookSubscription, error) {
+	var deliveries []WebhookDelivery
+	byID := map[uint]WebhookSubscription{}
+
+	err := w.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", WebhookPending, now).
+			Where("subscription_id IN (?)", tx.Model(&WebhookSubscription{}).Select("id").Where("disabled = ?", false)).
+			Order("id").
+			Limit(w.BatchSize).
+			Find(&deliveries).Error
+		if err != nil || len(deliveries) == 0 {
+			return err
+		}
+
+		var ids, subscriptionIDs []uint
+		for _, d := range deliveries {
+			ids = append(ids, d.ID)
+			subscriptionIDs = append(subscriptionIDs, d.SubscriptionID)
+		}
+		err = tx.Model(&WebhookDelivery{}).Where("id IN ?", ids).Update("next_attempt_at", now.Add(w.ClaimFor)).Error
+		if err != nil {
+			return err
+		}
+
+		var subscriptions []WebhookSubscription
+		if err := tx.Where("id IN ?", subscriptionIDs).Find(&subscriptions).Error; err != nil {
+			return err
+		}
+		for _, s := range subscriptions {
+			byID[s.ID] = s
+		}
+		return nil
+	})
+	return deliveries, byID, err
+}
+
+// record stores the outcome of an attempt on the delivery and its subscription
+func (w *Webhooks) record(tx *gorm.DB, subscription WebhookSubscription, d WebhookDelivery, status int, sendErr error) error {
+	now := time.Now()
+	changes := map[string]interface{}{"attempts": d.Attempts + 1, "response_status": status}
+
+	if sendErr == nil {
+		changes["status"] = WebhookDelivered
+		changes["error"] = ""
+		changes["delivered_at"] = now
+		if err := tx.Model(&WebhookDelivery{}).Where("id = ?", d.ID).Updates(changes).Error; err != nil {
+			return err
+		}
+		return tx.Model(&WebhookSubscription{}).Where("id = ?", subscription.ID).Update("failures", 0).Error
+	}
+
+	changes["error"] = sendErr.Error()
+	schedule, err := subscription.Schedule()
+	if err != nil {
+		schedule = nil // an invalid schedule does not retry
+	}
+	if d.Attempts < len(schedule) {
+		changes["next_attempt_at"] = now.Add(schedule[d.Attempts])
+	} else {
+		changes["status"] = WebhookFailed
+	}
+	if err := tx.Model(&WebhookDelivery{}).Where("id = ?", d.ID).Updates(changes).Error; err != nil {
+		return err
+	}
+
+	// the counter restarts at 0 when the subscription is disabled, so enabling it again gives it a new chance
+	if err := tx.Model(&WebhookSubscription{}).Where("id = ?", subscription.ID).
+		Update("failures", gorm.Expr("failures + 1")).Error; err != nil {
+		return err
+	}
+	return tx.Model(&WebhookSubscription{}).
+		Where("id = ? AND failures >= ?", subscription.ID, subscription.disableAfter()).
+		Updates(map[string]interface{}{
+			"disabled":        true,
+			"disabled_reason": fmt.Sprintf("%d attempts in a row failed, the last with: %v", subscription.disableAfter(), sendErr),
+			"failures":        0,
+		}).Error
+}
+
+// Ping sends a webhook.ping event to subscription right away and returns the HTTP status it answered with
+func (w *Webhooks) Ping(ctx context.Context, subscription WebhookSubscription) (int, error) {
+	event := newEvent("webhook", "ping", strconv.FormatUint(uint64(subscription.ID), 10), map[string]interface{}{"URL": subscription.URL})
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return 0, err
+	}
+	return w.send(ctx, subscription, event.ID, payload)
+}
+
+// send posts payload to the subscription, any status but 2xx is an error
+func (w *Webhooks) send(ctx context.Context, subscription WebhookSubscription, eventID string, payload []byte) (int, error) {
+	if subscription.ID == 0 {
+		return 0, errors.New("subscription not found")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/cloudevents+json")
+	req.Header.Set(HeaderWebhookID, eventID)
+	req.Header.Set(HeaderWebhookTimestamp, timestamp)
+	req.Header.Set(HeaderWebhookSignature, SignWebhook(subscription.Secret, timestamp, payload))
+
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10)) // so the connection is reused
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
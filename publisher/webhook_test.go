@@ -0,0 +1,168 @@
+package publisher
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// receiver is a webhook endpoint answering with status, it records the requests whose signature checks out
+type receiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	status   int
+	requests int
+	invalid  int
+}
+
+func newReceiver(t *testing.T, secret string, status int) *receiver {
+	r := &receiver{status: status}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.requests++
+		if !VerifyWebhook(secret, req.Header.Get(HeaderWebhookTimestamp), req.Header.Get(HeaderWebhookSignature), body) {
+			r.invalid++
+		}
+		w.WriteHeader(r.status)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *receiver) counts() (requests, invalid int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.requests, r.invalid
+}
+
+func TestWebhookSignature(t *testing.T) {
+	r := newReceiver(t, "s3cr3t", http.StatusNoContent)
+	w := NewWebhooks(nil)
+
+	status, err := w.Ping(context.Background(), WebhookSubscription{ID: 1, URL: r.URL, Secret: "s3cr3t"})
+	if err != nil || status != http.StatusNoContent {
+		t.Fatalf("Ping = %d, %v", status, err)
+	}
+	if _, err := w.Ping(context.Background(), WebhookSubscription{ID: 1, URL: r.URL, Secret: "other"}); err != nil {
+		t.Fatal(err)
+	}
+	if requests, invalid := r.counts(); requests != 2 || invalid != 1 {
+		t.Fatalf("%d requests, %d with an invalid signature, want 2 and 1", requests, invalid)
+	}
+}
+
+// testDB returns the database of TEST_DATABASE_URL with empty tables of models, the test is skipped without it
+func testDB(t *testing.T, models ...interface{}) *gorm.DB {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(models...); err != nil {
+		t.Fatal(err)
+	}
+	var tables []string
+	for _, model := range models {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			t.Fatal(err)
+		}
+		tables = append(tables, stmt.Quote(stmt.Schema.Table))
+	}
+	if err := db.Exec("TRUNCATE " + strings.Join(tables, ", ") + " RESTART IDENTITY CASCADE").Error; err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestWebhookRetryAndDisable(t *testing.T) {
+	db := testDB(t, &WebhookSubscription{}, &WebhookDelivery{})
+	r := newReceiver(t, "s3cr3t", http.StatusInternalServerError)
+	w := NewWebhooks(db)
+
+	subscription := WebhookSubscription{URL: r.URL, Secret: "s3cr3t", RetrySchedule: "0s,1h", DisableAfter: 3}
+	if err := db.Create(&subscription).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Publish(testEvent()); err != nil {
+		t.Fatal(err)
+	}
+
+	deliver := func() {
+		t.Helper()
+		if delivered, err := w.DeliverOnce(context.Background()); err != nil || delivered != 0 {
+			t.Fatalf("DeliverOnce = %d, %v, want 0 delivered", delivered, err)
+		}
+	}
+	delivery := func(id uint) WebhookDelivery {
+		t.Helper()
+		var d WebhookDelivery
+		if err := db.First(&d, id).Error; err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	// the first retry is due right away, the second in an hour
+	deliver()
+	if d := delivery(1); d.Attempts != 1 || d.Status != WebhookPending || d.ResponseStatus != 500 || d.NextAttemptAt.After(time.Now()) {
+		t.Fatalf("after one attempt %+v, want pending and due", d)
+	}
+	deliver()
+	if d := delivery(1); d.Attempts != 2 || d.Status != WebhookPending || d.NextAttemptAt.Before(time.Now().Add(59*time.Minute)) {
+		t.Fatalf("after two attempts %+v, want pending in an hour", d)
+	}
+
+	// the third attempt ends the schedule and is the third failure in a row, which disables the subscription
+	if err := db.Model(&WebhookDelivery{}).Where("id = ?", 1).Update("next_attempt_at", time.Now()).Error; err != nil {
+		t.Fatal(err)
+	}
+	deliver()
+	if d := delivery(1); d.Attempts != 3 || d.Status != WebhookFailed {
+		t.Fatalf("after the schedule %+v, want failed", d)
+	}
+	var stored WebhookSubscription
+	if err := db.First(&stored, subscription.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !stored.Disabled || stored.DisabledReason == "" || stored.Failures != 0 {
+		t.Fatalf("subscription %+v, want it disabled after 3 failed attempts", stored)
+	}
+	if requests, invalid := r.counts(); requests != 3 || invalid != 0 {
+		t.Fatalf("%d requests, %d with an invalid signature, want 3 and 0", requests, invalid)
+	}
+
+	// a disabled subscription gets nothing, its pending deliveries wait
+	pending := WebhookDelivery{SubscriptionID: subscription.ID, EventID: "2", Status: WebhookPending, NextAttemptAt: time.Now()}
+	if err := db.Create(&pending).Error; err != nil {
+		t.Fatal(err)
+	}
+	deliver()
+	if requests, _ := r.counts(); requests != 3 {
+		t.Fatalf("%d requests, want none to a disabled subscription", requests)
+	}
+	if d := delivery(pending.ID); d.Attempts != 0 || d.Status != WebhookPending {
+		t.Fatalf("delivery of the disabled subscription %+v, want it kept pending", d)
+	}
+
+	// the failed delivery is purged, the pending one kept
+	if purged, err := w.Purge(context.Background(), time.Now().Add(time.Second)); err != nil || purged != 1 {
+		t.Fatalf("Purge = %d, %v, want the failed delivery", purged, err)
+	}
+	delivery(pending.ID)
+}
@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"context"
+	"gorepository/audit"
+	"gorepository/publisher"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// change is one entity change made by an operation. It is published with the entity as it is afterwards,
+// or as it was for a purge, and recorded in the audit log with both states.
+type change[T any] struct {
+	action publisher.Action
+	id     string
+	before *T // nil when the entity was created, or not loaded because auditing is off
+	after  *T // nil when the entity was purged
+}
+
+// events turns changes into the events to publish
+func events[T any](changes []change[T]) []publisher.Event {
+	events := make([]publisher.Event, 0, len(changes))
+	for _, c := range changes {
+		entity := c.after
+		if entity == nil {
+			entity = c.before
+		}
+		if entity == nil {
+			continue
+		}
+		events = append(events, publisher.NewEvent(*entity, c.action, c.id))
+	}
+	return events
+}
+
+// auditEntries describes changes as audit log entries, made by the actor of ctx
+func auditEntries[T any](ctx context.Context, changes []change[T]) ([]audit.Entry, error) {
+	entityType := publisher.EntityType(new(T))
+	entries := make([]audit.Entry, len(changes))
+	for i, c := range changes {
+		entry, err := audit.NewEntry(ctx, entityType, c.id, string(c.action), c.before, c.after)
+		if err != nil {
+			return nil, err
+		}
+		entries[i] = entry
+	}
+	return entries, nil
+}
+
+// writeAudit stores changes in the audit log through tx
+func writeAudit[T any](tx *gorm.DB, changes []change[T]) error {
+	entries, err := auditEntries(tx.Statement.Context, changes)
+	if err != nil {
+		return err
+	}
+	return audit.Write(tx, entries)
+}
+
+// before loads the entity with id as it is before a change, locking its row. It returns nil when auditing
+// is off, as the state before is only needed for the audit log, or when there is no such entity.
+func (r *genericRepository[T, ID]) before(db *gorm.DB, options operationOptions, id interface{}, unscoped bool) (*T, error) {
+	if !options.audit {
+		return nil, nil
+	}
+	query := db.Clauses(clause.Locking{Strength: "UPDATE"}).Where(clause.Eq{Column: clause.PrimaryColumn, Value: id})
+	if unscoped {
+		query = query.Unscoped()
+	}
+	var rows []T
+	if err := query.Limit(1).Find(&rows).Error; err != nil || len(rows) == 0 {
+		return nil, err
+	}
+	return &rows[0], nil
+}
+
+// deletions describes deleted rows as returned by DELETE ... RETURNING *, which for a soft delete
+// is the row after the change
+func (r *genericRepository[T, ID]) deletions(rows []T, soft bool) []change[T] {
+	changes := make([]change[T], len(rows))
+	for i := range rows {
+		row := &rows[i]
+		if !soft {
+			changes[i] = change[T]{action: publisher.Purged, id: r.primaryKey(row), before: row}
+			continue
+		}
+		// a soft delete only sets deleted_at
+		before := *row
+		if field := r.softDelete(); field != nil {
+			_ = field.Set(context.Background(), reflect.ValueOf(&before).Elem(), gorm.DeletedAt{})
+		}
+		changes[i] = change[T]{action: publisher.Deleted, id: r.primaryKey(row), before: &before, after: row}
+	}
+	return changes
+}
@@ -0,0 +1,323 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"gorepository/publisher"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// The bulk operations change many rows with one statement (or one per batch) and publish all their
+// messages with a single publisher.PublishAll call, so a publisher.BatchPublisher sends them at once.
+
+// CreateMany inserts entities with one INSERT per batchSize rows, all of them in one for batchSize <= 0,
+// and returns them with their IDs. Either every entity is created or none.
+func (r *genericRepository[T, ID]) CreateMany(entities []T, batchSize int, opts ...Option) ([]T, error) {
+	options := r.defaults
+	options.gormDB = r.db
+
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	if len(entities) == 0 {
+		return entities, nil
+	}
+	sch, err := parseSchema(r.db, new(T))
+	if err != nil {
+		return entities, err
+	}
+	initVersions(sch, entities)
+
+	err = r.save(options, func(db *gorm.DB) error {
+		if batchSize <= 0 || batchSize >= len(entities) {
+			return db.Create(&entities).Error
+		}
+		// the batches must not commit on their own when Create runs outside of a transaction
+		return db.Transaction(func(tx *gorm.DB) error {
+			return tx.CreateInBatches(&entities, batchSize).Error
+		})
+	}, func() []change[T] {
+		return r.changes(entities, publisher.Created, nil)
+	})
+	return entities, err
+}
+
+// Upsert inserts entities with INSERT ... ON CONFLICT (conflictColumns) DO UPDATE SET updateColumns,
+// i.e. a row whose conflict columns match a stored one updates the stored row instead. Without updateColumns
+// stored rows are kept as they are. Soft deleted rows are restored either way. It returns the entities as stored
+// and publishes "upserted" for each of them. Columns are column, field or JSON names; the conflict columns need
+// a unique index, and no two entities may have the same values in them.
+//
+//	users, err := repos.UserRepo.Upsert(users, []string{"email"}, []string{"name"})
+func (r *genericRepository[T, ID]) Upsert(entities []T, conflictColumns, updateColumns []string, opts ...Option) ([]T, error) {
+	options := r.defaults
+	options.gormDB = r.db
+
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	if len(entities) == 0 {
+		return entities, nil
+	}
+	sch, err := parseSchema(r.db, new(T))
+	if err != nil {
+		return entities, err
+	}
+	onConflict, err := upsertClause(sch, conflictColumns, updateColumns)
+	if err != nil {
+		return entities, err
+	}
+	if err := uniqueConflictKeys(sch, onConflict.Columns, entities); err != nil {
+		return entities, err
+	}
+	initVersions(sch, entities)
+
+	var before map[string]*T
+	err = r.save(options, func(db *gorm.DB) error {
+		// the stored rows the entities conflict with, soft deleted ones included like ON CONFLICT does
+		var err error
+		before, err = r.beforeWhere(db, options, func(db *gorm.DB) *gorm.DB {
+			return db.Unscoped().Where(conflictCondition(sch, onConflict.Columns, entities))
+		})
+		if err != nil {
+			return err
+		}
+
+		// RETURNING * replaces the entities with the stored rows, updated ones included
+		return db.Clauses(onConflict, clause.Returning{}).Create(&entities).Error
+	}, func() []change[T] {
+		return r.changes(entities, publisher.Upserted, before)
+	})
+	return entities, err
+}
+
+// UpdateWhere writes changes, like Patch does, to every entity matching conditions and returns how many
+// were updated. Each of them is published as "updated". conditions must not be empty.
+//
+//	n, err := repos.PostRepo.UpdateWhere(conditions, map[string]interface{}{"published": true})
+func (r *genericRepository[T, ID]) UpdateWhere(conditions []func(*gorm.DB) *gorm.DB, changes map[string]interface{}, opts ...Option) (int64, error) {
+	options := r.defaults
+	options.gormDB = r.db
+
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	if len(conditions) == 0 {
+		return 0, gorm.ErrMissingWhereClause
+	}
+	sch, err := parseSchema(r.db, new(T))
+	if err != nil {
+		return 0, err
+	}
+	columns, err := patchColumns(sch, changes)
+	if err != nil || len(columns) == 0 {
+		return 0, err
+	}
+	if field := versionField(sch); field != nil {
+		columns[field.DBName] = gorm.Expr("? + 1", clause.Column{Table: clause.CurrentTable, Name: field.DBName})
+	}
+
+	var updated []T
+	var before map[string]*T
+	err = r.save(options, func(db *gorm.DB) error {
+		var err error
+		if before, err = r.beforeWhere(db, options, conditions...); err != nil {
+			return err
+		}
+
+		query := db.Model(&updated).Clauses(clause.Returning{})
+		for _, condition := range conditions {
+			query = condition(query)
+		}
+		if _, ok := query.Get(predicateKey); ok {
+			return errPredicate
+		}
+		return query.Updates(columns).Error
+	}, func() []change[T] {
+		return r.changes(updated, publisher.Updated, before)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(updated)), nil
+}
+
+// DeleteWhere deletes every entity matching conditions, soft deleting them when T supports it like Delete,
+// and returns how many were deleted. conditions must not be empty.
+func (r *genericRepository[T, ID]) DeleteWhere(conditions []func(*gorm.DB) *gorm.DB, opts ...Option) (int64, error) {
+	options := r.defaults
+	options.gormDB = r.db
+
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	if len(conditions) == 0 {
+		return 0, gorm.ErrMissingWhereClause
+	}
+
+	var deleted []T
+	err := r.save(options, func(db *gorm.DB) error {
+		query := db.Model(new(T)).Clauses(clause.Returning{})
+		for _, condition := range conditions {
+			query = condition(query)
+		}
+		if _, ok := query.Get(predicateKey); ok {
+			return errPredicate
+		}
+		return query.Delete(&deleted).Error
+	}, func() []change[T] {
+		return r.deletions(deleted, r.softDelete() != nil)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(deleted)), nil
+}
+
+// changes describes the entities as changed by action, before holds their previous state by primary key
+func (r *genericRepository[T, ID]) changes(entities []T, action publisher.Action, before map[string]*T) []change[T] {
+	changes := make([]change[T], len(entities))
+	for i := range entities {
+		id := r.primaryKey(&entities[i])
+		changes[i] = change[T]{action: action, id: id, before: before[id], after: &entities[i]}
+	}
+	return changes
+}
+
+// beforeWhere loads the entities matching conditions for the audit log by primary key, locking their rows,
+// nil when auditing is off
+func (r *genericRepository[T, ID]) beforeWhere(db *gorm.DB, options operationOptions, conditions ...func(*gorm.DB) *gorm.DB) (map[string]*T, error) {
+	if !options.audit {
+		return nil, nil
+	}
+	query := db.Clauses(clause.Locking{Strength: "UPDATE"})
+	for _, condition := range conditions {
+		query = condition(query)
+	}
+	if _, ok := query.Get(predicateKey); ok {
+		return nil, errPredicate
+	}
+
+	var rows []T
+	if err := query.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	before := make(map[string]*T, len(rows))
+	for i := range rows {
+		before[r.primaryKey(&rows[i])] = &rows[i]
+	}
+	return before, nil
+}
+
+// conflictCondition matches the rows whose conflict columns equal those of one of entities
+func conflictCondition[T any](sch *schema.Schema, columns []clause.Column, entities []T) clause.Expression {
+	matches := make([]clause.Expression, len(entities))
+	for i := range entities {
+		value := reflect.ValueOf(&entities[i]).Elem()
+		equal := make([]clause.Expression, len(columns))
+		for j, column := range columns {
+			v, _ := sch.LookUpField(column.Name).ValueOf(context.Background(), value)
+			equal[j] = clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: column.Name}, Value: v}
+		}
+		matches[i] = clause.And(equal...)
+	}
+	return clause.Or(matches...)
+}
+
+// initVersions starts the Version of new entities at 1, like Create does
+func initVersions[T any](sch *schema.Schema, entities []T) {
+	field := versionField(sch)
+	if field == nil {
+		return
+	}
+	for i := range entities {
+		if getVersion(field, reflect.ValueOf(&entities[i]).Elem()) == 0 {
+			SetVersion(&entities[i], 1)
+		}
+	}
+}
+
+// uniqueConflictKeys rejects entities of which two have the same conflict columns, Postgres cannot update
+// one row twice in a statement
+func uniqueConflictKeys[T any](sch *schema.Schema, columns []clause.Column, entities []T) error {
+	seen := make(map[string]bool, len(entities))
+	for i := range entities {
+		value := reflect.ValueOf(&entities[i]).Elem()
+		key := make([]interface{}, len(columns))
+		for j, column := range columns {
+			key[j], _ = sch.LookUpField(column.Name).ValueOf(context.Background(), value)
+		}
+		data, err := json.Marshal(key)
+		if err != nil {
+			return err
+		}
+		if seen[string(data)] {
+			return &ErrValidation{Fields: map[string]string{columns[0].Name: "is duplicated in the batch"}}
+		}
+		seen[string(data)] = true
+	}
+	return nil
+}
+
+// upsertClause builds the ON CONFLICT clause of Upsert. Updated rows get a new UpdatedAt and Version,
+// soft deleted ones are restored.
+func upsertClause(sch *schema.Schema, conflictColumns, updateColumns []string) (clause.OnConflict, error) {
+	var onConflict clause.OnConflict
+	for _, name := range conflictColumns {
+		field := lookUpField(sch, name)
+		if field == nil || field.DBName == "" {
+			return onConflict, &ErrValidation{Fields: map[string]string{name: "is not a column"}}
+		}
+		onConflict.Columns = append(onConflict.Columns, clause.Column{Name: field.DBName})
+	}
+	if len(onConflict.Columns) == 0 {
+		return onConflict, &ErrValidation{Fields: map[string]string{"conflictColumns": "is required"}}
+	}
+
+	var columns []string
+	for _, name := range updateColumns {
+		field := lookUpField(sch, name)
+		if field == nil || field.DBName == "" || serverOwned(field) {
+			return onConflict, &ErrValidation{Fields: map[string]string{name: "cannot be changed"}, Err: ErrNotWritable}
+		}
+		columns = append(columns, field.DBName)
+	}
+	if len(columns) == 0 {
+		// a no-op update instead of DO NOTHING, so RETURNING reports the stored rows as well
+		onConflict.DoUpdates = append(clause.AssignmentColumns([]string{onConflict.Columns[0].Name}), restoreAssignment(sch)...)
+		return onConflict, nil
+	}
+
+	for _, field := range sch.Fields {
+		if field.AutoUpdateTime > 0 && field.DBName != "" {
+			columns = append(columns, field.DBName)
+		}
+	}
+	onConflict.DoUpdates = clause.AssignmentColumns(columns)
+	if field := versionField(sch); field != nil {
+		column := clause.Column{Table: clause.CurrentTable, Name: field.DBName}
+		onConflict.DoUpdates = append(onConflict.DoUpdates, clause.Assignment{
+			Column: clause.Column{Name: field.DBName},
+			Value:  gorm.Expr("? + 1", column),
+		})
+	}
+	onConflict.DoUpdates = append(onConflict.DoUpdates, restoreAssignment(sch)...)
+	return onConflict, nil
+}
+
+// restoreAssignment sets the DeletedAt column of a soft deleted row back to NULL, nothing when T has none
+func restoreAssignment(sch *schema.Schema) []clause.Assignment {
+	field := softDeleteField(sch)
+	if field == nil {
+		return nil
+	}
+	return []clause.Assignment{{Column: clause.Column{Name: field.DBName}, Value: nil}}
+}
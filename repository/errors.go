@@ -0,0 +1,167 @@
+package repository
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+// The repositories return errors of this file instead of driver errors, so callers can tell what went
+// wrong with errors.Is and errors.As. The original error stays reachable with errors.Unwrap.
+
+// ErrNotFound is returned when the entity does not exist (or is soft deleted). It also matches gorm.ErrRecordNotFound.
+var ErrNotFound = errors.New("not found")
+
+// errNotFound is ErrNotFound for code that has no GORM error to translate
+var errNotFound = translateError(gorm.ErrRecordNotFound)
+
+// ErrConflict is returned by Update when the entity was changed since it was read, i.e. its Version is outdated,
+// and when Postgres aborts a transaction that conflicts with a concurrent one
+var ErrConflict = errors.New("entity was changed concurrently")
+
+// ErrDuplicate is returned when a unique constraint is violated, e.g. a second user with the same email
+type ErrDuplicate struct {
+	Field string // column of the constraint, empty when Postgres does not tell
+	Err   error
+}
+
+func (e *ErrDuplicate) Error() string {
+	if e.Field == "" {
+		return "duplicate value"
+	}
+	return "duplicate value for " + e.Field
+}
+
+func (e *ErrDuplicate) Unwrap() error {
+	return e.Err
+}
+
+// ErrForeignKey is returned when a reference points to a row that does not exist, or a referenced row is deleted
+type ErrForeignKey struct {
+	Field string
+	Err   error
+}
+
+func (e *ErrForeignKey) Error() string {
+	if e.Field == "" {
+		return "invalid reference"
+	}
+	return "invalid reference in " + e.Field
+}
+
+func (e *ErrForeignKey) Unwrap() error {
+	return e.Err
+}
+
+// ErrValidation is returned for values the model or the database does not accept, with a message per field
+type ErrValidation struct {
+	Fields map[string]string
+	Err    error
+}
+
+func (e *ErrValidation) Error() string {
+	fields := make([]string, 0, len(e.Fields))
+	for field, message := range e.Fields {
+		fields = append(fields, field+" "+message)
+	}
+	sort.Strings(fields)
+	return "invalid " + strings.Join(fields, ", ")
+}
+
+func (e *ErrValidation) Unwrap() error {
+	return e.Err
+}
+
+// ErrPublish is returned when the change was saved but its events could not be published, e.g. because the
+// broker is down. Retrying the operation would repeat the change; publish through a publisher.Outbox or a
+// publisher.Retrying with dead letters to not lose events.
+type ErrPublish struct {
+	Err error
+}
+
+func (e *ErrPublish) Error() string {
+	return "saved, but publishing failed: " + e.Err.Error()
+}
+
+func (e *ErrPublish) Unwrap() error {
+	return e.Err
+}
+
+// publishError wraps err of publishing the events of a saved change
+func publishError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &ErrPublish{Err: err}
+}
+
+// sentinelError makes an error match a sentinel of this package while keeping the original as cause
+type sentinelError struct {
+	sentinel error
+	err      error
+}
+
+func (e *sentinelError) Error() string        { return e.sentinel.Error() }
+func (e *sentinelError) Is(target error) bool { return target == e.sentinel }
+func (e *sentinelError) Unwrap() error        { return e.err }
+
+// Postgres error codes, see https://www.postgresql.org/docs/current/errcodes-appendix.html
+const (
+	pgUniqueViolation      = "23505"
+	pgForeignKeyViolation  = "23503"
+	pgNotNullViolation     = "23502"
+	pgCheckViolation       = "23514"
+	pgSerializationFailure = "40001"
+	pgCardinalityViolation = "21000"
+)
+
+// pgKey reads the column out of a detail like: Key (email)=(ann@example.com) already exists.
+var pgKey = regexp.MustCompile(`Key \(([^)]+)\)=`)
+
+// translateError turns GORM and Postgres errors into the errors of this package, others are returned as they are
+func translateError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if errors.Is(err, ErrNotFound) {
+			return err
+		}
+		return &sentinelError{sentinel: ErrNotFound, err: err}
+	}
+
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return err
+	}
+
+	field := pgErr.ColumnName
+	if match := pgKey.FindStringSubmatch(pgErr.Detail); match != nil {
+		field = match[1]
+	}
+
+	switch pgErr.Code {
+	case pgUniqueViolation:
+		return &ErrDuplicate{Field: field, Err: err}
+	case pgForeignKeyViolation:
+		return &ErrForeignKey{Field: field, Err: err}
+	case pgNotNullViolation:
+		return &ErrValidation{Fields: map[string]string{field: "is required"}, Err: err}
+	case pgCheckViolation:
+		if field == "" {
+			field = pgErr.ConstraintName
+		}
+		return &ErrValidation{Fields: map[string]string{field: "fails a check constraint"}, Err: err}
+	case pgSerializationFailure:
+		return &sentinelError{sentinel: ErrConflict, err: err}
+	case pgCardinalityViolation:
+		// e.g. ON CONFLICT DO UPDATE hitting the same row twice
+		return &ErrValidation{Fields: map[string]string{"rows": "affect the same row more than once"}, Err: err}
+	}
+	return err
+}
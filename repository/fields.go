@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"reflect"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// WritableFields returns the JSON names of the fields of T a client may set: every column except the
+// primary key, the automatic create/update timestamps, the soft delete and the version column, which the server owns
+func WritableFields[T any]() []string {
+	var entity T
+	sch, err := schema.Parse(&entity, &querySchemas, schema.NamingStrategy{})
+	if err != nil {
+		return nil
+	}
+
+	var fields []string
+	for _, field := range sch.Fields {
+		if field.DBName == "" || serverOwned(field) {
+			continue
+		}
+		if name := jsonName(field); name != "" {
+			fields = append(fields, name)
+		}
+	}
+	return fields
+}
+
+// HasSoftDelete reports whether T has a gorm.DeletedAt field, i.e. whether Delete keeps the row
+func HasSoftDelete[T any]() bool {
+	var entity T
+	sch, err := schema.Parse(&entity, &querySchemas, schema.NamingStrategy{})
+	return err == nil && softDeleteField(sch) != nil
+}
+
+func serverOwned(field *schema.Field) bool {
+	return field.PrimaryKey ||
+		field.AutoCreateTime > 0 ||
+		field.AutoUpdateTime > 0 ||
+		field.FieldType == deletedAtType ||
+		isVersion(field)
+}
+
+var deletedAtType = reflect.TypeOf(gorm.DeletedAt{})
+
+// jsonName returns the key encoding/json uses for field, or "" when it is not encoded
+func jsonName(field *schema.Field) string {
+	tag := field.StructField.Tag.Get("json")
+	if tag == "-" {
+		return ""
+	}
+	if name, _, _ := strings.Cut(tag, ","); name != "" {
+		return name
+	}
+	return field.Name
+}
@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+// ParseID converts a path parameter into the ID type of a repository, e.g. ParseID[uint](c.Params("id"))
+func ParseID[ID comparable](s string) (ID, error) {
+	var id ID
+	var err error
+
+	switch p := any(&id).(type) {
+	case *uint:
+		var v uint64
+		v, err = strconv.ParseUint(s, 10, 0)
+		*p = uint(v)
+	case *uint32:
+		var v uint64
+		v, err = strconv.ParseUint(s, 10, 32)
+		*p = uint32(v)
+	case *uint64:
+		*p, err = strconv.ParseUint(s, 10, 64)
+	case *int:
+		var v int64
+		v, err = strconv.ParseInt(s, 10, 0)
+		*p = int(v)
+	case *int32:
+		var v int64
+		v, err = strconv.ParseInt(s, 10, 32)
+		*p = int32(v)
+	case *int64:
+		*p, err = strconv.ParseInt(s, 10, 64)
+	case *string:
+		if s == "" {
+			err = fmt.Errorf("empty ID")
+		}
+		*p = s
+	case *uuid.UUID:
+		*p, err = uuid.Parse(s)
+	default:
+		err = fmt.Errorf("unsupported ID type %T", id)
+	}
+
+	return id, err
+}
@@ -0,0 +1,626 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"gorepository/audit"
+	"gorepository/model"
+	"gorepository/publisher"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/callbacks"
+	"gorm.io/gorm/schema"
+)
+
+// NewMemoryRepository returns a GenericRepository keeping its entities in memory, so code using repositories
+// can be tested without Postgres. It is safe for concurrent use and supports ID assignment, soft delete,
+// paging, sorting and the conditions described in memory_query.go. A nil publisher publishes nothing.
+// opts are the defaults of every operation; WithAudit needs NewMemoryRepositories, which has an AuditRepo.
+func NewMemoryRepository[T any, ID comparable](pub publisher.Publisher, opts ...Option) GenericRepository[T, ID] {
+	if pub == nil {
+		pub = &publisher.NoopPublisher{}
+	}
+	return &memoryRepository[T, ID]{store: newMemoryStore[T, ID](), publisher: pub, defaults: options(opts)}
+}
+
+// NewMemoryRepositories returns Repositories backed by NewMemoryRepository, e.g. for app.Test of the routes.
+// Transaction only defers publishing, changes made before an error are not rolled back.
+func NewMemoryRepositories(pub publisher.Publisher, opts ...Option) *Repositories {
+	if pub == nil {
+		pub = &publisher.NoopPublisher{}
+	}
+	users := newMemoryStore[model.User, uint]()
+	posts := newMemoryStore[model.Post, uint]()
+	entries := newMemoryStore[audit.Entry, uint]()
+	deadLetters := newMemoryStore[publisher.DeadLetter, uint]()
+	webhooks := newMemoryStore[publisher.WebhookSubscription, uint]()
+	deliveries := newMemoryStore[publisher.WebhookDelivery, uint]()
+	defaults := options(opts)
+
+	var build func(ctx context.Context, pub publisher.Publisher) *Repositories
+	build = func(ctx context.Context, pub publisher.Publisher) *Repositories {
+		return &Repositories{
+			UserRepo:       &memoryRepository[model.User, uint]{store: users, publisher: pub, ctx: ctx, defaults: defaults, audit: entries},
+			PostRepo:       &memoryRepository[model.Post, uint]{store: posts, publisher: pub, ctx: ctx, defaults: defaults, audit: entries},
+			AuditRepo:      &memoryRepository[audit.Entry, uint]{store: entries, publisher: pub, ctx: ctx, defaults: options(internalRepoOptions)},
+			DeadLetterRepo: &memoryRepository[publisher.DeadLetter, uint]{store: deadLetters, publisher: pub, ctx: ctx, defaults: options(internalRepoOptions)},
+
+			WebhookRepo:         &memoryRepository[publisher.WebhookSubscription, uint]{store: webhooks, publisher: pub, ctx: ctx, defaults: options(internalRepoOptions)},
+			WebhookDeliveryRepo: &memoryRepository[publisher.WebhookDelivery, uint]{store: deliveries, publisher: pub, ctx: ctx, defaults: options(internalRepoOptions)},
+
+			publisher: pub,
+			opts:      opts,
+			memory:    build,
+			ctx:       ctx,
+		}
+	}
+	return build(nil, pub)
+}
+
+type memoryRepository[T any, ID comparable] struct {
+	store     *memoryStore[T, ID]
+	publisher publisher.Publisher
+	ctx       context.Context
+	defaults  operationOptions
+	audit     *memoryStore[audit.Entry, uint] // where WithAudit records changes, nil for NewMemoryRepository
+}
+
+type memoryStore[T any, ID comparable] struct {
+	mu     sync.RWMutex
+	schema *schema.Schema
+	rows   map[ID]T
+	order  []ID // insertion order, the order of rows without ORDER BY
+	nextID uint64
+}
+
+func newMemoryStore[T any, ID comparable]() *memoryStore[T, ID] {
+	var entity T
+	sch, err := schema.Parse(&entity, &querySchemas, schema.NamingStrategy{})
+	if err != nil {
+		panic(err)
+	}
+	if sch.PrioritizedPrimaryField == nil {
+		panic(fmt.Sprintf("repository: %s has no primary key", sch.Name))
+	}
+	return &memoryStore[T, ID]{schema: sch, rows: map[ID]T{}}
+}
+
+func (r *memoryRepository[T, ID]) WithContext(ctx context.Context) GenericRepository[T, ID] {
+	clone := *r
+	clone.ctx = ctx
+	return &clone
+}
+
+func (r *memoryRepository[T, ID]) GetAll() ([]T, error) {
+	var entities []T
+	err := r.GetWithConditions(&entities, nil)
+	return entities, err
+}
+
+func (r *memoryRepository[T, ID]) GetWithConditions(result interface{}, conditions []func(*gorm.DB) *gorm.DB, opts ...GORMOption) error {
+	if err := r.err(); err != nil {
+		return err
+	}
+
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	stmt, err := r.store.statement(conditions, opts)
+	if err != nil {
+		return err
+	}
+	rows, err := r.store.filter(stmt)
+	if err != nil {
+		return err
+	}
+	if rows, err = r.store.sort(stmt, rows); err != nil {
+		return err
+	}
+	return scanRows(paginate(stmt, rows), result)
+}
+
+func (r *memoryRepository[T, ID]) CountWithConditions(result *int64, conditions []func(*gorm.DB) *gorm.DB, opts ...GORMOption) error {
+	if err := r.err(); err != nil {
+		return err
+	}
+
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	stmt, err := r.store.statement(conditions, opts)
+	if err != nil {
+		return err
+	}
+	rows, err := r.store.filter(stmt)
+	*result = int64(len(rows))
+	return err
+}
+
+func (r *memoryRepository[T, ID]) ListPage(conditions []func(*gorm.DB) *gorm.DB, req PageRequest, opts ...GORMOption) (Page[T], error) {
+	return ListPageAs[T, T, ID](r, conditions, req, opts...)
+}
+
+func (r *memoryRepository[T, ID]) FindByID(id ID) (T, error) {
+	var entity T
+	if err := r.err(); err != nil {
+		return entity, err
+	}
+
+	r.store.mu.RLock()
+	defer r.store.mu.RUnlock()
+
+	entity, ok := r.store.rows[id]
+	if !ok || r.store.deleted(entity) {
+		var zero T
+		return zero, errNotFound
+	}
+	return entity, nil
+}
+
+func (r *memoryRepository[T, ID]) Create(entity T, opts ...Option) (T, error) {
+	options := r.options(opts)
+	if err := r.err(); err != nil {
+		return entity, err
+	}
+
+	r.store.mu.Lock()
+	if err := r.store.insert(&entity); err != nil {
+		r.store.mu.Unlock()
+		return entity, err
+	}
+	r.store.mu.Unlock()
+
+	return entity, r.commit(options, change[T]{action: publisher.Created, id: fmt.Sprint(r.store.id(&entity)), after: &entity})
+}
+
+func (r *memoryRepository[T, ID]) Update(entity T, opts ...Option) (T, error) {
+	options := r.options(opts)
+	if err := r.err(); err != nil {
+		return entity, err
+	}
+
+	r.store.mu.Lock()
+	before := r.store.row(r.store.id(&entity))
+	if err := r.store.update(&entity); err != nil {
+		r.store.mu.Unlock()
+		return entity, err
+	}
+	r.store.mu.Unlock()
+
+	return entity, r.commit(options, change[T]{action: publisher.Updated, id: fmt.Sprint(r.store.id(&entity)), before: before, after: &entity})
+}
+
+func (r *memoryRepository[T, ID]) Patch(id ID, changes map[string]interface{}, opts ...Option) (T, error) {
+	options := r.options(opts)
+	var entity T
+	if err := r.err(); err != nil {
+		return entity, err
+	}
+
+	columns, err := patchColumns(r.store.schema, changes)
+	if err != nil {
+		return entity, err
+	}
+
+	r.store.mu.Lock()
+	entity, ok := r.store.rows[id]
+	if !ok || r.store.deleted(entity) {
+		r.store.mu.Unlock()
+		var zero T
+		return zero, errNotFound
+	}
+	if len(columns) == 0 {
+		r.store.mu.Unlock()
+		return entity, nil
+	}
+
+	if field := versionField(r.store.schema); field != nil && options.version != nil {
+		if *options.version != getVersion(field, reflect.ValueOf(&entity).Elem()) {
+			r.store.mu.Unlock()
+			return entity, ErrConflict
+		}
+	}
+	before := entity
+	if err := r.store.assign(&entity, columns); err != nil {
+		r.store.mu.Unlock()
+		var zero T
+		return zero, err
+	}
+	r.store.rows[id] = entity
+	r.store.mu.Unlock()
+
+	return entity, r.commit(options, change[T]{action: publisher.Updated, id: fmt.Sprint(id), before: &before, after: &entity})
+}
+
+func (r *memoryRepository[T, ID]) Delete(id ID, opts ...Option) error {
+	options := r.options(opts)
+	if err := r.err(); err != nil {
+		return err
+	}
+
+	r.store.mu.Lock()
+	entity, ok := r.store.rows[id]
+	if !ok || r.store.deleted(entity) {
+		r.store.mu.Unlock()
+		return nil // like gorm, deleting nothing is no error
+	}
+
+	c, err := r.store.delete(id, entity)
+	r.store.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return r.commit(options, c)
+}
+
+func (r *memoryRepository[T, ID]) HardDelete(id ID, opts ...Option) error {
+	options := r.options(opts)
+	if err := r.err(); err != nil {
+		return err
+	}
+
+	r.store.mu.Lock()
+	before := r.store.row(id)
+	r.store.remove(id)
+	r.store.mu.Unlock()
+
+	if before == nil {
+		return nil
+	}
+	return r.commit(options, change[T]{action: publisher.Purged, id: fmt.Sprint(id), before: before})
+}
+
+func (r *memoryRepository[T, ID]) Restore(id ID, opts ...Option) (T, error) {
+	options := r.options(opts)
+	var entity T
+	if err := r.err(); err != nil {
+		return entity, err
+	}
+
+	field := softDeleteField(r.store.schema)
+	if field == nil {
+		return entity, ErrNoSoftDelete
+	}
+
+	r.store.mu.Lock()
+	entity, ok := r.store.rows[id]
+	if !ok || !r.store.deleted(entity) {
+		r.store.mu.Unlock()
+		var zero T
+		return zero, errNotFound
+	}
+	before := entity
+	if err := field.Set(context.Background(), reflect.ValueOf(&entity).Elem(), gorm.DeletedAt{}); err != nil {
+		r.store.mu.Unlock()
+		return entity, err
+	}
+	r.store.touch(&entity, false)
+	r.store.rows[id] = entity
+	r.store.mu.Unlock()
+
+	return entity, r.commit(options, change[T]{action: publisher.Restored, id: fmt.Sprint(id), before: &before, after: &entity})
+}
+
+func (r *memoryRepository[T, ID]) PurgeDeleted(before time.Time, opts ...Option) (int64, error) {
+	options := r.options(opts)
+	if err := r.err(); err != nil {
+		return 0, err
+	}
+
+	field := softDeleteField(r.store.schema)
+	if field == nil {
+		return 0, ErrNoSoftDelete
+	}
+
+	var purged []change[T]
+	r.store.mu.Lock()
+	for _, id := range append([]ID{}, r.store.order...) {
+		entity := r.store.rows[id]
+		value, _ := field.ValueOf(context.Background(), reflect.ValueOf(&entity).Elem())
+		if deletedAt, ok := value.(gorm.DeletedAt); ok && deletedAt.Valid && deletedAt.Time.Before(before) {
+			r.store.remove(id)
+			purged = append(purged, change[T]{action: publisher.Purged, id: fmt.Sprint(id), before: &entity})
+		}
+	}
+	r.store.mu.Unlock()
+
+	return int64(len(purged)), r.commit(options, purged...)
+}
+
+func (r *memoryRepository[T, ID]) options(opts []Option) operationOptions {
+	options := r.defaults
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return options
+}
+
+// commit records changes in the audit log when auditing and publishes them
+func (r *memoryRepository[T, ID]) commit(options operationOptions, changes ...change[T]) error {
+	if options.audit && r.audit != nil {
+		entries, err := auditEntries(r.ctx, changes)
+		if err != nil {
+			return err
+		}
+		r.audit.mu.Lock()
+		for i := range entries {
+			_ = r.audit.insert(&entries[i])
+		}
+		r.audit.mu.Unlock()
+	}
+	if options.publish {
+		return publishError(publisher.PublishAll(r.publisher, events(changes)))
+	}
+	return nil
+}
+
+// options applies opts to the default options
+func options(opts []Option) operationOptions {
+	options := defaultOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return options
+}
+
+func (r *memoryRepository[T, ID]) err() error {
+	if r.ctx == nil {
+		return nil
+	}
+	return r.ctx.Err()
+}
+
+// insert assigns an ID to a new entity, sets its timestamps and stores it. The caller holds the lock.
+func (s *memoryStore[T, ID]) insert(entity *T) error {
+	if err := hook(entity, true); err != nil {
+		return err
+	}
+
+	pk := s.schema.PrioritizedPrimaryField
+	value := reflect.ValueOf(entity).Elem()
+	if _, zero := pk.ValueOf(context.Background(), value); zero {
+		if err := pk.Set(context.Background(), value, s.newID()); err != nil {
+			return err
+		}
+	} else if n, ok := numericID(pk.ReflectValueOf(context.Background(), value)); ok && n > s.nextID {
+		s.nextID = n // newID never hands out an ID the caller supplied
+	}
+
+	id := s.id(entity)
+	if _, exists := s.rows[id]; exists {
+		return &ErrDuplicate{Field: pk.DBName}
+	}
+	if err := s.unique(id, entity); err != nil {
+		return err
+	}
+	if field := versionField(s.schema); field != nil && getVersion(field, value) == 0 {
+		if err := field.Set(context.Background(), value, int64(1)); err != nil {
+			return err
+		}
+	}
+
+	s.touch(entity, true)
+	s.rows[id] = *entity
+	s.order = append(s.order, id)
+	return nil
+}
+
+// update replaces a stored entity the way update does in the database. The caller holds the lock.
+func (s *memoryStore[T, ID]) update(entity *T) error {
+	value := reflect.ValueOf(entity).Elem()
+	if _, zero := s.schema.PrioritizedPrimaryField.ValueOf(context.Background(), value); zero {
+		return &ErrValidation{Fields: map[string]string{s.schema.PrioritizedPrimaryField.Name: "is required"}, Err: gorm.ErrPrimaryKeyRequired}
+	}
+	id := s.id(entity)
+	stored, ok := s.rows[id]
+	if !ok || s.deleted(stored) {
+		return errNotFound
+	}
+
+	field := versionField(s.schema)
+	var version int64
+	if field != nil {
+		version = getVersion(field, value)
+		if version != getVersion(field, reflect.ValueOf(&stored).Elem()) {
+			return ErrConflict
+		}
+	}
+
+	if err := hook(entity, false); err != nil {
+		return err
+	}
+	if err := s.unique(id, entity); err != nil {
+		return err
+	}
+	storedValue := reflect.ValueOf(&stored).Elem()
+	for _, f := range s.schema.Fields {
+		if f.AutoCreateTime > 0 {
+			v, _ := f.ValueOf(context.Background(), storedValue)
+			if err := f.Set(context.Background(), value, v); err != nil {
+				return err
+			}
+		}
+	}
+	if field != nil {
+		if err := field.Set(context.Background(), value, version+1); err != nil {
+			return err
+		}
+	}
+	s.touch(entity, false)
+	s.rows[id] = *entity
+	return nil
+}
+
+// assign sets columns of a stored entity like an UPDATE, incrementing its Version. The caller holds the lock.
+func (s *memoryStore[T, ID]) assign(entity *T, columns map[string]interface{}) error {
+	value := reflect.ValueOf(entity).Elem()
+	for column, v := range columns {
+		if err := s.schema.LookUpField(column).Set(context.Background(), value, v); err != nil {
+			return err
+		}
+	}
+	if field := versionField(s.schema); field != nil {
+		if err := field.Set(context.Background(), value, getVersion(field, value)+1); err != nil {
+			return err
+		}
+	}
+	s.touch(entity, false)
+	return nil
+}
+
+// snapshot returns a function that puts the rows back as they are now, to undo an operation on several rows.
+// The caller holds the lock.
+func (s *memoryStore[T, ID]) snapshot() (restore func()) {
+	rows := make(map[ID]T, len(s.rows))
+	for id, row := range s.rows {
+		rows[id] = row
+	}
+	order := append([]ID{}, s.order...)
+	nextID := s.nextID
+	return func() {
+		s.rows, s.order, s.nextID = rows, order, nextID
+	}
+}
+
+// unique checks the unique columns of entity against every other row, soft deleted ones included like in Postgres
+func (s *memoryStore[T, ID]) unique(id ID, entity *T) error {
+	value := reflect.ValueOf(entity).Elem()
+	for _, field := range s.schema.Fields {
+		if !field.Unique || field.DBName == "" {
+			continue
+		}
+		v := fieldValue(field, value)
+		if v == nil {
+			continue
+		}
+		for otherID, other := range s.rows {
+			if otherID == id {
+				continue
+			}
+			if c, ok := compare(v, fieldValue(field, reflect.ValueOf(&other).Elem())); ok && c == 0 {
+				return &ErrDuplicate{Field: field.DBName}
+			}
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore[T, ID]) newID() interface{} {
+	var id ID
+	switch any(id).(type) {
+	case uuid.UUID:
+		return uuid.New()
+	case string:
+		return uuid.NewString()
+	default:
+		s.nextID++
+		return s.nextID
+	}
+}
+
+// numericID returns an integer primary key as uint64
+func numericID(v reflect.Value) (uint64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.Int() < 0 {
+			return 0, false
+		}
+		return uint64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint(), true
+	}
+	return 0, false
+}
+
+func (s *memoryStore[T, ID]) id(entity *T) ID {
+	value, _ := s.schema.PrioritizedPrimaryField.ValueOf(context.Background(), reflect.ValueOf(entity).Elem())
+	id, _ := value.(ID)
+	return id
+}
+
+// row returns a copy of the stored row with id, soft deleted or not, nil when there is none. The caller holds the lock.
+func (s *memoryStore[T, ID]) row(id ID) *T {
+	row, ok := s.rows[id]
+	if !ok {
+		return nil
+	}
+	return &row
+}
+
+// delete soft deletes entity, the stored row with id, or removes it when T has no soft delete. The caller holds the lock.
+func (s *memoryStore[T, ID]) delete(id ID, entity T) (change[T], error) {
+	field := softDeleteField(s.schema)
+	if field == nil {
+		s.remove(id)
+		return change[T]{action: publisher.Purged, id: fmt.Sprint(id), before: &entity}, nil
+	}
+
+	before := entity
+	deletedAt := gorm.DeletedAt{Time: time.Now(), Valid: true}
+	if err := field.Set(context.Background(), reflect.ValueOf(&entity).Elem(), deletedAt); err != nil {
+		return change[T]{}, err
+	}
+	s.rows[id] = entity
+	return change[T]{action: publisher.Deleted, id: fmt.Sprint(id), before: &before, after: &entity}, nil
+}
+
+func (s *memoryStore[T, ID]) remove(id ID) {
+	if _, ok := s.rows[id]; !ok {
+		return
+	}
+	delete(s.rows, id)
+	for i, o := range s.order {
+		if o == id {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// touch sets the automatic timestamps the way gorm does
+func (s *memoryStore[T, ID]) touch(entity *T, created bool) {
+	now := time.Now()
+	value := reflect.ValueOf(entity).Elem()
+	for _, field := range s.schema.Fields {
+		if created && field.AutoCreateTime > 0 {
+			if _, zero := field.ValueOf(context.Background(), value); zero {
+				_ = field.Set(context.Background(), value, now)
+			}
+		}
+		if field.AutoUpdateTime > 0 {
+			_ = field.Set(context.Background(), value, now)
+		}
+	}
+}
+
+func (s *memoryStore[T, ID]) deleted(entity T) bool {
+	field := softDeleteField(s.schema)
+	if field == nil {
+		return false
+	}
+	value, _ := field.ValueOf(context.Background(), reflect.ValueOf(&entity).Elem())
+	deletedAt, ok := value.(gorm.DeletedAt)
+	return ok && deletedAt.Valid
+}
+
+// hook runs the BeforeSave and BeforeCreate or BeforeUpdate hook of entity, the After* hooks are not supported
+func hook[T any](entity *T, create bool) error {
+	db := memoryDB()
+	if h, ok := any(entity).(callbacks.BeforeSaveInterface); ok {
+		if err := h.BeforeSave(db); err != nil {
+			return err
+		}
+	}
+	if h, ok := any(entity).(callbacks.BeforeCreateInterface); ok && create {
+		return h.BeforeCreate(db)
+	}
+	if h, ok := any(entity).(callbacks.BeforeUpdateInterface); ok && !create {
+		return h.BeforeUpdate(db)
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"gorepository/publisher"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// CreateMany inserts every entity or, when one fails, none of them; batchSize does not matter in memory
+func (r *memoryRepository[T, ID]) CreateMany(entities []T, batchSize int, opts ...Option) ([]T, error) {
+	options := r.options(opts)
+	if err := r.err(); err != nil {
+		return entities, err
+	}
+
+	r.store.mu.Lock()
+	restore := r.store.snapshot()
+	for i := range entities {
+		if err := r.store.insert(&entities[i]); err != nil {
+			restore()
+			r.store.mu.Unlock()
+			return entities, err
+		}
+	}
+	r.store.mu.Unlock()
+
+	return entities, r.commit(options, r.changes(entities, publisher.Created, nil)...)
+}
+
+// Upsert matches entities to stored rows, soft deleted ones included, by the values of conflictColumns
+func (r *memoryRepository[T, ID]) Upsert(entities []T, conflictColumns, updateColumns []string, opts ...Option) ([]T, error) {
+	options := r.options(opts)
+	if err := r.err(); err != nil {
+		return entities, err
+	}
+
+	// the same checks as the ON CONFLICT clause
+	onConflict, err := upsertClause(r.store.schema, conflictColumns, updateColumns)
+	if err != nil {
+		return entities, err
+	}
+	if err := uniqueConflictKeys(r.store.schema, onConflict.Columns, entities); err != nil {
+		return entities, err
+	}
+	var updates []string
+	for _, name := range updateColumns {
+		updates = append(updates, lookUpField(r.store.schema, name).DBName)
+	}
+
+	r.store.mu.Lock()
+	restore := r.store.snapshot()
+	before := map[string]*T{}
+	for i := range entities {
+		stored, err := r.store.upsert(&entities[i], onConflict.Columns, updates)
+		if err != nil {
+			restore()
+			r.store.mu.Unlock()
+			return entities, err
+		}
+		if stored != nil {
+			before[fmt.Sprint(r.store.id(stored))] = stored
+		}
+	}
+	r.store.mu.Unlock()
+
+	return entities, r.commit(options, r.changes(entities, publisher.Upserted, before)...)
+}
+
+func (r *memoryRepository[T, ID]) UpdateWhere(conditions []func(*gorm.DB) *gorm.DB, changes map[string]interface{}, opts ...Option) (int64, error) {
+	options := r.options(opts)
+	if err := r.err(); err != nil {
+		return 0, err
+	}
+
+	if len(conditions) == 0 {
+		return 0, gorm.ErrMissingWhereClause
+	}
+	columns, err := patchColumns(r.store.schema, changes)
+	if err != nil || len(columns) == 0 {
+		return 0, err
+	}
+
+	r.store.mu.Lock()
+	updated, err := r.store.where(conditions)
+	if err != nil {
+		r.store.mu.Unlock()
+		return 0, err
+	}
+	restore := r.store.snapshot()
+	before := map[string]*T{}
+	for i := range updated {
+		id := r.store.id(&updated[i])
+		before[fmt.Sprint(id)] = r.store.row(id)
+		if err := r.store.assign(&updated[i], columns); err != nil {
+			restore()
+			r.store.mu.Unlock()
+			return 0, err
+		}
+		if err := r.store.unique(id, &updated[i]); err != nil {
+			restore()
+			r.store.mu.Unlock()
+			return 0, err
+		}
+		r.store.rows[id] = updated[i]
+	}
+	r.store.mu.Unlock()
+
+	return int64(len(updated)), r.commit(options, r.changes(updated, publisher.Updated, before)...)
+}
+
+func (r *memoryRepository[T, ID]) DeleteWhere(conditions []func(*gorm.DB) *gorm.DB, opts ...Option) (int64, error) {
+	options := r.options(opts)
+	if err := r.err(); err != nil {
+		return 0, err
+	}
+
+	if len(conditions) == 0 {
+		return 0, gorm.ErrMissingWhereClause
+	}
+
+	r.store.mu.Lock()
+	deleted, err := r.store.where(conditions)
+	if err != nil {
+		r.store.mu.Unlock()
+		return 0, err
+	}
+	changes := make([]change[T], len(deleted))
+	for i := range deleted {
+		if changes[i], err = r.store.delete(r.store.id(&deleted[i]), deleted[i]); err != nil {
+			r.store.mu.Unlock()
+			return 0, err
+		}
+	}
+	r.store.mu.Unlock()
+
+	return int64(len(deleted)), r.commit(options, changes...)
+}
+
+func (r *memoryRepository[T, ID]) changes(entities []T, action publisher.Action, before map[string]*T) []change[T] {
+	changes := make([]change[T], len(entities))
+	for i := range entities {
+		id := fmt.Sprint(r.store.id(&entities[i]))
+		changes[i] = change[T]{action: action, id: id, before: before[id], after: &entities[i]}
+	}
+	return changes
+}
+
+// where returns the rows matching conditions. The caller holds the lock.
+func (s *memoryStore[T, ID]) where(conditions []func(*gorm.DB) *gorm.DB) ([]T, error) {
+	stmt, err := s.statement(conditions, nil)
+	if err != nil {
+		return nil, err
+	}
+	return s.filter(stmt)
+}
+
+// upsert updates the row whose conflict columns equal those of entity, or inserts entity when there is none.
+// entity becomes the stored row, the row before is returned when there was one. The caller holds the lock.
+func (s *memoryStore[T, ID]) upsert(entity *T, conflictColumns []clause.Column, updateColumns []string) (*T, error) {
+	value := reflect.ValueOf(entity).Elem()
+	for _, id := range s.order {
+		stored := s.rows[id]
+		storedValue := reflect.ValueOf(&stored).Elem()
+		if !s.conflicts(value, storedValue, conflictColumns) {
+			continue
+		}
+		before := stored
+		if len(updateColumns) > 0 {
+			columns := make(map[string]interface{}, len(updateColumns))
+			for _, column := range updateColumns {
+				columns[column], _ = s.schema.LookUpField(column).ValueOf(context.Background(), value)
+			}
+			if err := s.assign(&stored, columns); err != nil {
+				return nil, err
+			}
+			if err := s.unique(id, &stored); err != nil {
+				return nil, err
+			}
+			s.rows[id] = stored
+		}
+		if field := softDeleteField(s.schema); field != nil && s.deleted(stored) {
+			_ = field.Set(context.Background(), reflect.ValueOf(&stored).Elem(), gorm.DeletedAt{})
+			s.rows[id] = stored
+		}
+		*entity = stored
+		return &before, nil
+	}
+	return nil, s.insert(entity)
+}
+
+// conflicts reports whether two rows have equal values in every one of columns
+func (s *memoryStore[T, ID]) conflicts(a, b reflect.Value, columns []clause.Column) bool {
+	for _, column := range columns {
+		field := s.schema.LookUpField(column.Name)
+		if c, ok := compare(fieldValue(field, a), fieldValue(field, b)); !ok || c != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,650 @@
+package repository
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// The memory repository runs conditions and GORMOptions against a dry run *gorm.DB and evaluates the clauses
+// they leave on the statement. Supported are WHERE clauses built from clause.Eq, Neq, Gt, Gte, Lt, Lte, Like,
+// IN, And, Or and Not (everything Query and the keyset paging produce), simple SQL like
+// Where("name = ? AND age > ?", ...), Unscoped, ORDER BY columns, LIMIT and OFFSET. Joins, Select, Group and
+// Preload are ignored. Anything else can be written as a Predicate.
+
+const predicateKey = "repository:predicate"
+
+var errPredicate = errors.New("predicate conditions are only supported by the memory repository")
+
+// Predicate is a condition written in Go, e.g. for a test double of a query the memory repository cannot evaluate.
+// The GORM repository returns an error for it.
+func Predicate[T any](fn func(T) bool) GORMOption {
+	return func(db *gorm.DB) *gorm.DB {
+		var predicates []func(interface{}) bool
+		if existing, ok := db.Get(predicateKey); ok {
+			predicates = existing.([]func(interface{}) bool)
+		}
+		predicates = append(predicates, func(v interface{}) bool {
+			entity, ok := v.(T)
+			return ok && fn(entity)
+		})
+		return db.Set(predicateKey, predicates)
+	}
+}
+
+var (
+	memoryOnce sync.Once
+	memoryGorm *gorm.DB
+)
+
+// memoryDB is a *gorm.DB that only builds statements, it never reaches a database
+func memoryDB() *gorm.DB {
+	memoryOnce.Do(func() {
+		db, err := gorm.Open(memoryDialector{}, &gorm.Config{DryRun: true, DisableAutomaticPing: true})
+		if err != nil {
+			panic(err)
+		}
+		memoryGorm = db
+	})
+	return memoryGorm
+}
+
+type memoryDialector struct{}
+
+func (memoryDialector) Name() string                                                { return "memory" }
+func (memoryDialector) Initialize(*gorm.DB) error                                   { return nil }
+func (memoryDialector) Migrator(*gorm.DB) gorm.Migrator                             { return nil }
+func (memoryDialector) DataTypeOf(*schema.Field) string                             { return "" }
+func (memoryDialector) DefaultValueOf(*schema.Field) clause.Expression              { return clause.Expr{} }
+func (memoryDialector) BindVarTo(w clause.Writer, _ *gorm.Statement, _ interface{}) { w.WriteByte('?') }
+func (memoryDialector) QuoteTo(w clause.Writer, s string)                           { w.WriteString(s) }
+func (memoryDialector) Explain(sql string, _ ...interface{}) string                 { return sql }
+
+// statement applies conditions and opts to a query of T
+func (s *memoryStore[T, ID]) statement(conditions []func(*gorm.DB) *gorm.DB, opts []GORMOption) (*gorm.Statement, error) {
+	var entity T
+	query := memoryDB().Model(&entity)
+	for _, condition := range conditions {
+		query = condition(query)
+	}
+	for _, opt := range opts {
+		query = opt(query)
+	}
+	return query.Statement, query.Error
+}
+
+// filter returns the rows matching the WHERE clause and predicates of stmt in insertion order
+func (s *memoryStore[T, ID]) filter(stmt *gorm.Statement) ([]T, error) {
+	match := func(reflect.Value) bool { return true }
+	if c, ok := stmt.Clauses["WHERE"]; ok {
+		var err error
+		if match, err = s.compile(c.Expression); err != nil {
+			return nil, err
+		}
+	}
+
+	var predicates []func(interface{}) bool
+	if p, ok := stmt.Settings.Load(predicateKey); ok {
+		predicates = p.([]func(interface{}) bool)
+	}
+
+	rows := []T{}
+rows:
+	for _, id := range s.order {
+		entity := s.rows[id]
+		if !stmt.Unscoped && s.deleted(entity) {
+			continue
+		}
+		if !match(reflect.ValueOf(&entity).Elem()) {
+			continue
+		}
+		for _, predicate := range predicates {
+			if !predicate(entity) {
+				continue rows
+			}
+		}
+		rows = append(rows, entity)
+	}
+	return rows, nil
+}
+
+// sort orders rows by the ORDER BY clause of stmt, NULLs last ascending and first descending like Postgres
+func (s *memoryStore[T, ID]) sort(stmt *gorm.Statement, rows []T) ([]T, error) {
+	c, ok := stmt.Clauses["ORDER BY"]
+	if !ok {
+		return rows, nil
+	}
+	orderBy, ok := c.Expression.(clause.OrderBy)
+	if !ok || orderBy.Expression != nil {
+		return nil, errors.New("memory repository: unsupported ORDER BY expression")
+	}
+
+	type key struct {
+		field *schema.Field
+		desc  bool
+	}
+	var keys []key
+	for _, column := range orderBy.Columns {
+		if !column.Column.Raw {
+			field, err := s.field(column.Column)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key{field, column.Desc})
+			continue
+		}
+		// Order("name DESC, id")
+		for _, item := range strings.Split(column.Column.Name, ",") {
+			parts := strings.Fields(item)
+			if len(parts) == 0 || len(parts) > 2 {
+				return nil, fmt.Errorf("memory repository: unsupported order %q", item)
+			}
+			field, err := s.field(parts[0])
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key{field, len(parts) == 2 && strings.EqualFold(parts[1], "desc")})
+		}
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		a, b := reflect.ValueOf(&rows[i]).Elem(), reflect.ValueOf(&rows[j]).Elem()
+		for _, k := range keys {
+			va, vb := fieldValue(k.field, a), fieldValue(k.field, b)
+			var c int
+			switch {
+			case va == nil && vb == nil:
+				c = 0
+			case va == nil:
+				c = 1
+			case vb == nil:
+				c = -1
+			default:
+				c, _ = compare(va, vb)
+			}
+			if c != 0 {
+				return (c < 0) != k.desc
+			}
+		}
+		return false
+	})
+	return rows, nil
+}
+
+// paginate applies the LIMIT and OFFSET of stmt
+func paginate[T any](stmt *gorm.Statement, rows []T) []T {
+	c, ok := stmt.Clauses["LIMIT"]
+	if !ok {
+		return rows
+	}
+	limit, ok := c.Expression.(clause.Limit)
+	if !ok {
+		return rows
+	}
+	if limit.Offset > 0 {
+		if limit.Offset >= len(rows) {
+			return rows[:0]
+		}
+		rows = rows[limit.Offset:]
+	}
+	if limit.Limit != nil && *limit.Limit >= 0 && *limit.Limit < len(rows) {
+		rows = rows[:*limit.Limit]
+	}
+	return rows
+}
+
+// scanRows copies rows into result, a *[]T or a pointer to a slice of another struct (e.g. a DTO)
+// whose fields are filled from the columns of the same name
+func scanRows[T any](rows []T, result interface{}) error {
+	if dest, ok := result.(*[]T); ok {
+		*dest = append((*dest)[:0], rows...)
+		return nil
+	}
+
+	value := reflect.ValueOf(result)
+	if value.Kind() != reflect.Ptr || value.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("memory repository: cannot scan into %T", result)
+	}
+	slice := value.Elem()
+	elemType := slice.Type().Elem()
+
+	var entity T
+	src, err := schema.Parse(&entity, &querySchemas, schema.NamingStrategy{})
+	if err != nil {
+		return err
+	}
+	dst, err := schema.Parse(reflect.New(elemType).Interface(), &querySchemas, schema.NamingStrategy{})
+	if err != nil {
+		return err
+	}
+
+	slice.Set(reflect.MakeSlice(slice.Type(), 0, len(rows)))
+	for i := range rows {
+		row := reflect.ValueOf(&rows[i]).Elem()
+		item := reflect.New(elemType).Elem()
+		for _, field := range dst.Fields {
+			from := src.LookUpField(field.DBName)
+			if field.DBName == "" || from == nil {
+				continue
+			}
+			v, _ := from.ValueOf(context.Background(), row)
+			if err := field.Set(context.Background(), item, v); err != nil {
+				return err
+			}
+		}
+		slice.Set(reflect.Append(slice, item))
+	}
+	return nil
+}
+
+// field resolves a column of a condition or order to a field of T
+func (s *memoryStore[T, ID]) field(column interface{}) (*schema.Field, error) {
+	var name string
+	switch c := column.(type) {
+	case clause.Column:
+		if c.Name == clause.PrimaryKey {
+			return s.schema.PrioritizedPrimaryField, nil
+		}
+		if c.Table != "" && c.Table != clause.CurrentTable && c.Table != s.schema.Table {
+			return nil, fmt.Errorf("memory repository: column %s.%s is not a column of %s", c.Table, c.Name, s.schema.Table)
+		}
+		name = c.Name
+	case string:
+		name = c
+	default:
+		return nil, fmt.Errorf("memory repository: unsupported column %T", column)
+	}
+
+	name = strings.Trim(name, `"`)
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		if table := strings.Trim(name[:i], `"`); table != s.schema.Table {
+			return nil, fmt.Errorf("memory repository: column %s is not a column of %s", name, s.schema.Table)
+		}
+		name = strings.Trim(name[i+1:], `"`)
+	}
+
+	field := s.schema.LookUpField(name)
+	if field == nil {
+		return nil, fmt.Errorf("memory repository: %s has no column %q", s.schema.Name, name)
+	}
+	return field, nil
+}
+
+type matcher func(row reflect.Value) bool
+
+// compile turns a WHERE expression into a matcher
+func (s *memoryStore[T, ID]) compile(expr clause.Expression) (matcher, error) {
+	switch e := expr.(type) {
+	case clause.Where:
+		return s.all(e.Exprs)
+	case clause.AndConditions:
+		return s.all(e.Exprs)
+	case clause.OrConditions:
+		var ms []matcher
+		for _, expr := range e.Exprs {
+			m, err := s.compile(expr)
+			if err != nil {
+				return nil, err
+			}
+			ms = append(ms, m)
+		}
+		return func(row reflect.Value) bool {
+			for _, m := range ms {
+				if m(row) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	case clause.NotConditions:
+		m, err := s.all(e.Exprs)
+		if err != nil {
+			return nil, err
+		}
+		return func(row reflect.Value) bool { return !m(row) }, nil
+	case clause.Eq:
+		return s.comparison(e.Column, "=", e.Value)
+	case clause.Neq:
+		return s.comparison(e.Column, "<>", e.Value)
+	case clause.Gt:
+		return s.comparison(e.Column, ">", e.Value)
+	case clause.Gte:
+		return s.comparison(e.Column, ">=", e.Value)
+	case clause.Lt:
+		return s.comparison(e.Column, "<", e.Value)
+	case clause.Lte:
+		return s.comparison(e.Column, "<=", e.Value)
+	case clause.Like:
+		return s.comparison(e.Column, "LIKE", e.Value)
+	case clause.IN:
+		return s.comparison(e.Column, "IN", e.Values)
+	case clause.Expr:
+		return s.expr(e)
+	default:
+		return nil, fmt.Errorf("memory repository: unsupported condition %T, use Predicate", expr)
+	}
+}
+
+func (s *memoryStore[T, ID]) all(exprs []clause.Expression) (matcher, error) {
+	var ms []matcher
+	for _, expr := range exprs {
+		m, err := s.compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		ms = append(ms, m)
+	}
+	return func(row reflect.Value) bool {
+		for _, m := range ms {
+			if !m(row) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+var (
+	andSplit       = regexp.MustCompile(`(?i)\s+AND\s+`)
+	exprComparison = regexp.MustCompile(`(?i)^\s*(\?|[\w."]+)\s*(=|<>|!=|<=|>=|<|>|NOT\s+LIKE|LIKE|NOT\s+ILIKE|ILIKE|NOT\s+IN|IN|IS\s+NOT|IS)\s*(\?|\(\s*\?\s*\)|NULL)\s*$`)
+)
+
+// expr compiles SQL of the form "a = ? AND b IS NULL AND ? ILIKE ?", the only SQL the memory repository understands
+func (s *memoryStore[T, ID]) expr(e clause.Expr) (matcher, error) {
+	vars := e.Vars
+	next := func() (interface{}, error) {
+		if len(vars) == 0 {
+			return nil, fmt.Errorf("memory repository: missing value in %q", e.SQL)
+		}
+		v := vars[0]
+		vars = vars[1:]
+		return v, nil
+	}
+
+	var ms []matcher
+	for _, part := range andSplit.Split(strings.TrimSpace(e.SQL), -1) {
+		match := exprComparison.FindStringSubmatch(part)
+		if match == nil {
+			return nil, fmt.Errorf("memory repository: unsupported condition %q, use Predicate", e.SQL)
+		}
+
+		var column interface{} = match[1]
+		if match[1] == "?" {
+			v, err := next()
+			if err != nil {
+				return nil, err
+			}
+			column = v
+		}
+
+		op := strings.ToUpper(strings.Join(strings.Fields(match[2]), " "))
+		var value interface{}
+		if match[3] != "NULL" && !strings.EqualFold(match[3], "null") {
+			v, err := next()
+			if err != nil {
+				return nil, err
+			}
+			value = v
+		}
+		switch op {
+		case "IS":
+			op = "="
+		case "IS NOT", "!=":
+			op = "<>"
+		case "IN", "NOT IN":
+			value = expand(value)
+		}
+
+		m, err := s.comparison(column, op, value)
+		if err != nil {
+			return nil, err
+		}
+		ms = append(ms, m)
+	}
+
+	return func(row reflect.Value) bool {
+		for _, m := range ms {
+			if !m(row) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// comparison compiles column op value with SQL NULL semantics
+func (s *memoryStore[T, ID]) comparison(column interface{}, op string, value interface{}) (matcher, error) {
+	field, err := s.field(column)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op {
+	case "IN", "NOT IN":
+		var values []interface{}
+		for _, v := range expand(value) {
+			values = append(values, normalize(v))
+		}
+		in := op == "IN"
+		return func(row reflect.Value) bool {
+			v := fieldValue(field, row)
+			if v == nil {
+				return false
+			}
+			for _, candidate := range values {
+				if c, ok := compare(v, candidate); ok && c == 0 {
+					return in
+				}
+			}
+			return !in
+		}, nil
+
+	case "LIKE", "NOT LIKE", "ILIKE", "NOT ILIKE":
+		pattern, ok := normalize(value).(string)
+		if !ok {
+			return nil, fmt.Errorf("memory repository: %s needs a string, got %T", op, value)
+		}
+		re, err := likePattern(pattern, strings.HasSuffix(op, "ILIKE"))
+		if err != nil {
+			return nil, err
+		}
+		negate := strings.HasPrefix(op, "NOT")
+		return func(row reflect.Value) bool {
+			v, ok := fieldValue(field, row).(string)
+			return ok && re.MatchString(v) != negate
+		}, nil
+	}
+
+	want := normalize(value)
+	if want == nil {
+		if op != "=" && op != "<>" {
+			return func(reflect.Value) bool { return false }, nil // a comparison with NULL is never true
+		}
+		// = NULL and <> NULL are what clause.Eq and clause.Neq build IS NULL and IS NOT NULL from
+		isNull := op == "="
+		return func(row reflect.Value) bool {
+			return (fieldValue(field, row) == nil) == isNull
+		}, nil
+	}
+
+	return func(row reflect.Value) bool {
+		v := fieldValue(field, row)
+		if v == nil {
+			return false
+		}
+		c, ok := compare(v, want)
+		if !ok {
+			return false
+		}
+		switch op {
+		case "=":
+			return c == 0
+		case "<>":
+			return c != 0
+		case ">":
+			return c > 0
+		case ">=":
+			return c >= 0
+		case "<":
+			return c < 0
+		default:
+			return c <= 0
+		}
+	}, nil
+}
+
+// likePattern translates a LIKE pattern, with \ as escape character, into a regexp
+func likePattern(pattern string, caseInsensitive bool) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("(?s)")
+	if caseInsensitive {
+		b.WriteString("(?i)")
+	}
+	b.WriteString("^")
+	escaped := false
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '%':
+			b.WriteString(".*")
+		case r == '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// expand turns a slice value, e.g. []uint{1, 2} of Where("id IN ?", ids), into its items
+func expand(value interface{}) []interface{} {
+	if values, ok := value.([]interface{}); ok {
+		if len(values) == 1 {
+			if v := reflect.ValueOf(values[0]); v.Kind() == reflect.Slice && v.Type().Elem().Kind() != reflect.Uint8 {
+				return expand(values[0])
+			}
+		}
+		return values
+	}
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Slice || v.Type().Elem().Kind() == reflect.Uint8 {
+		return []interface{}{value}
+	}
+	values := make([]interface{}, v.Len())
+	for i := range values {
+		values[i] = v.Index(i).Interface()
+	}
+	return values
+}
+
+func fieldValue(field *schema.Field, row reflect.Value) interface{} {
+	v, _ := field.ValueOf(context.Background(), row)
+	return normalize(v)
+}
+
+// normalize reduces v to nil, float64, string, bool or time.Time, the way the database would compare it
+func normalize(v interface{}) interface{} {
+	for {
+		if v == nil {
+			return nil
+		}
+		if valuer, ok := v.(driver.Valuer); ok {
+			value, err := valuer.Value()
+			if err != nil {
+				return nil
+			}
+			if _, same := value.(driver.Valuer); same {
+				return value
+			}
+			v = value
+			continue
+		}
+
+		switch value := v.(type) {
+		case time.Time:
+			return value
+		case []byte:
+			return string(value)
+		}
+
+		rv := reflect.ValueOf(v)
+		switch rv.Kind() {
+		case reflect.Ptr, reflect.Interface:
+			if rv.IsNil() {
+				return nil
+			}
+			v = rv.Elem().Interface()
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return float64(rv.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			return float64(rv.Uint())
+		case reflect.Float32, reflect.Float64:
+			return rv.Float()
+		case reflect.String:
+			return rv.String()
+		case reflect.Bool:
+			return rv.Bool()
+		default:
+			return fmt.Sprint(v)
+		}
+	}
+}
+
+// compare orders two normalized values, ok is false when they cannot be compared
+func compare(a, b interface{}) (int, bool) {
+	switch x := a.(type) {
+	case float64:
+		y, ok := b.(float64)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	case string:
+		y, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(x, y), true
+	case time.Time:
+		y, ok := b.(time.Time)
+		if !ok {
+			return 0, false
+		}
+		return x.Compare(y), true
+	case bool:
+		y, ok := b.(bool)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x == y:
+			return 0, true
+		case !x:
+			return -1, true
+		}
+		return 1, true
+	}
+	return 0, false
+}
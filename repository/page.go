@@ -0,0 +1,227 @@
+package repository
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// PageRequest selects one page of a list, either by page number or by cursor
+type PageRequest struct {
+	Page     int // 1 based, ignored when Keyset is set
+	PageSize int
+	Sort     []SortField // e.g. Query.Sort, the primary key is always appended as tie breaker
+	Keyset   bool        // page by Cursor instead of Page
+	Cursor   string      // NextCursor of the previous page, empty for the first one
+}
+
+// Page is one page of a list together with what a client needs to fetch the others
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	Total      int64  `json:"total"`
+	Page       int    `json:"page,omitempty"`
+	PageSize   int    `json:"pageSize"`
+	TotalPages int    `json:"totalPages"`
+	HasNext    bool   `json:"hasNext"`
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// ErrInvalidCursor is returned for a cursor that was not produced by the same list and sort
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ListPageAs runs the count and the data query of one page of repo's entities and scans the rows into R,
+// e.g. a DTO selected by conditions. Cursors are built from the sort columns of R, so R must have them.
+func ListPageAs[R any, T any, ID comparable](repo GenericRepository[T, ID], conditions []func(*gorm.DB) *gorm.DB, req PageRequest, opts ...GORMOption) (Page[R], error) {
+	page := Page[R]{Items: []R{}, PageSize: req.PageSize}
+	if req.PageSize <= 0 {
+		return page, errors.New("page size must be positive")
+	}
+
+	if err := repo.CountWithConditions(&page.Total, conditions, opts...); err != nil {
+		return page, err
+	}
+	page.TotalPages = int((page.Total + int64(req.PageSize) - 1) / int64(req.PageSize))
+
+	var entity T
+	sch, err := schema.Parse(&entity, &querySchemas, schema.NamingStrategy{})
+	if err != nil {
+		return page, err
+	}
+
+	sort := keysetSort(sch, req.Sort)
+
+	if !req.Keyset {
+		if req.Page < 1 {
+			req.Page = 1
+		}
+		page.Page = req.Page
+		page.HasNext = req.Page < page.TotalPages
+
+		opts = append(opts, WithOrder(sort), WithPaging(req.Page, req.PageSize))
+		err := repo.GetWithConditions(&page.Items, conditions, opts...)
+		return page, err
+	}
+
+	if req.Cursor != "" {
+		values, err := decodeCursor[R](req.Cursor, sort)
+		if err != nil {
+			return page, err
+		}
+		opts = append(opts, withKeyset(sort, values))
+	}
+
+	// one extra row tells whether there is a next page
+	opts = append(opts, WithOrder(sort), func(db *gorm.DB) *gorm.DB {
+		return db.Limit(req.PageSize + 1)
+	})
+	if err := repo.GetWithConditions(&page.Items, conditions, opts...); err != nil {
+		return page, err
+	}
+
+	if len(page.Items) > req.PageSize {
+		page.Items = page.Items[:req.PageSize]
+		page.HasNext = true
+		page.NextCursor, err = encodeCursor(page.Items[len(page.Items)-1], sort)
+	}
+	return page, err
+}
+
+// keysetSort appends the primary key to sort so every row has a unique, stable position
+func keysetSort(sch *schema.Schema, sort []SortField) []SortField {
+	if sch.PrioritizedPrimaryField == nil {
+		return sort
+	}
+	for _, field := range sort {
+		if field.Column.Name == sch.PrioritizedPrimaryField.DBName {
+			return sort
+		}
+	}
+	pk := SortField{Column: clause.Column{Table: sch.Table, Name: sch.PrioritizedPrimaryField.DBName}}
+	return append(append([]SortField{}, sort...), pk)
+}
+
+// withKeyset selects the rows after values in sort order:
+// (a > ?) OR (a = ? AND b > ?) OR (a = ? AND b = ? AND c > ?) ...
+// NULLs sort last ascending and first descending, Postgres' default, so after a NULL comes nothing ascending
+// and every other value descending, and after a value come the NULLs ascending.
+func withKeyset(sort []SortField, values []interface{}) GORMOption {
+	var or []clause.Expression
+	for i, field := range sort {
+		var and []clause.Expression
+		for j := 0; j < i; j++ {
+			and = append(and, clause.Eq{Column: sort[j].Column, Value: values[j]}) // IS NULL for nil
+		}
+
+		null := isNil(values[i])
+		switch {
+		case null && field.Desc:
+			and = append(and, clause.Neq{Column: field.Column, Value: nil})
+		case null:
+			continue // nothing sorts after NULL
+		case field.Desc:
+			and = append(and, clause.Lt{Column: field.Column, Value: values[i]})
+		default:
+			and = append(and, clause.Or(
+				clause.Gt{Column: field.Column, Value: values[i]},
+				clause.Eq{Column: field.Column, Value: nil},
+			))
+		}
+		or = append(or, clause.And(and...))
+	}
+
+	return func(db *gorm.DB) *gorm.DB {
+		if len(or) == 0 {
+			return db.Where(clause.IN{Column: sort[0].Column}) // IN (NULL), nothing comes after the cursor
+		}
+		return db.Where(clause.Or(or...))
+	}
+}
+
+// isNil reports whether v is nil or a nil pointer, the value of a NULL column
+func isNil(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	return rv.Kind() == reflect.Ptr && rv.IsNil()
+}
+
+// cursor is the JSON behind the opaque base64 cursor
+type cursor struct {
+	Sort   string            `json:"s"`
+	Values []json.RawMessage `json:"v"`
+}
+
+func encodeCursor[R any](item R, sort []SortField) (string, error) {
+	sch, err := schema.Parse(&item, &querySchemas, schema.NamingStrategy{})
+	if err != nil {
+		return "", err
+	}
+
+	c := cursor{Sort: sortKey(sort)}
+	for _, field := range sort {
+		f := sch.LookUpField(field.Column.Name)
+		if f == nil {
+			return "", errors.New("cursor column " + field.Column.Name + " is not a field of " + sch.Name)
+		}
+		value, _ := f.ValueOf(context.Background(), reflect.ValueOf(item))
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return "", err
+		}
+		c.Values = append(c.Values, raw)
+	}
+
+	data, err := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data), err
+}
+
+func decodeCursor[R any](s string, sort []SortField) ([]interface{}, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c cursor
+	if err := json.Unmarshal(data, &c); err != nil || c.Sort != sortKey(sort) || len(c.Values) != len(sort) {
+		return nil, ErrInvalidCursor
+	}
+
+	var item R
+	sch, err := schema.Parse(&item, &querySchemas, schema.NamingStrategy{})
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]interface{}, len(sort))
+	for i, field := range sort {
+		f := sch.LookUpField(field.Column.Name)
+		if f == nil {
+			return nil, ErrInvalidCursor
+		}
+		// decode into the field's own type so the value is bound with the column's type
+		value := reflect.New(f.FieldType)
+		if err := json.Unmarshal(c.Values[i], value.Interface()); err != nil {
+			return nil, ErrInvalidCursor
+		}
+		values[i] = value.Elem().Interface()
+	}
+	return values, nil
+}
+
+func sortKey(sort []SortField) string {
+	keys := make([]string, len(sort))
+	for i, field := range sort {
+		keys[i] = field.Column.Name
+		if field.Desc {
+			keys[i] = "-" + keys[i]
+		}
+	}
+	return strings.Join(keys, ",")
+}
@@ -0,0 +1,132 @@
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gorepository/publisher"
+	"reflect"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// ErrNotWritable is returned by Patch for a change of an unknown or server owned field
+var ErrNotWritable = errors.New("field cannot be changed")
+
+// Patch updates only the given fields of the entity with id, zero values like Published=false included,
+// and returns the updated entity. Keys are column, field or JSON names of writable fields (see WritableFields),
+// values are converted to the type of the field. A Version is incremented, see IfVersion to check it first.
+//
+//	post, err := repo.Patch(id, map[string]interface{}{"published": false})
+func (r *genericRepository[T, ID]) Patch(id ID, changes map[string]interface{}, opts ...Option) (T, error) {
+	options := r.defaults
+	options.gormDB = r.db
+
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	var entity T
+	sch, err := parseSchema(r.db, &entity)
+	if err != nil {
+		return entity, err
+	}
+	columns, err := patchColumns(sch, changes)
+	if err != nil {
+		return entity, err
+	}
+
+	byID := clause.Eq{Column: clause.PrimaryColumn, Value: id}
+	if len(columns) == 0 {
+		db, cancel := withTimeout(options.gormDB, options.timeout)
+		defer cancel()
+		err := db.Where(byID).First(&entity).Error
+		return entity, translateError(err)
+	}
+
+	var before *T
+	err = r.save(options, func(db *gorm.DB) error {
+		var err error
+		if before, err = r.before(db, options, id, false); err != nil {
+			return err
+		}
+
+		query := db.Model(&entity).Where(byID)
+		if field := versionField(sch); field != nil {
+			column := clause.Column{Table: clause.CurrentTable, Name: field.DBName}
+			columns[field.DBName] = gorm.Expr("? + 1", column)
+			if options.version != nil {
+				query = query.Where(clause.Eq{Column: column, Value: *options.version})
+			}
+		}
+
+		result := query.Updates(columns)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			if options.version == nil {
+				return gorm.ErrRecordNotFound
+			}
+			return conflictOrNotFound[T](db, id)
+		}
+		return db.Where(byID).First(&entity).Error
+	}, func() []change[T] {
+		return []change[T]{{action: publisher.Updated, id: fmt.Sprint(id), before: before, after: &entity}}
+	})
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return entity, nil
+}
+
+// patchColumns validates changes and returns them by column name, converted to the types of the fields
+func patchColumns(sch *schema.Schema, changes map[string]interface{}) (map[string]interface{}, error) {
+	columns := make(map[string]interface{}, len(changes))
+	for key, value := range changes {
+		field := lookUpField(sch, key)
+		if field == nil || field.DBName == "" || serverOwned(field) {
+			return nil, &ErrValidation{Fields: map[string]string{key: "cannot be changed"}, Err: ErrNotWritable}
+		}
+		converted, err := convertValue(field, value)
+		if err != nil {
+			return nil, &ErrValidation{Fields: map[string]string{key: "has an invalid value"}, Err: err}
+		}
+		columns[field.DBName] = converted
+	}
+	return columns, nil
+}
+
+// lookUpField finds a field by column or field name, or case insensitively by JSON name like encoding/json does
+func lookUpField(sch *schema.Schema, name string) *schema.Field {
+	if field := sch.LookUpField(name); field != nil {
+		return field
+	}
+	for _, field := range sch.Fields {
+		if json := jsonName(field); json != "" && strings.EqualFold(json, name) {
+			return field
+		}
+	}
+	return nil
+}
+
+// convertValue turns value, e.g. a float64 or string decoded from JSON, into the Go type of field.
+// nil becomes NULL for pointers and the zero value otherwise, the same as decoding null into the struct.
+func convertValue(field *schema.Field, value interface{}) (interface{}, error) {
+	if value != nil && reflect.TypeOf(value) == field.FieldType {
+		return value, nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	target := reflect.New(field.FieldType)
+	if err := json.Unmarshal(data, target.Interface()); err != nil {
+		return nil, err
+	}
+	return target.Elem().Interface(), nil
+}
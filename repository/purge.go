@@ -0,0 +1,37 @@
+package repository
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Purger is implemented by every GenericRepository
+type Purger interface {
+	PurgeDeleted(before time.Time, opts ...Option) (int64, error)
+}
+
+// RunPurgeJob hard deletes, every interval, the rows of repos that were soft deleted more than retention ago.
+// It returns when ctx is cancelled.
+func RunPurgeJob(ctx context.Context, interval, retention time.Duration, repos ...Purger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		before := time.Now().Add(-retention)
+		for _, repo := range repos {
+			purged, err := repo.PurgeDeleted(before)
+			if err != nil {
+				log.Printf("purge job: %v", err)
+			} else if purged > 0 {
+				log.Printf("purge job: purged %d rows deleted before %s", purged, before.Format(time.RFC3339))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,327 @@
+package repository
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// Operator is a comparison a list endpoint accepts in filter[field][operator]=value
+type Operator string
+
+const (
+	OpEq    Operator = "eq"
+	OpNe    Operator = "ne"
+	OpGt    Operator = "gt"
+	OpGte   Operator = "gte"
+	OpLt    Operator = "lt"
+	OpLte   Operator = "lte"
+	OpLike  Operator = "like"  // case sensitive "contains"
+	OpIlike Operator = "ilike" // case insensitive "contains"
+	OpIn    Operator = "in"    // comma separated list
+	OpNull  Operator = "null"  // true for IS NULL, false for IS NOT NULL
+)
+
+// operators lists what each kind of column can be filtered with
+var operators = map[schema.DataType][]Operator{
+	schema.String: {OpEq, OpNe, OpLike, OpIlike, OpIn, OpNull},
+	schema.Int:    {OpEq, OpNe, OpGt, OpGte, OpLt, OpLte, OpIn, OpNull},
+	schema.Uint:   {OpEq, OpNe, OpGt, OpGte, OpLt, OpLte, OpIn, OpNull},
+	schema.Float:  {OpEq, OpNe, OpGt, OpGte, OpLt, OpLte, OpIn, OpNull},
+	schema.Time:   {OpEq, OpNe, OpGt, OpGte, OpLt, OpLte, OpNull},
+	schema.Bool:   {OpEq, OpNe, OpNull},
+}
+
+// Filter is a validated filter whose value already has the Go type of its column
+type Filter struct {
+	Column   clause.Column
+	Operator Operator
+	Value    interface{}
+}
+
+// SortField is a validated sort column
+type SortField struct {
+	Column clause.Column
+	Desc   bool
+}
+
+// Query is a parsed and validated filter/sort query string
+type Query struct {
+	Filters []Filter
+	Sort    []SortField
+}
+
+// QueryError is returned by QuerySpec.Parse for anything outside the whitelist
+type QueryError struct {
+	Message string
+	Allowed map[string][]Operator // field => operators
+}
+
+func (e *QueryError) Error() string {
+	return e.Message
+}
+
+// QuerySpec is the whitelist of fields a list endpoint of one model can be filtered and sorted by.
+// It turns query strings like ?filter[name][ilike]=ann&sort=-created_at into GORMOptions
+// without ever putting user input into SQL.
+type QuerySpec struct {
+	schema      *schema.Schema
+	fields      map[string]*schema.Field // by column name
+	defaultSort []SortField
+}
+
+var querySchemas sync.Map
+
+// NewQuerySpec whitelists fields (column names, e.g. "created_at") of T. Without fields every column of T is allowed.
+// It panics on a field T does not have, like regexp.MustCompile it is meant to be called while setting up routes.
+func NewQuerySpec[T any](fields ...string) *QuerySpec {
+	var entity T
+	sch, err := schema.Parse(&entity, &querySchemas, schema.NamingStrategy{})
+	if err != nil {
+		panic(err)
+	}
+
+	spec := &QuerySpec{schema: sch, fields: map[string]*schema.Field{}}
+	if len(fields) == 0 {
+		for _, field := range sch.Fields {
+			if field.DBName != "" && operators[field.DataType] != nil {
+				spec.fields[field.DBName] = field
+			}
+		}
+		return spec
+	}
+
+	for _, name := range fields {
+		field := sch.LookUpField(name)
+		if field == nil || field.DBName == "" || operators[field.DataType] == nil {
+			panic(fmt.Sprintf("repository: %s has no filterable field %q", sch.Name, name))
+		}
+		spec.fields[field.DBName] = field
+	}
+	return spec
+}
+
+// SortBy sets the sort used when the query string has none, in the same syntax as ?sort=
+func (s *QuerySpec) SortBy(sort string) *QuerySpec {
+	fields, err := s.parseSort(sort)
+	if err != nil {
+		panic(err)
+	}
+	s.defaultSort = fields
+	return s
+}
+
+var filterKey = regexp.MustCompile(`^filter\[([^\[\]]+)\](?:\[([^\[\]]+)\])?$`)
+
+// Parse validates the filter[...] and sort parameters of a query string, e.g. from c.Queries().
+// Every other parameter is ignored. The error is always a *QueryError.
+func (s *QuerySpec) Parse(values map[string]string) (Query, error) {
+	var query Query
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys) // stable order of WHERE clauses
+
+	for _, key := range keys {
+		if !strings.HasPrefix(key, "filter[") {
+			continue
+		}
+		match := filterKey.FindStringSubmatch(key)
+		if match == nil {
+			return query, s.invalid("invalid filter %q, use filter[field][operator]=value", key)
+		}
+
+		op := Operator(match[2])
+		if op == "" {
+			op = OpEq
+		}
+		filter, err := s.parseFilter(match[1], op, values[key])
+		if err != nil {
+			return query, err
+		}
+		query.Filters = append(query.Filters, filter)
+	}
+
+	query.Sort = s.defaultSort
+	if raw := values["sort"]; raw != "" {
+		fields, err := s.parseSort(raw)
+		if err != nil {
+			return query, err
+		}
+		query.Sort = fields
+	}
+
+	return query, nil
+}
+
+func (s *QuerySpec) parseFilter(name string, op Operator, raw string) (Filter, error) {
+	field, ok := s.fields[name]
+	if !ok {
+		return Filter{}, s.invalid("cannot filter by %q", name)
+	}
+	if !allowed(operators[field.DataType], op) {
+		return Filter{}, s.invalid("cannot filter %q with %q", name, op)
+	}
+
+	filter := Filter{Column: s.column(field), Operator: op}
+	var err error
+
+	switch op {
+	case OpNull:
+		filter.Value, err = strconv.ParseBool(raw)
+	case OpIn:
+		var values []interface{}
+		for _, item := range strings.Split(raw, ",") {
+			value, err := parseValue(field, item)
+			if err != nil {
+				return Filter{}, s.invalid("invalid value %q for %q", item, name)
+			}
+			values = append(values, value)
+		}
+		filter.Value = values
+	case OpLike, OpIlike:
+		filter.Value = "%" + likeEscaper.Replace(raw) + "%"
+	default:
+		filter.Value, err = parseValue(field, raw)
+	}
+	if err != nil {
+		return Filter{}, s.invalid("invalid value %q for %q", raw, name)
+	}
+
+	return filter, nil
+}
+
+func (s *QuerySpec) parseSort(raw string) ([]SortField, error) {
+	var fields []SortField
+	for _, item := range strings.Split(raw, ",") {
+		item = strings.TrimSpace(item)
+		desc := strings.HasPrefix(item, "-")
+		name := strings.TrimPrefix(item, "-")
+
+		field, ok := s.fields[name]
+		if !ok {
+			return nil, s.invalid("cannot sort by %q", name)
+		}
+		fields = append(fields, SortField{Column: s.column(field), Desc: desc})
+	}
+	return fields, nil
+}
+
+func (s *QuerySpec) column(field *schema.Field) clause.Column {
+	// qualified, so it stays unambiguous in queries joining other tables
+	return clause.Column{Table: s.schema.Table, Name: field.DBName}
+}
+
+func (s *QuerySpec) invalid(format string, args ...interface{}) *QueryError {
+	allowedFields := map[string][]Operator{}
+	for name, field := range s.fields {
+		allowedFields[name] = operators[field.DataType]
+	}
+	return &QueryError{Message: fmt.Sprintf(format, args...), Allowed: allowedFields}
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// parseValue converts raw into the Go type of field's column
+func parseValue(field *schema.Field, raw string) (interface{}, error) {
+	switch field.DataType {
+	case schema.Int:
+		return strconv.ParseInt(raw, 10, 64)
+	case schema.Uint:
+		return strconv.ParseUint(raw, 10, 64)
+	case schema.Float:
+		return strconv.ParseFloat(raw, 64)
+	case schema.Bool:
+		return strconv.ParseBool(raw)
+	case schema.Time:
+		if t, err := time.Parse(time.RFC3339, raw); err == nil {
+			return t, nil
+		}
+		return time.Parse("2006-01-02", raw)
+	default:
+		return raw, nil
+	}
+}
+
+func allowed(ops []Operator, op Operator) bool {
+	for _, o := range ops {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+// Options returns the GORMOptions applying the filters and sort of q
+func (q Query) Options() []GORMOption {
+	opts := q.FilterOptions()
+	if len(q.Sort) > 0 {
+		opts = append(opts, WithOrder(q.Sort))
+	}
+	return opts
+}
+
+// FilterOptions returns the GORMOptions applying only the filters of q, e.g. for ListPage which sorts by PageRequest.Sort
+func (q Query) FilterOptions() []GORMOption {
+	opts := make([]GORMOption, 0, len(q.Filters)+1)
+	for _, filter := range q.Filters {
+		opts = append(opts, WithFilter(filter))
+	}
+	return opts
+}
+
+// WithFilter adds a validated filter to the WHERE clause
+func WithFilter(f Filter) GORMOption {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where(f.Expression())
+	}
+}
+
+// WithOrder sorts by validated columns, unlike WithSorting it is safe to build from user input
+func WithOrder(fields []SortField) GORMOption {
+	return func(db *gorm.DB) *gorm.DB {
+		for _, field := range fields {
+			db = db.Order(clause.OrderByColumn{Column: field.Column, Desc: field.Desc})
+		}
+		return db
+	}
+}
+
+// Expression returns f as a GORM clause expression
+func (f Filter) Expression() clause.Expression {
+	switch f.Operator {
+	case OpNe:
+		return clause.Neq{Column: f.Column, Value: f.Value}
+	case OpGt:
+		return clause.Gt{Column: f.Column, Value: f.Value}
+	case OpGte:
+		return clause.Gte{Column: f.Column, Value: f.Value}
+	case OpLt:
+		return clause.Lt{Column: f.Column, Value: f.Value}
+	case OpLte:
+		return clause.Lte{Column: f.Column, Value: f.Value}
+	case OpLike:
+		return clause.Like{Column: f.Column, Value: f.Value}
+	case OpIlike:
+		return clause.Expr{SQL: "? ILIKE ?", Vars: []interface{}{f.Column, f.Value}}
+	case OpIn:
+		return clause.IN{Column: f.Column, Values: f.Value.([]interface{})}
+	case OpNull:
+		if f.Value.(bool) {
+			return clause.Eq{Column: f.Column, Value: nil}
+		}
+		return clause.Neq{Column: f.Column, Value: nil}
+	default:
+		return clause.Eq{Column: f.Column, Value: f.Value}
+	}
+}
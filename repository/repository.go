@@ -2,19 +2,115 @@
 package repository
 
 import (
+	"context"
+	"gorepository/audit"
 	"gorepository/model"
+	"gorepository/publisher"
+	"time"
 
 	"gorm.io/gorm"
 )
 
+// defaultTimeout bounds every statement of a repository unless the call sets its own WithTimeout
+const defaultTimeout = 30 * time.Second
+
 type Repositories struct {
-	UserRepo GenericRepository[model.User]
-	PostRepo GenericRepository[model.Post]
+	UserRepo GenericRepository[model.User, uint]
+	PostRepo GenericRepository[model.Post, uint]
+
+	// AuditRepo reads the audit log written with WithAudit, its own changes are neither audited nor published
+	AuditRepo GenericRepository[audit.Entry, uint]
+	// DeadLetterRepo reads the events a publisher.DeadLetterStore keeps, neither audited nor published either
+	DeadLetterRepo GenericRepository[publisher.DeadLetter, uint]
+	// WebhookRepo manages the subscriptions of publisher.Webhooks, WebhookDeliveryRepo reads their delivery log
+	WebhookRepo         GenericRepository[publisher.WebhookSubscription, uint]
+	WebhookDeliveryRepo GenericRepository[publisher.WebhookDelivery, uint]
+
+	db        *gorm.DB
+	publisher publisher.Publisher
+	opts      []Option
+	memory    func(ctx context.Context, publisher publisher.Publisher) *Repositories // set by NewMemoryRepositories
+	ctx       context.Context                                                        // of WithContext, db carries it otherwise
 }
 
-func NewRepositories(db *gorm.DB) *Repositories {
+// NewRepositories returns repositories that publish nothing, see NewRepositoriesWithPublisher
+func NewRepositories(db *gorm.DB, opts ...Option) *Repositories {
+	return newRepositories(db, nil, opts)
+}
+
+// NewRepositoriesWithPublisher wires every repository to publisher, e.g. a publisher.Outbox.
+// opts are the defaults of every operation, e.g. WithAudit(true).
+func NewRepositoriesWithPublisher(db *gorm.DB, publisher publisher.Publisher, opts ...Option) *Repositories {
+	return newRepositories(db, publisher, opts)
+}
+
+// internalRepoOptions keep the audit log, the dead letters and the webhooks from being audited and published themselves
+var internalRepoOptions = []Option{WithTimeout(defaultTimeout), WithAudit(false), WithPublishing(false)}
+
+func newRepositories(db *gorm.DB, pub publisher.Publisher, opts []Option) *Repositories {
+	if pub == nil {
+		pub = &publisher.NoopPublisher{}
+	}
+	defaults := append([]Option{WithTimeout(defaultTimeout)}, opts...)
 	return &Repositories{
-		UserRepo: NewGenericRepository[model.User](db, nil),
-		PostRepo: NewGenericRepository[model.Post](db, nil),
+		UserRepo:       NewGenericRepository[model.User, uint](db, pub, defaults...),
+		PostRepo:       NewGenericRepository[model.Post, uint](db, pub, defaults...),
+		AuditRepo:      NewGenericRepository[audit.Entry, uint](db, pub, internalRepoOptions...),
+		DeadLetterRepo: NewGenericRepository[publisher.DeadLetter, uint](db, pub, internalRepoOptions...),
+
+		WebhookRepo:         NewGenericRepository[publisher.WebhookSubscription, uint](db, pub, internalRepoOptions...),
+		WebhookDeliveryRepo: NewGenericRepository[publisher.WebhookDelivery, uint](db, pub, internalRepoOptions...),
+
+		db:        db,
+		publisher: pub,
+		opts:      opts,
 	}
 }
+
+// WithContext returns repositories whose queries, including Transaction, are bound to ctx
+func (r *Repositories) WithContext(ctx context.Context) *Repositories {
+	if r.memory != nil {
+		return r.memory(ctx, r.publisher)
+	}
+	return newRepositories(r.db.WithContext(ctx), r.publisher, r.opts)
+}
+
+// Transaction runs fn as a single unit of work. Every repository in tx is bound to the same
+// database transaction, which is committed when fn returns nil and rolled back otherwise.
+// Events published inside fn are only sent after a successful commit, failing to send them is ErrPublish.
+//
+//	err := repos.Transaction(func(tx *repository.Repositories) error {
+//		user, err := tx.UserRepo.Create(user)
+//		if err != nil {
+//			return err
+//		}
+//		post.UserID = user.ID
+//		_, err = tx.PostRepo.Create(post)
+//		return err
+//	})
+func (r *Repositories) Transaction(fn func(tx *Repositories) error) error {
+	if _, ok := r.publisher.(publisher.TxPublisher); ok {
+		// the publisher writes through the transaction itself, nothing has to be held back
+		err := r.db.Transaction(func(tx *gorm.DB) error {
+			return fn(newRepositories(tx, r.publisher, r.opts))
+		})
+		return translateError(err)
+	}
+
+	buffered := publisher.NewBufferedPublisher(r.publisher)
+
+	var err error
+	if r.memory != nil {
+		err = fn(r.memory(r.ctx, buffered))
+	} else {
+		err = translateError(r.db.Transaction(func(tx *gorm.DB) error {
+			return fn(newRepositories(tx, buffered, r.opts))
+		}))
+	}
+	if err != nil {
+		buffered.Discard()
+		return err
+	}
+
+	return publishError(buffered.Flush())
+}
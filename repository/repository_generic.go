@@ -1,40 +1,80 @@
 package repository
 
 import (
+	"context"
+	"fmt"
 	"gorepository/publisher"
 	"reflect"
+	"time"
 
-	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
 )
 
-type GenericRepository[T any] interface {
+// GenericRepository gives CRUD access to T, whose primary key is of type ID (e.g. uint, int64, uuid.UUID or string)
+type GenericRepository[T any, ID comparable] interface {
 	GetAll() ([]T, error)
 	GetWithConditions(result interface{}, conditions []func(*gorm.DB) *gorm.DB, opts ...GORMOption) error
 	CountWithConditions(result *int64, conditions []func(*gorm.DB) *gorm.DB, opts ...GORMOption) error
-	FindByID(id uuid.UUID) (T, error)
+	ListPage(conditions []func(*gorm.DB) *gorm.DB, req PageRequest, opts ...GORMOption) (Page[T], error)
+	FindByID(id ID) (T, error)
 	Create(entity T, opts ...Option) (T, error)
 	Update(entity T, opts ...Option) (T, error)
-	Delete(id uuid.UUID, opts ...Option) error
+	Patch(id ID, changes map[string]interface{}, opts ...Option) (T, error)
+	Delete(id ID, opts ...Option) error
+	HardDelete(id ID, opts ...Option) error
+	Restore(id ID, opts ...Option) (T, error)
+	PurgeDeleted(before time.Time, opts ...Option) (int64, error)
+
+	// bulk operations, see bulk.go
+	CreateMany(entities []T, batchSize int, opts ...Option) ([]T, error)
+	Upsert(entities []T, conflictColumns, updateColumns []string, opts ...Option) ([]T, error)
+	UpdateWhere(conditions []func(*gorm.DB) *gorm.DB, changes map[string]interface{}, opts ...Option) (int64, error)
+	DeleteWhere(conditions []func(*gorm.DB) *gorm.DB, opts ...Option) (int64, error)
+
+	// WithContext returns a copy of the repository whose queries are bound to ctx,
+	// so they are cancelled together with it (e.g. c.UserContext() in a Fiber handler)
+	WithContext(ctx context.Context) GenericRepository[T, ID]
 }
 
-type genericRepository[T any] struct {
+type genericRepository[T any, ID comparable] struct {
 	db        *gorm.DB
 	publisher publisher.Publisher
+	defaults  operationOptions
 }
 
-func NewGenericRepository[T any](db *gorm.DB, publisher publisher.Publisher) GenericRepository[T] {
-	return &genericRepository[T]{db, publisher}
+// NewGenericRepository returns a repository for T on db publishing through pub, a nil pub publishes nothing.
+// opts are the defaults of every operation, e.g. WithTimeout(5*time.Second) bounds all its statements
+// unless a call overrides it.
+func NewGenericRepository[T any, ID comparable](db *gorm.DB, pub publisher.Publisher, opts ...Option) GenericRepository[T, ID] {
+	if pub == nil {
+		pub = &publisher.NoopPublisher{}
+	}
+	defaults := defaultOptions
+	for _, opt := range opts {
+		opt(&defaults)
+	}
+	return &genericRepository[T, ID]{db, pub, defaults}
+}
+
+func (r *genericRepository[T, ID]) WithContext(ctx context.Context) GenericRepository[T, ID] {
+	clone := *r
+	clone.db = r.db.WithContext(ctx)
+	return &clone
 }
 
-func (r *genericRepository[T]) GetAll() ([]T, error) {
+func (r *genericRepository[T, ID]) GetAll() ([]T, error) {
+	db, cancel := withTimeout(r.db, r.defaults.timeout)
+	defer cancel()
+
 	var entities []T
-	result := r.db.Find(&entities)
-	return entities, result.Error
+	result := db.Find(&entities)
+	return entities, translateError(result.Error)
 }
 
 // hints : if gets error 'golang "reflect: reflect.Value.Set using unaddressable value"' add '&' in front result e.g. GetWithConditions(&result, etc...)
-func (r *genericRepository[T]) GetWithConditions(result interface{}, conditions []func(*gorm.DB) *gorm.DB, gormOpts ...GORMOption) error {
+func (r *genericRepository[T, ID]) GetWithConditions(result interface{}, conditions []func(*gorm.DB) *gorm.DB, gormOpts ...GORMOption) error {
 	var entity T
 	query := r.db.Model(&entity)
 
@@ -46,9 +86,17 @@ func (r *genericRepository[T]) GetWithConditions(result interface{}, conditions
 		query = opt(query)
 	}
 
-	return query.Find(result).Error
+	if _, ok := query.Get(predicateKey); ok {
+		return errPredicate
+	}
+
+	query, cancel := withTimeout(query, queryTimeout(query, r.defaults.timeout))
+	defer cancel()
+
+	return translateError(query.Find(result).Error)
 }
-func (r *genericRepository[T]) CountWithConditions(result *int64, conditions []func(*gorm.DB) *gorm.DB, gormOpts ...GORMOption) error {
+
+func (r *genericRepository[T, ID]) CountWithConditions(result *int64, conditions []func(*gorm.DB) *gorm.DB, gormOpts ...GORMOption) error {
 	var entity T
 	query := r.db.Model(&entity)
 
@@ -60,61 +108,146 @@ func (r *genericRepository[T]) CountWithConditions(result *int64, conditions []f
 		query = opt(query)
 	}
 
-	return query.Count(result).Error
+	if _, ok := query.Get(predicateKey); ok {
+		return errPredicate
+	}
+
+	query, cancel := withTimeout(query, queryTimeout(query, r.defaults.timeout))
+	defer cancel()
+
+	return translateError(query.Count(result).Error)
+}
+
+// ListPage returns one page of the entities matching conditions together with their total count
+func (r *genericRepository[T, ID]) ListPage(conditions []func(*gorm.DB) *gorm.DB, req PageRequest, gormOpts ...GORMOption) (Page[T], error) {
+	return ListPageAs[T, T, ID](r, conditions, req, gormOpts...)
 }
 
-func (r *genericRepository[T]) FindByID(id uuid.UUID) (T, error) {
+func (r *genericRepository[T, ID]) FindByID(id ID) (T, error) {
+	db, cancel := withTimeout(r.db, r.defaults.timeout)
+	defer cancel()
+
 	var entity T
-	result := r.db.First(&entity, id)
-	return entity, result.Error
+	result := db.Where(clause.Eq{Column: clause.PrimaryColumn, Value: id}).First(&entity)
+	return entity, translateError(result.Error)
 }
 
-func (r *genericRepository[T]) Create(entity T, opts ...Option) (T, error) {
-	options := defaultOptions
+func (r *genericRepository[T, ID]) Create(entity T, opts ...Option) (T, error) {
+	options := r.defaults
 	options.gormDB = r.db // Set the initial GORM DB
 
 	for _, opt := range opts {
 		opt(&options)
 	}
 
-	result := options.gormDB.Create(&entity)
-	entityId := getIdFromEntity(&entity)
-	if result.Error == nil && options.publish {
-		r.publisher.PublishMessage(entity, "create", entityId) // create, no id have been formed yet
+	sch, err := parseSchema(r.db, &entity)
+	if err != nil {
+		return entity, err
+	}
+	if field := versionField(sch); field != nil && getVersion(field, reflect.ValueOf(&entity).Elem()) == 0 {
+		SetVersion(&entity, 1)
 	}
-	return entity, result.Error
+
+	err = r.save(options, func(db *gorm.DB) error {
+		return db.Create(&entity).Error
+	}, func() []change[T] {
+		return []change[T]{{action: publisher.Created, id: r.primaryKey(&entity), after: &entity}}
+	})
+	return entity, err
 }
 
-func (r *genericRepository[T]) Update(entity T, opts ...Option) (T, error) {
-	options := defaultOptions
+// Update overwrites the stored entity with the same primary key, see update for the optimistic locking
+func (r *genericRepository[T, ID]) Update(entity T, opts ...Option) (T, error) {
+	options := r.defaults
 	options.gormDB = r.db
 
 	for _, opt := range opts {
 		opt(&options)
 	}
 
-	result := options.gormDB.Save(&entity)
-	entityId := getIdFromEntity(entity)
-	if result.Error == nil && options.publish {
-		r.publisher.PublishMessage(entity, "update", entityId)
+	sch, err := parseSchema(r.db, &entity)
+	if err != nil {
+		return entity, err
+	}
+	id, zero := sch.PrioritizedPrimaryField.ValueOf(context.Background(), reflect.ValueOf(&entity).Elem())
+	if zero {
+		return entity, &ErrValidation{Fields: map[string]string{sch.PrioritizedPrimaryField.Name: "is required"}, Err: gorm.ErrPrimaryKeyRequired}
 	}
-	return entity, result.Error
+
+	var before *T
+	err = r.save(options, func(db *gorm.DB) error {
+		var err error
+		if before, err = r.before(db, options, id, false); err != nil {
+			return err
+		}
+		return update(db, sch, &entity)
+	}, func() []change[T] {
+		return []change[T]{{action: publisher.Updated, id: r.primaryKey(&entity), before: before, after: &entity}}
+	})
+	return entity, err
 }
 
-func (r *genericRepository[T]) Delete(id uuid.UUID, opts ...Option) error {
-	options := defaultOptions
+// Delete soft deletes the entity when T has a gorm.DeletedAt field and publishes "deleted",
+// otherwise the row is removed and "purged" is published. The event carries the deleted entity.
+func (r *genericRepository[T, ID]) Delete(id ID, opts ...Option) error {
+	options := r.defaults
 	options.gormDB = r.db
 
 	for _, opt := range opts {
 		opt(&options)
 	}
 
-	var entity T
-	result := options.gormDB.Delete(&entity, id)
-	if result.Error == nil && options.publish {
-		r.publisher.PublishMessage(entity, "delete", id.String())
+	var deleted []T
+	return r.save(options, func(db *gorm.DB) error {
+		// RETURNING * yields the row as deleted, with deleted_at set for a soft delete
+		return db.Clauses(clause.Returning{}).Where(clause.Eq{Column: clause.PrimaryColumn, Value: id}).Delete(&deleted).Error
+	}, func() []change[T] {
+		return r.deletions(deleted, r.softDelete() != nil)
+	})
+}
+
+// save runs op and publishes the changes it made, built by changes afterwards, with one publisher.PublishAll call.
+// A publisher.TxPublisher stores the messages in the same transaction as op, any other publisher is only called
+// once op succeeded and its error is returned as ErrPublish. With WithAudit the changes are recorded in the audit log within the transaction of op.
+func (r *genericRepository[T, ID]) save(options operationOptions, op func(db *gorm.DB) error, changes func() []change[T]) error {
+	db, cancel := withTimeout(options.gormDB, options.timeout)
+	defer cancel()
+
+	txPublisher, transactional := r.publisher.(publisher.TxPublisher)
+	publishTx := options.publish && transactional
+	if !publishTx && !options.audit {
+		if err := op(db); err != nil {
+			return translateError(err)
+		}
+		if options.publish {
+			return publishError(publisher.PublishAll(r.publisher, events(changes())))
+		}
+		return nil
+	}
+
+	var done []change[T]
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := op(tx); err != nil {
+			return err
+		}
+		done = changes()
+		if options.audit {
+			if err := writeAudit(tx, done); err != nil {
+				return err
+			}
+		}
+		if publishTx {
+			return publisher.PublishAllTx(txPublisher, tx, events(done))
+		}
+		return nil
+	})
+	if err != nil {
+		return translateError(err)
+	}
+	if options.publish && !transactional {
+		return publishError(publisher.PublishAll(r.publisher, events(done)))
 	}
-	return result.Error
+	return nil
 }
 
 type Option func(*operationOptions)
@@ -123,6 +256,9 @@ type GORMOption func(*gorm.DB) *gorm.DB
 type operationOptions struct {
 	gormDB  *gorm.DB
 	publish bool
+	timeout time.Duration
+	version *int64 // see IfVersion
+	audit   bool
 }
 
 var defaultOptions = operationOptions{
@@ -145,6 +281,43 @@ func WithSorting(columns []string) GORMOption {
 	}
 }
 
+// WithTx runs a Create, Update or Delete on the given transaction instead of the repository's own connection.
+// Messages are still published right after the statement; use Repositories.Transaction to publish only after commit.
+func WithTx(tx *gorm.DB) Option {
+	return func(o *operationOptions) {
+		o.gormDB = tx
+	}
+}
+
+// WithTimeout bounds the statements of an operation, overriding the repository default. Zero means no timeout.
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *operationOptions) {
+		o.timeout = timeout
+	}
+}
+
+// WithQueryTimeout bounds GetWithConditions and CountWithConditions, overriding the repository default
+func WithQueryTimeout(timeout time.Duration) GORMOption {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Set(queryTimeoutKey, timeout)
+	}
+}
+
+// IfVersion makes Patch fail with ErrConflict unless the stored Version of the entity is still version
+func IfVersion(version int64) Option {
+	return func(o *operationOptions) {
+		o.version = &version
+	}
+}
+
+// WithAudit records every change in the audit log (see package audit) in the same transaction.
+// Pass it to NewRepositoriesWithPublisher to audit all repositories.
+func WithAudit(enabled bool) Option {
+	return func(o *operationOptions) {
+		o.audit = enabled
+	}
+}
+
 func WithPublishing(publish bool) Option {
 	return func(o *operationOptions) {
 		o.publish = publish
@@ -179,26 +352,44 @@ func DistinctBy(s string) GORMOption {
 	}
 }
 
-func getIdFromEntity[T any](entity T) string {
-	val := reflect.ValueOf(entity)
-	// If entity is a pointer, get the value it points to
-	if val.Kind() == reflect.Ptr {
-		val = val.Elem()
+const queryTimeoutKey = "repository:query_timeout"
+
+// queryTimeout returns the timeout set by WithQueryTimeout, or fallback
+func queryTimeout(query *gorm.DB, fallback time.Duration) time.Duration {
+	if timeout, ok := query.Get(queryTimeoutKey); ok {
+		return timeout.(time.Duration)
 	}
+	return fallback
+}
+
+// withTimeout bounds every statement run on the returned db by timeout, on top of the deadline of db's own context
+func withTimeout(db *gorm.DB, timeout time.Duration) (*gorm.DB, context.CancelFunc) {
+	if timeout <= 0 {
+		return db, func() {}
+	}
+	ctx, cancel := context.WithTimeout(db.Statement.Context, timeout)
+	return db.WithContext(ctx), cancel
+}
 
-	// Check if the entity has an 'Id' field
-	idField := val.FieldByName("Id")
-	if !idField.IsValid() {
-		// The entity does not have an 'Id' field
+// primaryKey returns the primary key of entity as a string, using the field GORM parsed as primary key
+func (r *genericRepository[T, ID]) primaryKey(entity *T) string {
+	sch, err := parseSchema(r.db, entity)
+	if err != nil || sch.PrioritizedPrimaryField == nil {
 		return ""
 	}
 
-	// Check if the 'Id' field is of type uuid.UUID
-	if idField.Type() == reflect.TypeOf(uuid.UUID{}) {
-		// Return the 'Id' field's value as a string
-		return idField.Interface().(uuid.UUID).String()
+	value, zero := sch.PrioritizedPrimaryField.ValueOf(context.Background(), reflect.ValueOf(entity).Elem())
+	if zero {
+		return ""
 	}
+	return fmt.Sprint(value)
+}
 
-	// If the 'Id' field is not of type uuid.UUID, or any other condition you wish to check
-	return ""
+// parseSchema returns the GORM schema of model, cached by db
+func parseSchema(db *gorm.DB, model interface{}) (*schema.Schema, error) {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return nil, err
+	}
+	return stmt.Schema, nil
 }
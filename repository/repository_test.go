@@ -0,0 +1,339 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"gorepository/audit"
+	"gorepository/model"
+	"gorepository/repository"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+var (
+	dbOnce sync.Once
+	db     *gorm.DB
+	dbErr  error
+)
+
+// eachRepo runs fn against the memory repository and, with TEST_DATABASE_URL set, against the GORM one on
+// an emptied posts table, so both are held to the same expectations. Point it at a scratch database.
+func eachRepo(t *testing.T, fn func(t *testing.T, repo repository.GenericRepository[model.Post, uint])) {
+	t.Run("memory", func(t *testing.T) {
+		fn(t, repository.NewMemoryRepository[model.Post, uint](nil))
+	})
+	t.Run("gorm", func(t *testing.T) {
+		dsn := os.Getenv("TEST_DATABASE_URL")
+		if dsn == "" {
+			t.Skip("TEST_DATABASE_URL is not set")
+		}
+		dbOnce.Do(func() {
+			db, dbErr = gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+			if dbErr == nil {
+				dbErr = db.AutoMigrate(&model.Post{})
+			}
+		})
+		if dbErr != nil {
+			t.Fatal(dbErr)
+		}
+		if err := db.Exec("TRUNCATE posts RESTART IDENTITY").Error; err != nil {
+			t.Fatal(err)
+		}
+		fn(t, repository.NewGenericRepository[model.Post, uint](db, nil))
+	})
+}
+
+// seed creates posts with the titles, every second one published with a publish date
+func seed(t *testing.T, repo repository.GenericRepository[model.Post, uint], titles ...string) {
+	t.Helper()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, title := range titles {
+		post := model.Post{Title: title, UserID: uint(i%2 + 1)}
+		if i%2 == 1 {
+			date := start.Add(time.Duration(len(titles)-i) * time.Hour) // later posts published earlier
+			post.Published, post.PublishDate = true, &date
+		}
+		if _, err := repo.Create(post); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// byID orders rows, Postgres returns them in no particular order without ORDER BY
+func byID(db *gorm.DB) *gorm.DB {
+	return db.Order("id")
+}
+
+func ids(posts []model.Post) []uint {
+	ids := []uint{}
+	for _, post := range posts {
+		ids = append(ids, post.ID)
+	}
+	return ids
+}
+
+func TestIDAssignment(t *testing.T) {
+	eachRepo(t, func(t *testing.T, repo repository.GenericRepository[model.Post, uint]) {
+		seed(t, repo, "a", "b", "c")
+
+		var all []model.Post
+		if err := repo.GetWithConditions(&all, nil, byID); err != nil {
+			t.Fatal(err)
+		}
+		if got := ids(all); !reflect.DeepEqual(got, []uint{1, 2, 3}) {
+			t.Fatalf("ids = %v, want [1 2 3]", got)
+		}
+
+		post, err := repo.FindByID(2)
+		if err != nil || post.Title != "b" || post.Version != 1 || post.CreatedAt.IsZero() {
+			t.Fatalf("FindByID(2) = %+v, %v", post, err)
+		}
+		if _, err := repo.FindByID(4); !errors.Is(err, repository.ErrNotFound) {
+			t.Fatalf("FindByID(4) error = %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestMemoryKeepsSuppliedIDs(t *testing.T) {
+	repo := repository.NewMemoryRepository[model.Post, uint](nil)
+	seed(t, repo, "a")
+
+	supplied := model.Post{Title: "b", UserID: 1}
+	supplied.ID = 2
+	if _, err := repo.Create(supplied); err != nil {
+		t.Fatal(err)
+	}
+	next, err := repo.Create(model.Post{Title: "c", UserID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.ID != 3 {
+		t.Fatalf("next ID = %d, want 3", next.ID)
+	}
+}
+
+func TestMemoryTransactionContext(t *testing.T) {
+	repos := repository.NewMemoryRepositories(nil, repository.WithAudit(true))
+
+	ctx := audit.WithActor(context.Background(), "ann")
+	err := repos.WithContext(ctx).Transaction(func(tx *repository.Repositories) error {
+		_, err := tx.UserRepo.Create(model.User{Name: "Ann", Email: "ann@example.com"})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := repos.AuditRepo.GetAll()
+	if err != nil || len(entries) != 1 || entries[0].Actor != "ann" {
+		t.Fatalf("audit log %+v, %v, want the change made by ann", entries, err)
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = repos.WithContext(cancelled).Transaction(func(tx *repository.Repositories) error {
+		_, err := tx.UserRepo.Create(model.User{Name: "Bob", Email: "bob@example.com"})
+		return err
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Transaction with a cancelled context = %v, want context.Canceled", err)
+	}
+}
+
+func TestSoftDelete(t *testing.T) {
+	eachRepo(t, func(t *testing.T, repo repository.GenericRepository[model.Post, uint]) {
+		seed(t, repo, "a", "b", "c")
+
+		if err := repo.Delete(2); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repo.FindByID(2); !errors.Is(err, repository.ErrNotFound) {
+			t.Fatalf("FindByID of a deleted post: %v, want ErrNotFound", err)
+		}
+		if err := repo.Delete(2); err != nil {
+			t.Fatalf("second Delete: %v, deleting nothing is no error", err)
+		}
+
+		list := func(opts ...repository.GORMOption) []uint {
+			var posts []model.Post
+			if err := repo.GetWithConditions(&posts, nil, append(opts, byID)...); err != nil {
+				t.Fatal(err)
+			}
+			return ids(posts)
+		}
+		if got := list(); !reflect.DeepEqual(got, []uint{1, 3}) {
+			t.Fatalf("list = %v, want [1 3]", got)
+		}
+		if got := list(repository.OnlyTrashed()); !reflect.DeepEqual(got, []uint{2}) {
+			t.Fatalf("only trashed = %v, want [2]", got)
+		}
+
+		if restored, err := repo.Restore(2); err != nil || restored.DeletedAt.Valid {
+			t.Fatalf("Restore = %+v, %v", restored, err)
+		}
+		if _, err := repo.Restore(2); !errors.Is(err, repository.ErrNotFound) {
+			t.Fatalf("Restore of a post that is not deleted: %v, want ErrNotFound", err)
+		}
+
+		if err := repo.Delete(3); err != nil {
+			t.Fatal(err)
+		}
+		if purged, err := repo.PurgeDeleted(time.Now().Add(-time.Hour)); err != nil || purged != 0 {
+			t.Fatalf("PurgeDeleted before the delete = %d, %v", purged, err)
+		}
+		if purged, err := repo.PurgeDeleted(time.Now().Add(time.Hour)); err != nil || purged != 1 {
+			t.Fatalf("PurgeDeleted = %d, %v, want 1", purged, err)
+		}
+		if got := list(repository.WithTrashed()); !reflect.DeepEqual(got, []uint{1, 2}) {
+			t.Fatalf("with trashed after purge = %v, want [1 2]", got)
+		}
+	})
+}
+
+func TestUpsert(t *testing.T) {
+	eachRepo(t, func(t *testing.T, repo repository.GenericRepository[model.Post, uint]) {
+		seed(t, repo, "a", "b")
+		if err := repo.Delete(2); err != nil {
+			t.Fatal(err)
+		}
+
+		post := model.Post{Title: "c", UserID: 1}
+		post.ID = 2
+		upserted, err := repo.Upsert([]model.Post{post}, []string{"id"}, []string{"title"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if upserted[0].Title != "c" || upserted[0].DeletedAt.Valid || upserted[0].Version != 2 {
+			t.Fatalf("upserted %+v, want the deleted post updated and restored", upserted[0])
+		}
+		if found, err := repo.FindByID(2); err != nil || found.Title != "c" {
+			t.Fatalf("FindByID(2) = %+v, %v", found, err)
+		}
+
+		var invalid *repository.ErrValidation
+		if _, err := repo.Upsert([]model.Post{post, post}, []string{"id"}, nil); !errors.As(err, &invalid) {
+			t.Fatalf("upsert of a duplicated key: %v, want ErrValidation", err)
+		}
+	})
+}
+
+func TestPaging(t *testing.T) {
+	// publish dates: 2 and 4 are set, 4 is the earlier one; 1, 3 and 5 are NULL
+	tests := []struct {
+		sort string
+		want []uint
+	}{
+		{"title", []uint{2, 4, 1, 5, 3}},
+		{"-title", []uint{3, 1, 5, 2, 4}},
+		{"publish_date", []uint{4, 2, 1, 3, 5}},  // NULLs last
+		{"-publish_date", []uint{1, 3, 5, 2, 4}}, // NULLs first
+		{"published,-user_id", []uint{1, 3, 5, 2, 4}},
+	}
+	spec := repository.NewQuerySpec[model.Post]()
+
+	eachRepo(t, func(t *testing.T, repo repository.GenericRepository[model.Post, uint]) {
+		seed(t, repo, "b", "a", "c", "a", "b")
+
+		for _, tt := range tests {
+			query, err := spec.Parse(map[string]string{"sort": tt.sort})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, keyset := range []bool{false, true} {
+				req := repository.PageRequest{PageSize: 2, Sort: query.Sort, Keyset: keyset, Page: 1}
+				var got []uint
+				for pages := 0; ; pages++ {
+					if pages > len(tt.want) {
+						t.Fatalf("sort %s keyset %v: paging does not end", tt.sort, keyset)
+					}
+					page, err := repo.ListPage(nil, req)
+					if err != nil {
+						t.Fatalf("sort %s keyset %v: %v", tt.sort, keyset, err)
+					}
+					if page.Total != 5 || page.TotalPages != 3 {
+						t.Fatalf("sort %s: total %d in %d pages, want 5 in 3", tt.sort, page.Total, page.TotalPages)
+					}
+					got = append(got, ids(page.Items)...)
+					if !page.HasNext {
+						break
+					}
+					req.Page++
+					req.Cursor = page.NextCursor
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("sort %s keyset %v = %v, want %v", tt.sort, keyset, got, tt.want)
+				}
+			}
+		}
+	})
+}
+
+func TestConditions(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      map[string]string
+		conditions []func(*gorm.DB) *gorm.DB
+		want       []uint
+	}{
+		{name: "eq", query: map[string]string{"filter[title]": "a"}, want: []uint{2, 4}},
+		{name: "ne", query: map[string]string{"filter[title][ne]": "a"}, want: []uint{1, 3, 5}},
+		{name: "in", query: map[string]string{"filter[title][in]": "b,c"}, want: []uint{1, 3, 5}},
+		{name: "gt", query: map[string]string{"filter[id][gt]": "3"}, want: []uint{4, 5}},
+		{name: "like", query: map[string]string{"filter[content][like]": "50%"}, want: []uint{3}},
+		{name: "ilike", query: map[string]string{"filter[content][ilike]": "POST"}, want: []uint{1, 2, 3, 4, 5}},
+		{name: "bool", query: map[string]string{"filter[published]": "true"}, want: []uint{2, 4}},
+		{name: "null", query: map[string]string{"filter[publish_date][null]": "true"}, want: []uint{1, 3, 5}},
+		{name: "not null", query: map[string]string{"filter[publish_date][null]": "false"}, want: []uint{2, 4}},
+		{name: "time", query: map[string]string{"filter[publish_date][lt]": "2024-01-01T03:30:00Z"}, want: []uint{4}},
+		{name: "combined", query: map[string]string{"filter[title]": "b", "filter[user_id]": "1"}, want: []uint{1, 5}},
+		{
+			name: "sql",
+			conditions: []func(*gorm.DB) *gorm.DB{func(db *gorm.DB) *gorm.DB {
+				return db.Where("user_id = ? AND title <> ?", 2, "a")
+			}},
+			want: []uint{},
+		},
+	}
+	spec := repository.NewQuerySpec[model.Post]()
+
+	eachRepo(t, func(t *testing.T, repo repository.GenericRepository[model.Post, uint]) {
+		seed(t, repo, "b", "a", "c", "a", "b")
+		for _, id := range []uint{1, 2, 4, 5} {
+			if _, err := repo.Patch(id, map[string]interface{}{"content": "a post"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := repo.Patch(3, map[string]interface{}{"content": "50% off post"}); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, tt := range tests {
+			query, err := spec.Parse(tt.query)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			opts := append(query.FilterOptions(), byID)
+
+			var posts []model.Post
+			if err := repo.GetWithConditions(&posts, tt.conditions, opts...); err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			if got := ids(posts); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s = %v, want %v", tt.name, got, tt.want)
+			}
+
+			var count int64
+			if err := repo.CountWithConditions(&count, tt.conditions, query.FilterOptions()...); err != nil || count != int64(len(tt.want)) {
+				t.Errorf("%s: count = %d, %v, want %d", tt.name, count, err, len(tt.want))
+			}
+		}
+	})
+}
@@ -0,0 +1,167 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"gorepository/publisher"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// ErrNoSoftDelete is returned by Restore for a model without a gorm.DeletedAt field
+var ErrNoSoftDelete = errors.New("model has no soft delete column")
+
+// HardDelete removes the row even when T supports soft delete and publishes "purged" with the removed entity
+func (r *genericRepository[T, ID]) HardDelete(id ID, opts ...Option) error {
+	options := r.defaults
+	options.gormDB = r.db
+
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	var deleted []T
+	return r.save(options, func(db *gorm.DB) error {
+		return db.Unscoped().Clauses(clause.Returning{}).Where(clause.Eq{Column: clause.PrimaryColumn, Value: id}).Delete(&deleted).Error
+	}, func() []change[T] {
+		return r.deletions(deleted, false)
+	})
+}
+
+// Restore undoes a soft delete and publishes "restored" with the restored entity
+func (r *genericRepository[T, ID]) Restore(id ID, opts ...Option) (T, error) {
+	options := r.defaults
+	options.gormDB = r.db
+
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	var entity T
+	field := r.softDelete()
+	if field == nil {
+		return entity, ErrNoSoftDelete
+	}
+
+	var before *T
+	err := r.save(options, func(db *gorm.DB) error {
+		var err error
+		if before, err = r.before(db, options, id, true); err != nil {
+			return err
+		}
+
+		result := db.Unscoped().Model(&entity).
+			Where(clause.Eq{Column: clause.PrimaryColumn, Value: id}).
+			Where(clause.Neq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: nil}).
+			Update(field.DBName, nil)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return db.Where(clause.Eq{Column: clause.PrimaryColumn, Value: id}).First(&entity).Error
+	}, func() []change[T] {
+		return []change[T]{{action: publisher.Restored, id: fmt.Sprint(id), before: before, after: &entity}}
+	})
+	return entity, err
+}
+
+// purgeBatchSize bounds the rows PurgeDeleted deletes, and holds in memory, at once
+const purgeBatchSize = 1000
+
+// PurgeDeleted hard deletes every row soft deleted before the given time and publishes "purged" for each of them.
+// It deletes in batches, each a single DELETE ... RETURNING that checks deleted_at again, so a row restored
+// meanwhile is kept, and locks its rows with SKIP LOCKED, so purges running side by side do not wait on each other.
+func (r *genericRepository[T, ID]) PurgeDeleted(before time.Time, opts ...Option) (int64, error) {
+	options := r.defaults
+	options.gormDB = r.db
+
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	field := r.softDelete()
+	if field == nil {
+		return 0, ErrNoSoftDelete
+	}
+	deletedAt := clause.Column{Table: clause.CurrentTable, Name: field.DBName}
+	pk := clause.Column{Table: clause.CurrentTable, Name: clause.PrimaryKey}
+
+	var total int64
+	for {
+		var purged []T
+		err := r.save(options, func(db *gorm.DB) error {
+			batch := db.Session(&gorm.Session{NewDB: true}).Unscoped().Model(new(T)).
+				Select("?", pk).
+				Where(clause.Lt{Column: deletedAt, Value: before}).
+				Order(clause.OrderByColumn{Column: pk}).
+				Limit(purgeBatchSize).
+				Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"})
+			return db.Unscoped().Clauses(clause.Returning{}).
+				Where(clause.Lt{Column: deletedAt, Value: before}).
+				Where("? IN (?)", pk, batch).
+				Delete(&purged).Error
+		}, func() []change[T] {
+			return r.deletions(purged, false)
+		})
+		total += int64(len(purged))
+		if err != nil || len(purged) < purgeBatchSize {
+			return total, err
+		}
+	}
+}
+
+// softDelete returns the gorm.DeletedAt field of T, nil when T is always hard deleted
+func (r *genericRepository[T, ID]) softDelete() *schema.Field {
+	var entity T
+	sch, err := parseSchema(r.db, &entity)
+	if err != nil {
+		return nil
+	}
+	return softDeleteField(sch)
+}
+
+func softDeleteField(sch *schema.Schema) *schema.Field {
+	for _, field := range sch.Fields {
+		if field.FieldType == deletedAtType && field.DBName != "" {
+			return field
+		}
+	}
+	return nil
+}
+
+// WithTrashed includes soft deleted rows, which every query leaves out by default
+func WithTrashed() GORMOption {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Unscoped()
+	}
+}
+
+// OnlyTrashed selects nothing but soft deleted rows
+func OnlyTrashed() GORMOption {
+	return func(db *gorm.DB) *gorm.DB {
+		stmt := db.Statement
+		if stmt.Schema == nil && stmt.Model != nil {
+			if err := stmt.Parse(stmt.Model); err != nil {
+				db.AddError(err)
+				return db
+			}
+		}
+		if stmt.Schema == nil {
+			db.AddError(ErrNoSoftDelete)
+			return db
+		}
+
+		field := softDeleteField(stmt.Schema)
+		if field == nil {
+			db.AddError(ErrNoSoftDelete)
+			return db
+		}
+		deletedAt := clause.Column{Table: stmt.Schema.Table, Name: field.DBName}
+		return db.Unscoped().Where(clause.Neq{Column: deletedAt, Value: nil})
+	}
+}
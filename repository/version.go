@@ -0,0 +1,133 @@
+package repository
+
+import (
+	"context"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// Models opt in to optimistic locking with an integer Version field:
+//
+//	type Post struct {
+//		gorm.Model
+//		Version uint `gorm:"not null;default:1"`
+//	}
+//
+// Create starts it at 1 and every Update only succeeds while the stored version is still the one
+// that was read, incrementing it. Otherwise Update returns ErrConflict.
+
+// HasVersion reports whether T uses optimistic locking
+func HasVersion[T any]() bool {
+	var entity T
+	sch, err := schema.Parse(&entity, &querySchemas, schema.NamingStrategy{})
+	return err == nil && versionField(sch) != nil
+}
+
+// VersionOf returns the Version of entity, ok is false when T has none
+func VersionOf[T any](entity *T) (version int64, ok bool) {
+	sch, err := schema.Parse(entity, &querySchemas, schema.NamingStrategy{})
+	if err != nil {
+		return 0, false
+	}
+	field := versionField(sch)
+	if field == nil {
+		return 0, false
+	}
+	return getVersion(field, reflect.ValueOf(entity).Elem()), true
+}
+
+// SetVersion sets the Version of entity, e.g. to the one a client read, and reports whether T has one
+func SetVersion[T any](entity *T, version int64) bool {
+	sch, err := schema.Parse(entity, &querySchemas, schema.NamingStrategy{})
+	if err != nil {
+		return false
+	}
+	field := versionField(sch)
+	return field != nil && field.Set(context.Background(), reflect.ValueOf(entity).Elem(), version) == nil
+}
+
+func versionField(sch *schema.Schema) *schema.Field {
+	for _, field := range sch.Fields {
+		if isVersion(field) {
+			return field
+		}
+	}
+	return nil
+}
+
+func isVersion(field *schema.Field) bool {
+	return field.DBName == "version" && (field.DataType == schema.Int || field.DataType == schema.Uint)
+}
+
+func getVersion(field *schema.Field, entity reflect.Value) int64 {
+	value, _ := field.ValueOf(context.Background(), entity)
+	v := reflect.Indirect(reflect.ValueOf(value))
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(v.Uint())
+	}
+	return 0
+}
+
+// update writes every column of entity except the primary key and the creation time. Unlike db.Save it never
+// inserts: a row that does not exist, or is soft deleted, is gorm.ErrRecordNotFound. With a Version field
+// the row must still have the version of entity, which is incremented.
+func update[T any](db *gorm.DB, sch *schema.Schema, entity *T) error {
+	value := reflect.ValueOf(entity).Elem()
+	id, _ := sch.PrioritizedPrimaryField.ValueOf(context.Background(), value)
+
+	var omit []string
+	for _, field := range sch.Fields {
+		if field.AutoCreateTime > 0 && field.DBName != "" {
+			omit = append(omit, field.DBName)
+		}
+	}
+	query := db.Model(entity).Select("*")
+	if len(omit) > 0 {
+		query = query.Omit(omit...)
+	}
+
+	field := versionField(sch)
+	var version int64
+	if field != nil {
+		version = getVersion(field, value)
+		query = query.Where(clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: version})
+		if err := field.Set(context.Background(), value, version+1); err != nil {
+			return err
+		}
+	}
+
+	result := query.Updates(entity)
+	if result.Error == nil && result.RowsAffected > 0 {
+		return nil
+	}
+	if field != nil {
+		// leave the caller's entity as it was
+		_ = field.Set(context.Background(), value, version)
+	}
+	if result.Error != nil {
+		return result.Error
+	}
+	if field == nil {
+		return gorm.ErrRecordNotFound
+	}
+
+	return conflictOrNotFound[T](db, id)
+}
+
+// conflictOrNotFound explains why a versioned update changed no row: the row is gone or has another version
+func conflictOrNotFound[T any](db *gorm.DB, id interface{}) error {
+	var count int64
+	if err := db.Model(new(T)).Where(clause.Eq{Column: clause.PrimaryColumn, Value: id}).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return ErrConflict
+}
@@ -0,0 +1,104 @@
+package routes
+
+import (
+	"context"
+	"gorepository/publisher"
+	"gorepository/repository"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Replayer publishes dead lettered events again, e.g. a *publisher.DeadLetterStore
+type Replayer interface {
+	Replay(ctx context.Context, ids ...uint) (int, error)
+}
+
+// ReplayResult is the response of the replay endpoints
+type ReplayResult struct {
+	Replayed int    `json:"replayed"`
+	Error    string `json:"error,omitempty"` // why the replay stopped, the failed event stays dead lettered
+}
+
+// deadLetterQuery is what the dead letters can be filtered and sorted by, e.g. ?filter[replayed_at][null]=true
+var deadLetterQuery = repository.NewQuerySpec[publisher.DeadLetter]("entity_type", "entity_id", "type", "replayed_at", "created_at").
+	SortBy("-created_at")
+
+// SetupAdminRoutes registers the dead letter and webhook endpoints under /admin. They change what consumers receive
+// and send requests to any URL, so they answer 401 to requests no middleware like BearerAuth authenticated.
+//
+//	GET  /admin/dead-letters                 list, paged like the resources
+//	GET  /admin/dead-letters/:id
+//	POST /admin/dead-letters/:id/replay
+//	POST /admin/dead-letters/replay?id=1,2   all that were not replayed yet without ?id
+//
+// and the webhook subscriptions, see setupWebhookRoutes.
+func SetupAdminRoutes(app *fiber.App, repos *repository.Repositories, replayer Replayer, pinger Pinger) {
+	admin := app.Group("/admin", RequireIdentity())
+	setupWebhookRoutes(admin, repos, pinger)
+
+	admin.Get("/dead-letters", func(c *fiber.Ctx) error {
+		query, err := deadLetterQuery.Parse(c.Queries())
+		if err != nil {
+			return err
+		}
+
+		req, err := pageRequest(c, query)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+
+		page, err := repos.DeadLetterRepo.WithContext(c.UserContext()).ListPage(nil, req, query.FilterOptions()...)
+		if err != nil {
+			return err
+		}
+
+		setPageLinks(c, page)
+		return c.JSON(page)
+	})
+
+	admin.Get("/dead-letters/:id", func(c *fiber.Ctx) error {
+		id, err := strconv.ParseUint(c.Params("id"), 10, 0)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid ID")
+		}
+		deadLetter, err := repos.DeadLetterRepo.WithContext(c.UserContext()).FindByID(uint(id))
+		if err != nil {
+			return err
+		}
+		return c.JSON(deadLetter)
+	})
+
+	admin.Post("/dead-letters/:id/replay", func(c *fiber.Ctx) error {
+		id, err := strconv.ParseUint(c.Params("id"), 10, 0)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid ID")
+		}
+		if _, err := repos.DeadLetterRepo.WithContext(c.UserContext()).FindByID(uint(id)); err != nil {
+			return err
+		}
+		return replay(c, replayer, uint(id))
+	})
+
+	admin.Post("/dead-letters/replay", func(c *fiber.Ctx) error {
+		var ids []uint
+		for _, raw := range splitFields(c.Query("id")) {
+			id, err := strconv.ParseUint(strings.TrimSpace(raw), 10, 0)
+			if err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, "invalid ID "+raw)
+			}
+			ids = append(ids, uint(id))
+		}
+		return replay(c, replayer, ids...)
+	})
+}
+
+// replay answers 502 when the target still fails, with how many events were replayed before
+func replay(c *fiber.Ctx, replayer Replayer, ids ...uint) error {
+	replayed, err := replayer.Replay(c.UserContext(), ids...)
+	if err != nil {
+		return c.Status(fiber.StatusBadGateway).JSON(ReplayResult{Replayed: replayed, Error: err.Error()})
+	}
+	return c.JSON(ReplayResult{Replayed: replayed})
+}
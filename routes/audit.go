@@ -0,0 +1,75 @@
+package routes
+
+import (
+	"gorepository/audit"
+	"gorepository/repository"
+	"net"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// HeaderActor names who makes the request when an authenticating proxy in front of the API sets it
+const HeaderActor = "X-Actor"
+
+// Actor takes the identity of the caller, and so the audit actor, from header, but only for requests whose
+// remote address is one of proxies: a proxy that authenticates the caller and sets the header itself,
+// overwriting what the client sent. The header of any other request is ignored.
+func Actor(header string, proxies ...string) fiber.Handler {
+	trusted := make(map[string]bool, len(proxies))
+	for _, proxy := range proxies {
+		if ip := net.ParseIP(strings.TrimSpace(proxy)); ip != nil {
+			trusted[ip.String()] = true
+		}
+	}
+	return func(c *fiber.Ctx) error {
+		if actor := c.Get(header); actor != "" && trusted[c.Context().RemoteIP().String()] {
+			setIdentity(c, actor)
+		}
+		return c.Next()
+	}
+}
+
+// auditQuery is what the audit log can be filtered and sorted by besides entity and id
+var auditQuery = repository.NewQuerySpec[audit.Entry]("actor", "action", "created_at").SortBy("-created_at")
+
+// listAudit returns a page of the audit log, newest first, e.g. GET /audit?entity=post&id=1
+func listAudit(repo repository.GenericRepository[audit.Entry, uint]) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		query, err := auditQuery.Parse(c.Queries())
+		if err != nil {
+			return err
+		}
+
+		req, err := pageRequest(c, query)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+
+		var conditions []func(*gorm.DB) *gorm.DB
+		equal := func(column, value string) {
+			conditions = append(conditions, func(db *gorm.DB) *gorm.DB {
+				return db.Where(clause.Eq{Column: clause.Column{Name: column}, Value: value})
+			})
+		}
+		if entity := c.Query("entity"); entity != "" {
+			equal("entity_type", entity)
+		}
+		if id := c.Query("id"); id != "" {
+			if c.Query("entity") == "" {
+				return fiber.NewError(fiber.StatusBadRequest, "id needs entity")
+			}
+			equal("entity_id", id)
+		}
+
+		entries, err := repo.WithContext(c.UserContext()).ListPage(conditions, req, query.FilterOptions()...)
+		if err != nil {
+			return err
+		}
+
+		setPageLinks(c, entries)
+		return c.JSON(entries)
+	}
+}
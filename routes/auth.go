@@ -0,0 +1,68 @@
+package routes
+
+import (
+	"crypto/subtle"
+	"gorepository/audit"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// identityKey holds the authenticated caller in the fiber locals
+const identityKey = "identity"
+
+// Identity returns who the request was authenticated as, empty for anonymous requests
+func Identity(c *fiber.Ctx) string {
+	identity, _ := c.Locals(identityKey).(string)
+	return identity
+}
+
+// setIdentity records the caller and makes it the audit actor of the changes the request makes
+func setIdentity(c *fiber.Ctx, identity string) {
+	c.Locals(identityKey, identity)
+	c.SetUserContext(audit.WithActor(c.UserContext(), identity))
+}
+
+// BearerAuth authenticates requests by their Authorization: Bearer token, tokens maps each token to the
+// identity it stands for. Requests without a known token are answered with 401, those an earlier
+// middleware like Actor already authenticated pass.
+func BearerAuth(tokens map[string]string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if Identity(c) != "" {
+			return c.Next()
+		}
+
+		token, ok := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+		if ok {
+			if identity := lookUpToken(tokens, token); identity != "" {
+				setIdentity(c, identity)
+				return c.Next()
+			}
+		}
+		c.Set(fiber.HeaderWWWAuthenticate, "Bearer")
+		return fiber.ErrUnauthorized
+	}
+}
+
+// lookUpToken compares token with every known one in constant time, so the time taken tells nothing about them
+func lookUpToken(tokens map[string]string, token string) string {
+	var identity string
+	for known, name := range tokens {
+		if subtle.ConstantTimeCompare([]byte(known), []byte(token)) == 1 {
+			identity = name
+		}
+	}
+	return identity
+}
+
+// RequireIdentity answers requests no middleware authenticated with 401, e.g. for routes that must never be
+// open even when the API is
+func RequireIdentity() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if Identity(c) == "" {
+			c.Set(fiber.HeaderWWWAuthenticate, "Bearer")
+			return fiber.ErrUnauthorized
+		}
+		return c.Next()
+	}
+}
@@ -0,0 +1,198 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gorepository/repository"
+	"gorepository/validation"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+const (
+	maxBulkItems  = 1000 // entities per bulk create or upsert request
+	bulkBatchSize = 100  // rows per INSERT
+)
+
+// BulkResult is the response of the bulk endpoints, Items is left out by update and delete
+type BulkResult[T any] struct {
+	Count int64 `json:"count"`
+	Items []T   `json:"items,omitempty"`
+}
+
+// bulkCreate inserts a JSON array of entities, all of them or none
+func (r *resource[T, ID]) bulkCreate(c *fiber.Ctx) error {
+	entities, err := r.decodeMany(c, OpBulkCreate)
+	if err != nil {
+		return err
+	}
+
+	entities, err = r.repo.WithContext(c.UserContext()).CreateMany(entities, bulkBatchSize)
+	if err != nil {
+		return err
+	}
+	return c.JSON(BulkResult[T]{Count: int64(len(entities)), Items: entities})
+}
+
+// bulkUpsert inserts a JSON array of entities or updates the stored ones whose ?on= fields match, e.g.
+// PUT /users/bulk?on=Email. ?update=Name limits what is updated, by default every other writable field.
+func (r *resource[T, ID]) bulkUpsert(c *fiber.Ctx) error {
+	on := splitFields(c.Query("on"))
+	if len(on) == 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "on must name the fields to match stored entities by")
+	}
+
+	update := splitFields(c.Query("update"))
+	if len(update) == 0 {
+		for _, field := range r.opts.WritableFields {
+			if !containsFold(on, field) {
+				update = append(update, field)
+			}
+		}
+	}
+	for _, field := range append(append([]string{}, on...), update...) {
+		if !r.writable(field) {
+			return &repository.ErrValidation{Fields: map[string]string{field: "cannot be changed"}, Err: repository.ErrNotWritable}
+		}
+	}
+
+	entities, err := r.decodeMany(c, OpBulkUpsert)
+	if err != nil {
+		return err
+	}
+
+	entities, err = r.repo.WithContext(c.UserContext()).Upsert(entities, on, update)
+	if err != nil {
+		return err
+	}
+	return c.JSON(BulkResult[T]{Count: int64(len(entities)), Items: entities})
+}
+
+// bulkUpdate writes the fields of a JSON object to every entity matching the list filters, e.g.
+// PATCH /posts/bulk?filter[user_id]=1 with {"Published": true}
+func (r *resource[T, ID]) bulkUpdate(c *fiber.Ctx) error {
+	conditions, err := r.bulkConditions(c)
+	if err != nil {
+		return err
+	}
+
+	var changes map[string]interface{}
+	if err := json.Unmarshal(c.Body(), &changes); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "cannot parse JSON")
+	}
+	fields := make([]string, 0, len(changes))
+	for field := range changes {
+		if !r.writable(field) {
+			return &repository.ErrValidation{Fields: map[string]string{field: "cannot be changed"}, Err: repository.ErrNotWritable}
+		}
+		fields = append(fields, field)
+	}
+
+	// only the written fields are validated, the others differ from entity to entity
+	var entity T
+	if err := json.Unmarshal(c.Body(), &entity); err != nil {
+		return fiber.NewError(fiber.StatusUnprocessableEntity, err.Error())
+	}
+	if err := r.opts.Validator.ValidateFields(c.UserContext(), validation.Update, &entity, fields); err != nil {
+		return err
+	}
+	if err := r.validateOption(c, OpBulkUpdate, &entity); err != nil {
+		return err
+	}
+
+	count, err := r.repo.WithContext(c.UserContext()).UpdateWhere(conditions, changes)
+	if err != nil {
+		return err
+	}
+	return c.JSON(BulkResult[T]{Count: count})
+}
+
+// bulkDelete deletes every entity matching the list filters, e.g. DELETE /posts/bulk?filter[user_id]=1
+func (r *resource[T, ID]) bulkDelete(c *fiber.Ctx) error {
+	conditions, err := r.bulkConditions(c)
+	if err != nil {
+		return err
+	}
+
+	count, err := r.repo.WithContext(c.UserContext()).DeleteWhere(conditions)
+	if err != nil {
+		return err
+	}
+	return c.JSON(BulkResult[T]{Count: count})
+}
+
+// decodeMany reads a JSON array of entities and validates each of them, invalid fields are reported as [index].field
+func (r *resource[T, ID]) decodeMany(c *fiber.Ctx, op Operation) ([]T, error) {
+	var items []json.RawMessage
+	if err := json.Unmarshal(c.Body(), &items); err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "cannot parse JSON, expected an array")
+	}
+	if len(items) > maxBulkItems {
+		return nil, fiber.NewError(fiber.StatusRequestEntityTooLarge, fmt.Sprintf("at most %d items per request", maxBulkItems))
+	}
+
+	entities := make([]T, len(items))
+	problems := map[string]string{}
+	for i, item := range items {
+		if err := r.decode(item, &entities[i]); err != nil {
+			return nil, fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("cannot parse item %d", i))
+		}
+
+		err := r.validate(c, op, &entities[i])
+		var validationErr *repository.ErrValidation
+		if errors.As(err, &validationErr) {
+			for field, message := range validationErr.Fields {
+				problems[fmt.Sprintf("[%d].%s", i, field)] = message
+			}
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(problems) > 0 {
+		return nil, &repository.ErrValidation{Fields: problems}
+	}
+	return entities, nil
+}
+
+// bulkConditions returns the resource and scope conditions and the list filters, at least one filter is required
+func (r *resource[T, ID]) bulkConditions(c *fiber.Ctx) ([]func(*gorm.DB) *gorm.DB, error) {
+	query, err := r.opts.Query.Parse(c.Queries())
+	if err != nil {
+		return nil, err
+	}
+	if len(query.Filters) == 0 {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "at least one filter is required, e.g. ?filter[id][in]=1,2")
+	}
+
+	conditions, err := r.conditions(c)
+	if err != nil {
+		return nil, err
+	}
+	for _, opt := range query.FilterOptions() {
+		conditions = append(conditions, opt)
+	}
+	return conditions, nil
+}
+
+func splitFields(s string) []string {
+	var fields []string
+	for _, field := range strings.Split(s, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
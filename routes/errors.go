@@ -0,0 +1,93 @@
+package routes
+
+import (
+	"errors"
+	"gorepository/repository"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+const mimeProblem = "application/problem+json"
+
+// Problem is an RFC 7807 problem details body
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+
+	// extension members
+	Field   string                           `json:"field,omitempty"`   // the duplicate or invalid reference
+	Errors  map[string]string                `json:"errors,omitempty"`  // message per invalid field
+	Allowed map[string][]repository.Operator `json:"allowed,omitempty"` // filters a list accepts
+}
+
+// ErrorHandler renders the errors handlers return as application/problem+json, e.g.
+// repository.ErrNotFound as 404, repository.ErrDuplicate as 409, repository.ErrValidation as 422 and
+// repository.ErrPublish as 502.
+// Set it as fiber.Config.ErrorHandler.
+func ErrorHandler(c *fiber.Ctx, err error) error {
+	problem := NewProblem(c, err)
+	if problem.Status == fiber.StatusInternalServerError || problem.Status == fiber.StatusBadGateway {
+		log.Printf("%s %s: %v", c.Method(), c.Path(), err)
+	}
+	return c.Status(problem.Status).JSON(problem, mimeProblem)
+}
+
+// NewProblem describes err as problem details
+func NewProblem(c *fiber.Ctx, err error) Problem {
+	problem := Problem{Type: "about:blank", Status: fiber.StatusInternalServerError, Instance: c.OriginalURL()}
+
+	var (
+		fiberErr      *fiber.Error
+		duplicateErr  *repository.ErrDuplicate
+		foreignKeyErr *repository.ErrForeignKey
+		validationErr *repository.ErrValidation
+		queryErr      *repository.QueryError
+		publishErr    *repository.ErrPublish
+	)
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		problem.Status = fiber.StatusNotFound
+		problem.Detail = err.Error()
+	case errors.As(err, &duplicateErr):
+		problem.Status = fiber.StatusConflict
+		problem.Detail = err.Error()
+		problem.Field = duplicateErr.Field
+	case errors.Is(err, repository.ErrConflict):
+		// the client's If-Match no longer holds
+		problem.Status = fiber.StatusConflict
+		if c.Get(fiber.HeaderIfMatch) != "" {
+			problem.Status = fiber.StatusPreconditionFailed
+		}
+		problem.Detail = err.Error()
+	case errors.As(err, &foreignKeyErr):
+		problem.Status = fiber.StatusUnprocessableEntity
+		problem.Detail = err.Error()
+		problem.Field = foreignKeyErr.Field
+	case errors.As(err, &validationErr):
+		problem.Status = fiber.StatusUnprocessableEntity
+		problem.Detail = err.Error()
+		problem.Errors = validationErr.Fields
+	case errors.As(err, &queryErr):
+		problem.Status = fiber.StatusBadRequest
+		problem.Detail = queryErr.Message
+		problem.Allowed = queryErr.Allowed
+	case errors.Is(err, repository.ErrInvalidCursor):
+		problem.Status = fiber.StatusBadRequest
+		problem.Detail = err.Error()
+	case errors.As(err, &publishErr):
+		// the change itself was saved
+		problem.Status = fiber.StatusBadGateway
+		problem.Detail = err.Error()
+	case errors.As(err, &fiberErr):
+		problem.Status = fiberErr.Code
+		problem.Detail = fiberErr.Message
+	}
+
+	problem.Title = utils.StatusMessage(problem.Status)
+	return problem
+}
@@ -0,0 +1,77 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"gorepository/repository"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// maxPageSize caps ?pageSize=, larger values get pages of this size
+const maxPageSize = 100
+
+// pageRequest reads ?page=&pageSize= or, when a cursor parameter is present (even empty), keyset paging with ?cursor=
+func pageRequest(c *fiber.Ctx, query repository.Query) (repository.PageRequest, error) {
+	req := repository.PageRequest{Sort: query.Sort}
+
+	pageSize, err := strconv.Atoi(c.Query("pageSize", "10"))
+	if err != nil || pageSize <= 0 {
+		return req, errors.New("invalid page size")
+	}
+	req.PageSize = min(pageSize, maxPageSize)
+
+	if c.Context().QueryArgs().Has("cursor") {
+		req.Keyset = true
+		req.Cursor = c.Query("cursor")
+		return req, nil
+	}
+
+	page, err := strconv.Atoi(c.Query("page", "1"))
+	if err != nil || page < 1 {
+		return req, errors.New("invalid page number")
+	}
+	req.Page = page
+	return req, nil
+}
+
+// setPageLinks adds an RFC 8288 Link header pointing to the first, previous, next and last page
+func setPageLinks[T any](c *fiber.Ctx, page repository.Page[T]) {
+	query, _ := url.ParseQuery(string(c.Request().URI().QueryString()))
+	link := func(rel string, set func(url.Values)) string {
+		q := url.Values{}
+		for key, values := range query {
+			q[key] = values
+		}
+		set(q)
+		return fmt.Sprintf(`<%s%s?%s>; rel="%s"`, c.BaseURL(), c.Path(), q.Encode(), rel)
+	}
+
+	var links []string
+	if page.Page == 0 {
+		// keyset paging only knows the way forward
+		links = append(links, link("first", func(q url.Values) { q.Set("cursor", "") }))
+		if page.HasNext {
+			links = append(links, link("next", func(q url.Values) { q.Set("cursor", page.NextCursor) }))
+		}
+	} else {
+		setPage := func(n int) func(url.Values) {
+			return func(q url.Values) { q.Set("page", strconv.Itoa(n)) }
+		}
+		links = append(links, link("first", setPage(1)))
+		if page.Page > 1 {
+			links = append(links, link("prev", setPage(page.Page-1)))
+		}
+		if page.HasNext {
+			links = append(links, link("next", setPage(page.Page+1)))
+		}
+		if page.TotalPages > 0 {
+			links = append(links, link("last", setPage(page.TotalPages)))
+		}
+	}
+
+	c.Set(fiber.HeaderLink, strings.Join(links, ", "))
+}
@@ -0,0 +1,533 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gorepository/publisher"
+	"gorepository/repository"
+	"gorepository/validation"
+	"mime"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Operation is one of the endpoints mounted by RegisterResource
+type Operation string
+
+const (
+	OpList    Operation = "list"    // GET    path
+	OpGet     Operation = "get"     // GET    path/:id
+	OpCreate  Operation = "create"  // POST   path
+	OpUpdate  Operation = "update"  // PUT    path/:id
+	OpPatch   Operation = "patch"   // PATCH  path/:id
+	OpDelete  Operation = "delete"  // DELETE path/:id, a soft delete when the model supports it
+	OpPurge   Operation = "purge"   // DELETE path/:id?hard=true
+	OpRestore Operation = "restore" // POST   path/:id/restore
+
+	OpBulkCreate Operation = "bulk_create" // POST   path/bulk
+	OpBulkUpsert Operation = "bulk_upsert" // PUT    path/bulk?on=field
+	OpBulkUpdate Operation = "bulk_update" // PATCH  path/bulk?filter[...]
+	OpBulkDelete Operation = "bulk_delete" // DELETE path/bulk?filter[...]
+)
+
+// ResourceOptions customizes the endpoints of RegisterResource, the zero value mounts everything
+type ResourceOptions[T any] struct {
+	// Name used in error messages, defaults to the lower case type name, e.g. "user"
+	Name string
+	// Query whitelists what the list endpoint filters and sorts by, defaults to every column
+	Query *repository.QuerySpec
+	// WritableFields are the JSON fields a client may set on create, update and patch, every other field
+	// of the body is ignored. Defaults to repository.WritableFields, i.e. everything but ID and timestamps,
+	// server owned fields are left out even when listed.
+	WritableFields []string
+	// Conditions scope every endpoint, e.g. is_deleted = false. Rows outside of them are not found.
+	Conditions []func(*gorm.DB) *gorm.DB
+	// Scope adds conditions taken from the request to those, e.g. the user of /users/:user_id/posts. Its
+	// error is answered instead, e.g. a 404 for a user that does not exist.
+	Scope func(c *fiber.Ctx) ([]func(*gorm.DB) *gorm.DB, error)
+	// Validator checks the `validate` tags of T before create, update and patch, defaults to validation.New()
+	Validator *validation.Validator
+	// Validate runs after the Validator, an error is answered with 422. For OpBulkUpdate entity only has
+	// the fields the request writes.
+	Validate func(c *fiber.Ctx, op Operation, entity *T) error
+	// Disabled operations are not mounted
+	Disabled []Operation
+}
+
+// RegisterResource mounts list, get, create, update, patch, delete and bulk endpoints of repo under path:
+//
+//	routes.RegisterResource(app, "/users", repos.UserRepo, routes.ResourceOptions[model.User]{})
+func RegisterResource[T any, ID comparable](router fiber.Router, path string, repo repository.GenericRepository[T, ID], opts ResourceOptions[T]) {
+	r := &resource[T, ID]{repo: repo, opts: opts}
+	if r.opts.Name == "" {
+		r.opts.Name = publisher.EntityType(new(T))
+	}
+	if r.opts.Query == nil {
+		r.opts.Query = repository.NewQuerySpec[T]()
+	}
+	if len(r.opts.WritableFields) == 0 {
+		r.opts.WritableFields = repository.WritableFields[T]()
+	} else {
+		r.opts.WritableFields = r.writableFields(repository.WritableFields[T]())
+	}
+	if r.opts.Validator == nil {
+		r.opts.Validator = validation.New()
+	}
+
+	handlers := []struct {
+		op       Operation
+		register func(path string, handlers ...fiber.Handler) fiber.Router
+		path     string
+		handler  fiber.Handler
+	}{
+		{OpList, router.Get, path, r.list},
+		// before path/:id, which would match path/bulk as well
+		{OpBulkCreate, router.Post, path + "/bulk", r.bulkCreate},
+		{OpBulkUpsert, router.Put, path + "/bulk", r.bulkUpsert},
+		{OpBulkUpdate, router.Patch, path + "/bulk", r.bulkUpdate},
+		{OpBulkDelete, router.Delete, path + "/bulk", r.bulkDelete},
+		{OpGet, router.Get, path + "/:id", r.get},
+		{OpCreate, router.Post, path, r.create},
+		{OpUpdate, router.Put, path + "/:id", r.update},
+		{OpPatch, router.Patch, path + "/:id", r.patch},
+		{OpDelete, router.Delete, path + "/:id", r.delete},
+		{OpRestore, router.Post, path + "/:id/restore", r.restore},
+	}
+	for _, h := range handlers {
+		if h.op == OpRestore && !repository.HasSoftDelete[T]() {
+			continue
+		}
+		if !r.disabled(h.op) {
+			h.register(h.path, h.handler)
+		}
+	}
+}
+
+type resource[T any, ID comparable] struct {
+	repo repository.GenericRepository[T, ID]
+	opts ResourceOptions[T]
+}
+
+func (r *resource[T, ID]) list(c *fiber.Ctx) error {
+	query, err := r.opts.Query.Parse(c.Queries())
+	if err != nil {
+		return err
+	}
+
+	req, err := pageRequest(c, query)
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	opts, err := trashed(c)
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	opts = append(opts, query.FilterOptions()...)
+
+	conditions, err := r.conditions(c)
+	if err != nil {
+		return err
+	}
+	page, err := r.repo.WithContext(c.UserContext()).ListPage(conditions, req, opts...)
+	if err != nil {
+		return err
+	}
+
+	setPageLinks(c, page)
+	return c.JSON(page)
+}
+
+func (r *resource[T, ID]) get(c *fiber.Ctx) error {
+	id, err := repository.ParseID[ID](c.Params("id"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid ID")
+	}
+
+	opts, err := trashed(c)
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	entity, err := r.find(c, id, opts...)
+	if err != nil {
+		return r.wrap(err)
+	}
+
+	setETag(c, &entity)
+	return c.JSON(entity)
+}
+
+func (r *resource[T, ID]) create(c *fiber.Ctx) error {
+	var entity T
+	if err := r.decode(c.Body(), &entity); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "cannot parse JSON")
+	}
+
+	if err := r.validate(c, OpCreate, &entity); err != nil {
+		return err
+	}
+
+	entity, err := r.repo.WithContext(c.UserContext()).Create(entity)
+	if err != nil {
+		return err
+	}
+
+	setETag(c, &entity)
+	return c.JSON(entity)
+}
+
+func (r *resource[T, ID]) update(c *fiber.Ctx) error {
+	id, err := repository.ParseID[ID](c.Params("id"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid ID")
+	}
+
+	entity, err := r.find(c, id)
+	if err != nil {
+		return r.wrap(err)
+	}
+	if !ifMatch(c, &entity) {
+		return r.wrap(repository.ErrConflict)
+	}
+
+	// a PUT replaces every writable field, the server owned ones (ID, CreatedAt...) are kept
+	var zero T
+	empty, _ := json.Marshal(zero)
+	if err := r.decode(empty, &entity); err != nil {
+		return err
+	}
+	if err := r.decode(c.Body(), &entity); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "cannot parse JSON")
+	}
+
+	return r.save(c, OpUpdate, entity)
+}
+
+// patch accepts a JSON merge patch (application/merge-patch+json or application/json) or a
+// JSON patch (application/json-patch+json) and only writes the fields it changes
+func (r *resource[T, ID]) patch(c *fiber.Ctx) error {
+	id, err := repository.ParseID[ID](c.Params("id"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid ID")
+	}
+
+	entity, err := r.find(c, id)
+	if err != nil {
+		return r.wrap(err)
+	}
+	if !ifMatch(c, &entity) {
+		return r.wrap(repository.ErrConflict)
+	}
+
+	// patches apply to the JSON document of the entity, once to keep the original and once to change
+	current, err := json.Marshal(entity)
+	if err != nil {
+		return err
+	}
+	var before, doc interface{}
+	_ = json.Unmarshal(current, &before)
+	_ = json.Unmarshal(current, &doc)
+
+	mediaType, _, _ := mime.ParseMediaType(c.Get(fiber.HeaderContentType))
+	switch mediaType {
+	case mimeMergePatch, fiber.MIMEApplicationJSON, "":
+		var patch interface{}
+		if err := json.Unmarshal(c.Body(), &patch); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "cannot parse JSON")
+		}
+		if _, ok := patch.(map[string]interface{}); !ok {
+			return fiber.NewError(fiber.StatusBadRequest, "a merge patch must be a JSON object")
+		}
+		doc = mergePatch(doc, patch)
+	case mimeJSONPatch:
+		var operations []patchOperation
+		if err := json.Unmarshal(c.Body(), &operations); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "cannot parse JSON patch")
+		}
+		doc, err = applyJSONPatch(doc, operations)
+		if errors.Is(err, errTestFailed) {
+			return fiber.NewError(fiber.StatusConflict, err.Error())
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusUnprocessableEntity, err.Error())
+		}
+	default:
+		return fiber.NewError(fiber.StatusUnsupportedMediaType, "use "+mimeMergePatch+" or "+mimeJSONPatch)
+	}
+
+	after, ok := doc.(map[string]interface{})
+	if !ok {
+		return fiber.NewError(fiber.StatusUnprocessableEntity, "the patched "+r.opts.Name+" must be a JSON object")
+	}
+	changes := changedFields(before.(map[string]interface{}), after)
+	for field := range changes {
+		if !r.writable(field) {
+			return &repository.ErrValidation{Fields: map[string]string{field: "cannot be changed"}, Err: repository.ErrNotWritable}
+		}
+	}
+
+	// validate the entity as it will be stored
+	var patched T
+	data, _ := json.Marshal(after)
+	if err := json.Unmarshal(data, &patched); err != nil {
+		return fiber.NewError(fiber.StatusUnprocessableEntity, err.Error())
+	}
+	if err := r.validate(c, OpPatch, &patched); err != nil {
+		return err
+	}
+
+	// the version that was read, so a change made since then is a conflict
+	var opts []repository.Option
+	if version, ok := repository.VersionOf(&entity); ok {
+		opts = append(opts, repository.IfVersion(version))
+	}
+
+	entity, err = r.repo.WithContext(c.UserContext()).Patch(id, changes, opts...)
+	if err != nil {
+		return r.wrap(err)
+	}
+
+	setETag(c, &entity)
+	return c.JSON(entity)
+}
+
+func (r *resource[T, ID]) delete(c *fiber.Ctx) error {
+	id, err := repository.ParseID[ID](c.Params("id"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid ID")
+	}
+
+	repo := r.repo.WithContext(c.UserContext())
+	if c.QueryBool("hard") {
+		if r.disabled(OpPurge) {
+			return fiber.NewError(fiber.StatusMethodNotAllowed, "hard delete is disabled")
+		}
+		// a soft deleted row can still be purged
+		if _, err := r.find(c, id, repository.WithTrashed()); err != nil {
+			return r.wrap(err)
+		}
+		err = repo.HardDelete(id)
+	} else {
+		if _, err := r.find(c, id); err != nil {
+			return r.wrap(err)
+		}
+		err = repo.Delete(id)
+	}
+	if err != nil {
+		return r.wrap(err)
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (r *resource[T, ID]) restore(c *fiber.Ctx) error {
+	id, err := repository.ParseID[ID](c.Params("id"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid ID")
+	}
+
+	if _, err := r.find(c, id, repository.OnlyTrashed()); err != nil {
+		return r.wrap(err)
+	}
+
+	entity, err := r.repo.WithContext(c.UserContext()).Restore(id)
+	if err != nil {
+		return r.wrap(err)
+	}
+
+	setETag(c, &entity)
+	return c.JSON(entity)
+}
+
+func (r *resource[T, ID]) save(c *fiber.Ctx, op Operation, entity T) error {
+	if err := r.validate(c, op, &entity); err != nil {
+		return err
+	}
+
+	entity, err := r.repo.WithContext(c.UserContext()).Update(entity)
+	if err != nil {
+		return r.wrap(err)
+	}
+
+	setETag(c, &entity)
+	return c.JSON(entity)
+}
+
+// setETag sends the version of a model with optimistic locking as ETag
+func setETag[T any](c *fiber.Ctx, entity *T) {
+	if version, ok := repository.VersionOf(entity); ok {
+		c.Set(fiber.HeaderETag, etag(version))
+	}
+}
+
+// ifMatch reports whether the If-Match header, if any, names the current version of entity
+func ifMatch[T any](c *fiber.Ctx, entity *T) bool {
+	header := c.Get(fiber.HeaderIfMatch)
+	version, ok := repository.VersionOf(entity)
+	if header == "" || !ok {
+		return true
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag(version) {
+			return true
+		}
+	}
+	return false
+}
+
+func etag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// conditions returns the resource conditions and those of its scope for the request
+func (r *resource[T, ID]) conditions(c *fiber.Ctx) ([]func(*gorm.DB) *gorm.DB, error) {
+	conditions := append([]func(*gorm.DB) *gorm.DB{}, r.opts.Conditions...)
+	if r.opts.Scope == nil {
+		return conditions, nil
+	}
+	scope, err := r.opts.Scope(c)
+	if err != nil {
+		return nil, err
+	}
+	return append(conditions, scope...), nil
+}
+
+// find loads the entity with id, as long as it matches the resource conditions
+func (r *resource[T, ID]) find(c *fiber.Ctx, id ID, opts ...repository.GORMOption) (T, error) {
+	conditions, err := r.conditions(c)
+	if err != nil {
+		var entity T
+		return entity, err
+	}
+	conditions = append(conditions, func(db *gorm.DB) *gorm.DB {
+		return db.Where(clause.Eq{Column: clause.PrimaryColumn, Value: id})
+	})
+
+	opts = append(opts, func(db *gorm.DB) *gorm.DB {
+		return db.Limit(1)
+	})
+
+	var entities []T
+	err = r.repo.WithContext(c.UserContext()).GetWithConditions(&entities, conditions, opts...)
+	if err != nil {
+		var entity T
+		return entity, err
+	}
+	if len(entities) == 0 {
+		var entity T
+		return entity, repository.ErrNotFound
+	}
+	return entities[0], nil
+}
+
+// wrap names the resource in the message of a not found or conflict error, e.g. "user not found".
+// The ErrorHandler turns the errors handlers return into problem details.
+func (r *resource[T, ID]) wrap(err error) error {
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		return fmt.Errorf("%s %w", r.opts.Name, repository.ErrNotFound)
+	case errors.Is(err, repository.ErrConflict):
+		return fmt.Errorf("%w, fetch the %s again", repository.ErrConflict, r.opts.Name)
+	}
+	return err
+}
+
+// decode copies the writable fields of a JSON object into entity, leaving the others untouched
+func (r *resource[T, ID]) decode(data []byte, entity *T) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+
+	for key := range fields {
+		if !r.writable(key) {
+			delete(fields, key)
+		}
+	}
+
+	body, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, entity)
+}
+
+func (r *resource[T, ID]) writable(field string) bool {
+	for _, f := range r.opts.WritableFields {
+		// encoding/json matches keys case insensitively as well
+		if strings.EqualFold(f, field) {
+			return true
+		}
+	}
+	return false
+}
+
+// writableFields returns the WritableFields option without the fields the server owns
+func (r *resource[T, ID]) writableFields(allowed []string) []string {
+	var fields []string
+	for _, field := range r.opts.WritableFields {
+		for _, a := range allowed {
+			if strings.EqualFold(field, a) {
+				fields = append(fields, a)
+				break
+			}
+		}
+	}
+	return fields
+}
+
+// validate checks the validate tags with the create or update rules, then runs the Validate option.
+// Invalid fields are answered with 422 and a message per field.
+func (r *resource[T, ID]) validate(c *fiber.Ctx, op Operation, entity *T) error {
+	set := validation.Update
+	if op == OpCreate || op == OpBulkCreate || op == OpBulkUpsert {
+		set = validation.Create
+	}
+	if err := r.opts.Validator.Validate(c.UserContext(), set, entity); err != nil {
+		return err
+	}
+	return r.validateOption(c, op, entity)
+}
+
+// validateOption runs the Validate option, its errors are answered with 422
+func (r *resource[T, ID]) validateOption(c *fiber.Ctx, op Operation, entity *T) error {
+	if r.opts.Validate == nil {
+		return nil
+	}
+	err := r.opts.Validate(c, op, entity)
+	var validationErr *repository.ErrValidation
+	if err == nil || errors.As(err, &validationErr) {
+		return err
+	}
+	return fiber.NewError(fiber.StatusUnprocessableEntity, err.Error())
+}
+
+func (r *resource[T, ID]) disabled(op Operation) bool {
+	for _, d := range r.opts.Disabled {
+		if d == op {
+			return true
+		}
+	}
+	return false
+}
+
+// trashed reads ?trashed=with (include soft deleted rows) or ?trashed=only
+func trashed(c *fiber.Ctx) ([]repository.GORMOption, error) {
+	switch c.Query("trashed") {
+	case "":
+		return nil, nil
+	case "with":
+		return []repository.GORMOption{repository.WithTrashed()}, nil
+	case "only":
+		return []repository.GORMOption{repository.OnlyTrashed()}, nil
+	default:
+		return nil, errors.New("trashed must be \"with\" or \"only\"")
+	}
+}
@@ -0,0 +1,415 @@
+package routes_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gorepository/model"
+	"gorepository/publisher"
+	"gorepository/repository"
+	"gorepository/routes"
+	"gorepository/stream"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// newApp serves the routes of SetupRoutes from memory repositories behind the middlewares
+func newApp(rec *publisher.Recorder, middlewares ...fiber.Handler) *fiber.App {
+	repos := repository.NewMemoryRepositories(rec, repository.WithAudit(true))
+	app := fiber.New(fiber.Config{ErrorHandler: routes.ErrorHandler})
+	for _, middleware := range middlewares {
+		app.Use(middleware)
+	}
+	routes.SetupRoutes(app, repos)
+	return app
+}
+
+// call sends a request through app.Test and decodes the JSON response into a map, headers are name, value pairs
+func call(t *testing.T, app *fiber.App, method, path, body string, headers ...string) (int, http.Header, map[string]interface{}) {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]interface{}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatalf("%s %s: %v in %s", method, path, err, data)
+		}
+	}
+	return resp.StatusCode, resp.Header, decoded
+}
+
+func TestUserResource(t *testing.T) {
+	rec := &publisher.Recorder{}
+	app := newApp(rec)
+
+	tests := []struct {
+		method, path, body string
+		status             int
+		check              func(t *testing.T, body map[string]interface{})
+	}{
+		{method: "POST", path: "/users", body: `{"Name":"Ann","Email":"ann@example.com"}`, status: 200, check: func(t *testing.T, body map[string]interface{}) {
+			if body["ID"] != 1.0 || body["Name"] != "Ann" {
+				t.Errorf("created %v", body)
+			}
+		}},
+		{method: "GET", path: "/users/1", status: 200, check: func(t *testing.T, body map[string]interface{}) {
+			if body["Email"] != "ann@example.com" {
+				t.Errorf("got %v", body)
+			}
+		}},
+		{method: "POST", path: "/users", body: `{"Name":"Bob","Email":"bob"}`, status: 422, check: func(t *testing.T, body map[string]interface{}) {
+			errors, _ := body["errors"].(map[string]interface{})
+			if errors["Email"] == nil {
+				t.Errorf("no error for Email in %v", body)
+			}
+		}},
+		{method: "POST", path: "/users", body: `{"Name":"Ann","Email":"ann@example.com"}`, status: 409},
+		{method: "POST", path: "/users", body: `{"Name":"Bob","Email":"bob@example.com"}`, status: 200},
+		// the email is unique among the other users, an update keeps its own. The failed create took ID 2 like a sequence.
+		{method: "PUT", path: "/users/3", body: `{"Name":"Bob","Email":"ann@example.com"}`, status: 409},
+		{method: "PUT", path: "/users/1", body: `{"Name":"Ann B.","Email":"ann@example.com"}`, status: 200},
+		{method: "PUT", path: "/users/1", body: `{"Email":"ann@example.com"}`, status: 422},
+		{method: "POST", path: "/users", body: `{`, status: 400},
+		{method: "GET", path: "/users/4", status: 404},
+		{method: "GET", path: "/users/x", status: 400},
+		{method: "GET", path: "/users?pageSize=1000", status: 200, check: func(t *testing.T, body map[string]interface{}) {
+			if body["pageSize"] != 100.0 || body["total"] != 2.0 {
+				t.Errorf("page %v, want the page size capped at 100", body)
+			}
+		}},
+		{method: "GET", path: "/users?filter[password]=x", status: 400},
+		{method: "DELETE", path: "/users/1", status: 204},
+		{method: "GET", path: "/users/1", status: 404},
+		{method: "POST", path: "/users/1/restore", status: 200},
+	}
+	for _, tt := range tests {
+		status, header, body := call(t, app, tt.method, tt.path, tt.body)
+		if status != tt.status {
+			t.Fatalf("%s %s = %d %v, want %d", tt.method, tt.path, status, body, tt.status)
+		}
+		if contentType := header.Get(fiber.HeaderContentType); status >= 400 && !strings.HasPrefix(contentType, "application/problem+json") {
+			t.Errorf("%s %s: content type %q, want application/problem+json", tt.method, tt.path, contentType)
+		}
+		if tt.check != nil {
+			tt.check(t, body)
+		}
+	}
+
+	want := []publisher.Action{publisher.Created, publisher.Created, publisher.Updated, publisher.Deleted, publisher.Restored}
+	if got := rec.Actions(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("published %v, want %v", got, want)
+	}
+}
+
+func TestPostResource(t *testing.T) {
+	app := newApp(&publisher.Recorder{})
+	call(t, app, "POST", "/users", `{"Name":"Ann","Email":"ann@example.com"}`)
+
+	tests := []struct {
+		method, path, body string
+		headers            []string
+		status             int
+		etag               string // the ETag the response has to send, if any
+		check              func(t *testing.T, body map[string]interface{})
+	}{
+		{method: "POST", path: "/posts", body: `{"Title":"draft","UserID":1}`, status: 200, etag: `"1"`},
+		{method: "GET", path: "/posts/1", status: 200, etag: `"1"`},
+		{method: "PUT", path: "/posts/1", body: `{"Title":"final","UserID":1,"Version":7}`, headers: []string{"If-Match", `"1"`}, status: 200, etag: `"2"`, check: func(t *testing.T, body map[string]interface{}) {
+			if body["Title"] != "final" || body["Version"] != 2.0 {
+				t.Errorf("updated %v, want the title written and the version bumped once", body)
+			}
+		}},
+		// the version read before the update
+		{method: "PUT", path: "/posts/1", body: `{"Title":"stale","UserID":1}`, headers: []string{"If-Match", `"1"`}, status: 412},
+		{method: "PUT", path: "/posts/1", body: `{"Title":"final","UserID":1}`, headers: []string{"If-Match", `W/"2", "5"`}, status: 200, etag: `"3"`},
+		{method: "PUT", path: "/posts/1", body: `{"Title":"any","Content":"text","UserID":1}`, headers: []string{"If-Match", "*"}, status: 200, etag: `"4"`},
+
+		// merge patch writes what it names, null included
+		{method: "PATCH", path: "/posts/1", body: `{"Published":true,"Content":null}`, headers: []string{"Content-Type", "application/merge-patch+json"}, status: 200, etag: `"5"`, check: func(t *testing.T, body map[string]interface{}) {
+			if body["Published"] != true || body["Title"] != "any" || body["Content"] != "" {
+				t.Errorf("patched %v, want only Published and Content changed", body)
+			}
+		}},
+		{method: "PATCH", path: "/posts/1", body: `{"Published":false}`, headers: []string{"Content-Type", "application/merge-patch+json", "If-Match", `"4"`}, status: 412},
+		{method: "PATCH", path: "/posts/1", body: `{"Title":"x","Version":9}`, headers: []string{"Content-Type", "application/merge-patch+json"}, status: 422, check: func(t *testing.T, body map[string]interface{}) {
+			if errors, _ := body["errors"].(map[string]interface{}); errors["Version"] == nil {
+				t.Errorf("no error for the server owned Version in %v", body)
+			}
+		}},
+		{method: "PATCH", path: "/posts/1", body: `{"Title":""}`, headers: []string{"Content-Type", "application/merge-patch+json"}, status: 422},
+		{method: "PATCH", path: "/posts/1", body: `[1]`, headers: []string{"Content-Type", "application/merge-patch+json"}, status: 400},
+
+		// JSON patch
+		{method: "PATCH", path: "/posts/1", body: `[{"op":"test","path":"/Title","value":"draft"},{"op":"replace","path":"/Title","value":"final"}]`, headers: []string{"Content-Type", "application/json-patch+json"}, status: 409},
+		{method: "PATCH", path: "/posts/1", body: `[{"op":"test","path":"/Title","value":"any"},{"op":"replace","path":"/Title","value":"final"}]`, headers: []string{"Content-Type", "application/json-patch+json"}, status: 200, etag: `"6"`, check: func(t *testing.T, body map[string]interface{}) {
+			if body["Title"] != "final" {
+				t.Errorf("patched %v, want the title replaced", body)
+			}
+		}},
+		{method: "PATCH", path: "/posts/1", body: `[{"op":"replace","path":"/ID","value":2}]`, headers: []string{"Content-Type", "application/json-patch+json"}, status: 422},
+		{method: "PATCH", path: "/posts/1", body: `[{"op":"remove","path":"/Missing"}]`, headers: []string{"Content-Type", "application/json-patch+json"}, status: 422},
+		{method: "PATCH", path: "/posts/1", body: `Title=x`, headers: []string{"Content-Type", "text/plain"}, status: 415},
+		{method: "GET", path: "/posts/1", status: 200, etag: `"6"`},
+	}
+	for _, tt := range tests {
+		status, header, body := call(t, app, tt.method, tt.path, tt.body, tt.headers...)
+		if status != tt.status {
+			t.Fatalf("%s %s %v = %d %v, want %d", tt.method, tt.path, tt.headers, status, body, tt.status)
+		}
+		if tt.etag != "" && header.Get(fiber.HeaderETag) != tt.etag {
+			t.Fatalf("%s %s: ETag %q, want %s", tt.method, tt.path, header.Get(fiber.HeaderETag), tt.etag)
+		}
+		if tt.check != nil {
+			tt.check(t, body)
+		}
+	}
+}
+
+func TestUserPosts(t *testing.T) {
+	app := newApp(&publisher.Recorder{})
+	call(t, app, "POST", "/users", `{"Name":"Ann","Email":"ann@example.com"}`)
+	call(t, app, "POST", "/users", `{"Name":"Bob","Email":"bob@example.com"}`)
+	call(t, app, "POST", "/posts", `{"Title":"first","UserID":1}`)
+	call(t, app, "POST", "/posts", `{"Title":"second","UserID":2}`)
+
+	status, _, body := call(t, app, "GET", "/users/1/posts", "")
+	items, _ := body["items"].([]interface{})
+	if status != 200 || len(items) != 1 || items[0].(map[string]interface{})["Title"] != "first" {
+		t.Fatalf("posts of user 1 = %d %v, want the first post", status, body)
+	}
+	for path, want := range map[string]int{"/users/1/posts/1": 200, "/users/1/posts/2": 404, "/users/3/posts": 404, "/users/x/posts": 400} {
+		if status, _, body := call(t, app, "GET", path, ""); status != want {
+			t.Fatalf("GET %s = %d %v, want %d", path, status, body, want)
+		}
+	}
+
+	// the old paths redirect with the method kept
+	for _, tt := range []struct{ method, path, location string }{
+		{"POST", "/user", "/users"},
+		{"POST", "/post", "/posts"},
+		{"GET", "/post/user/1?page=2", "/users/1/posts?page=2"},
+	} {
+		resp, err := app.Test(httptest.NewRequest(tt.method, tt.path, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != 308 || resp.Header.Get("Location") != tt.location || resp.Header.Get("Deprecation") != "true" {
+			t.Fatalf("%s %s = %d to %q, want a deprecated 308 to %s", tt.method, tt.path, resp.StatusCode, resp.Header.Get("Location"), tt.location)
+		}
+	}
+}
+
+func TestActor(t *testing.T) {
+	tokens := map[string]string{"s3cr3t": "ann"}
+	user := `{"Name":"Ann","Email":"ann@example.com"}`
+
+	// actor returns the actor of the audit entry of user 1
+	actor := func(t *testing.T, app *fiber.App, headers ...string) interface{} {
+		t.Helper()
+		_, _, body := call(t, app, "GET", "/audit?entity=user&id=1", "", headers...)
+		items, _ := body["items"].([]interface{})
+		if len(items) != 1 {
+			t.Fatalf("audit log %v, want one entry", body)
+		}
+		return items[0].(map[string]interface{})["Actor"]
+	}
+
+	t.Run("bearer token", func(t *testing.T) {
+		app := newApp(&publisher.Recorder{}, routes.BearerAuth(tokens))
+		if status, _, _ := call(t, app, "POST", "/users", user); status != 401 {
+			t.Fatalf("without a token: %d, want 401", status)
+		}
+		if status, _, _ := call(t, app, "POST", "/users", user, "Authorization", "Bearer wrong"); status != 401 {
+			t.Fatalf("with an unknown token: %d, want 401", status)
+		}
+		if status, _, _ := call(t, app, "POST", "/users", user, "Authorization", "Bearer s3cr3t", routes.HeaderActor, "mallory"); status != 200 {
+			t.Fatalf("with a token: %d, want 200", status)
+		}
+		if got := actor(t, app, "Authorization", "Bearer s3cr3t"); got != "ann" {
+			t.Fatalf("actor = %v, want ann, the identity of the token", got)
+		}
+	})
+
+	t.Run("untrusted header", func(t *testing.T) {
+		app := newApp(&publisher.Recorder{}, routes.Actor(routes.HeaderActor, "10.0.0.2"))
+		call(t, app, "POST", "/users", user, routes.HeaderActor, "mallory")
+		if got := actor(t, app); got != "system" {
+			t.Fatalf("actor = %v, want system", got)
+		}
+	})
+
+	t.Run("trusted proxy", func(t *testing.T) {
+		// app.Test requests come from 0.0.0.0
+		app := newApp(&publisher.Recorder{}, routes.Actor(routes.HeaderActor, "0.0.0.0"), routes.BearerAuth(tokens))
+		if status, _, _ := call(t, app, "POST", "/users", user, routes.HeaderActor, "bob"); status != 200 {
+			t.Fatalf("from the proxy: %d, want 200", status)
+		}
+		if got := actor(t, app, routes.HeaderActor, "bob"); got != "bob" {
+			t.Fatalf("actor = %v, want bob", got)
+		}
+	})
+}
+
+func TestAdminWebhooks(t *testing.T) {
+	repos := repository.NewMemoryRepositories(nil)
+	app := fiber.New(fiber.Config{ErrorHandler: routes.ErrorHandler})
+	app.Use(routes.BearerAuth(map[string]string{"s3cr3t": "ann"}))
+	routes.SetupAdminRoutes(app, repos, publisher.NewDeadLetterStore(nil, nil), publisher.NewWebhooks(nil))
+	auth := []string{"Authorization", "Bearer s3cr3t"}
+
+	stored := func() string {
+		subscription, err := repos.WebhookRepo.FindByID(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return subscription.Secret
+	}
+
+	if status, _, _ := call(t, app, "GET", "/admin/webhooks", ""); status != 401 {
+		t.Fatalf("without a token: %d, want 401", status)
+	}
+
+	status, _, created := call(t, app, "POST", "/admin/webhooks", `{"URL":"https://example.com/hook","Failures":3}`, auth...)
+	if status != 200 || created["Secret"] == "" || created["Secret"] == nil || created["Failures"] != 0.0 {
+		t.Fatalf("create = %d %v, want the generated secret once", status, created)
+	}
+	if created["Secret"] != stored() {
+		t.Fatalf("created secret %v, stored %s", created["Secret"], stored())
+	}
+
+	for _, path := range []string{"/admin/webhooks/1", "/admin/webhooks"} {
+		_, _, body := call(t, app, "GET", path, "", auth...)
+		if strings.Contains(fmtJSON(body), stored()) {
+			t.Fatalf("GET %s shows the secret: %v", path, body)
+		}
+	}
+
+	secret := stored()
+	if status, _, body := call(t, app, "PUT", "/admin/webhooks/1", `{"URL":"https://example.com/other","Secret":"x"}`, auth...); status != 200 {
+		t.Fatalf("update = %d %v", status, body)
+	}
+	if stored() != secret {
+		t.Fatal("PUT changed the secret")
+	}
+
+	if status, _, body := call(t, app, "POST", "/admin/webhooks/1/secret", `{"Secret":"new secret"}`, auth...); status != 200 || body["Secret"] != "new secret" {
+		t.Fatalf("set secret = %d %v", status, body)
+	}
+	if status, _, body := call(t, app, "POST", "/admin/webhooks/1/secret", "", auth...); status != 200 || body["Secret"] == "new secret" || body["Secret"] != stored() {
+		t.Fatalf("generate secret = %d %v, stored %s", status, body, stored())
+	}
+}
+
+func fmtJSON(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+func TestEventStream(t *testing.T) {
+	hub := stream.NewHub()
+	app := fiber.New(fiber.Config{ErrorHandler: routes.ErrorHandler})
+	routes.SetupStreamRoutes(app, hub)
+
+	probe, err := hub.Connect("probe", stream.Filter{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hub.Publish(publisher.NewEvent(model.Post{}, publisher.Created, "1"))
+	hub.Publish(publisher.NewEvent(model.Post{}, publisher.Updated, "1"))
+	hub.Publish(publisher.NewEvent(model.User{}, publisher.Created, "1"))
+	hub.Publish(publisher.NewEvent(model.Post{}, publisher.Deleted, "2"))
+	var ids []string
+	for i := 0; i < 4; i++ {
+		ids = append(ids, (<-probe.Messages()).ID)
+	}
+	probe.Close()
+
+	// a client that received the first event gets the posts since, the stream ends when the hub closes
+	time.AfterFunc(100*time.Millisecond, func() { hub.Close() })
+	req := httptest.NewRequest("GET", "/events/stream?entity=post", nil)
+	req.Header.Set("Last-Event-ID", ids[0])
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	var got []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if id, ok := strings.CutPrefix(line, "id: "); ok {
+			got = append(got, id)
+		}
+	}
+	if want := []string{ids[1], ids[3]}; resp.StatusCode != 200 || !reflect.DeepEqual(got, want) {
+		t.Fatalf("resume = %d with %v, want %v in\n%s", resp.StatusCode, got, want, data)
+	}
+}
+
+func TestEventStreamLimits(t *testing.T) {
+	hub := stream.NewHub()
+	hub.MaxConnections, hub.MaxPerClient = 3, 1
+	app := fiber.New(fiber.Config{ErrorHandler: routes.ErrorHandler})
+	// app.Test requests come from 0.0.0.0, a proxy identifying its callers
+	app.Use(routes.Actor(routes.HeaderActor, "0.0.0.0"))
+	routes.SetupStreamRoutes(app, hub)
+
+	if _, err := hub.Connect("0.0.0.0", stream.Filter{}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if status, _, _ := call(t, app, "GET", "/events/stream", ""); status != 429 {
+		t.Fatalf("a second connection of an anonymous client = %d, want 429", status)
+	}
+
+	// identified clients are counted on their own, not by the address of the proxy
+	streamed := make(chan int, 1)
+	go func() {
+		req := httptest.NewRequest("GET", "/events/stream", nil)
+		req.Header.Set(routes.HeaderActor, "bob")
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			streamed <- 0
+			return
+		}
+		io.ReadAll(resp.Body)
+		streamed <- resp.StatusCode
+	}()
+	for deadline := time.Now().Add(time.Second); hub.Connections() < 2 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if status, _, _ := call(t, app, "GET", "/events/stream", "", routes.HeaderActor, "bob"); status != 429 {
+		t.Fatalf("a second connection of bob = %d, want 429", status)
+	}
+
+	if _, err := hub.Connect("other", stream.Filter{}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if status, _, _ := call(t, app, "GET", "/events/stream", "", routes.HeaderActor, "carol"); status != 503 {
+		t.Fatalf("a connection beyond MaxConnections = %d, want 503", status)
+	}
+	if status, _, _ := call(t, app, "GET", "/events/stream?action=x", ""); status != 400 {
+		t.Fatalf("an unknown action = %d, want 400", status)
+	}
+
+	hub.Close()
+	if status := <-streamed; status != 200 {
+		t.Fatalf("the stream of bob = %d, want 200", status)
+	}
+}
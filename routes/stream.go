@@ -0,0 +1,173 @@
+package routes
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gorepository/publisher"
+	"gorepository/stream"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+)
+
+// SetupStreamRoutes registers the streams of the events hub receives, filtered with ?entity=, ?action= and ?id=,
+// each a comma separated list:
+//
+//	GET /events/stream?entity=post&action=created   Server-Sent Events
+//	GET /events/ws?entity=post                      WebSocket, a JSON stream.Message per event
+//
+// A client resumes after the ID of the last event it received with the Last-Event-ID header (sent by
+// EventSource when it reconnects) or ?last_event_id=. When the events since are no longer kept it gets a
+// reset instead and has to load what it shows again. Idle connections get a keepalive every hub.Heartbeat.
+func SetupStreamRoutes(app *fiber.App, hub *stream.Hub) {
+	app.Get("/events/stream", func(c *fiber.Ctx) error {
+		client, err := connect(c, hub)
+		if err != nil {
+			return err
+		}
+
+		c.Set(fiber.HeaderContentType, "text/event-stream")
+		c.Set(fiber.HeaderCacheControl, "no-cache")
+		c.Set("X-Accel-Buffering", "no") // keeps proxies like nginx from buffering the stream
+
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			defer client.Close()
+
+			ticker := time.NewTicker(hub.Heartbeat)
+			defer ticker.Stop()
+
+			fmt.Fprint(w, "retry: 3000\n\n")
+			if client.Reset {
+				fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+			}
+			for _, message := range client.Missed {
+				writeSSE(w, message)
+			}
+
+			// a write to a closed connection fails on Flush
+			for w.Flush() == nil {
+				select {
+				case message, ok := <-client.Messages():
+					if !ok {
+						return
+					}
+					writeSSE(w, message)
+				case <-ticker.C:
+					fmt.Fprint(w, ": keepalive\n\n")
+				}
+			}
+		})
+		return nil
+	})
+
+	app.Get("/events/ws", func(c *fiber.Ctx) error {
+		if !websocket.IsWebSocketUpgrade(c) {
+			return fiber.ErrUpgradeRequired
+		}
+		client, err := connect(c, hub)
+		if err != nil {
+			return err
+		}
+		c.Locals("stream", client)
+		if err := c.Next(); err != nil {
+			client.Close() // the upgrade failed
+			return err
+		}
+		return nil
+	}, websocket.New(func(conn *websocket.Conn) {
+		client := conn.Locals("stream").(*stream.Client)
+		defer client.Close()
+
+		// the client only sends control frames, reading handles them and notices when it goes away
+		go func() {
+			defer client.Close()
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		ticker := time.NewTicker(hub.Heartbeat)
+		defer ticker.Stop()
+
+		write := func(v interface{}) error {
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			return conn.WriteJSON(v)
+		}
+		if client.Reset {
+			if write(fiber.Map{"reset": true}) != nil {
+				return
+			}
+		}
+		for _, message := range client.Missed {
+			if write(message) != nil {
+				return
+			}
+		}
+
+		for {
+			select {
+			case message, ok := <-client.Messages():
+				if !ok {
+					conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(time.Second))
+					return
+				}
+				if write(message) != nil {
+					return
+				}
+			case <-ticker.C:
+				if conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)) != nil {
+					return
+				}
+			}
+		}
+	}))
+}
+
+// connect registers the client of the request with hub, a full hub is answered with 503 and a client
+// with too many connections with 429. Clients are told apart by their identity, anonymous ones by their IP.
+func connect(c *fiber.Ctx, hub *stream.Hub) (*stream.Client, error) {
+	filter := stream.Filter{
+		EntityTypes: splitFields(c.Query("entity")),
+		IDs:         splitFields(c.Query("id")),
+	}
+	for _, name := range splitFields(c.Query("action")) {
+		action, ok := publisher.ParseAction(name)
+		if !ok {
+			return nil, fiber.NewError(fiber.StatusBadRequest, "unknown action "+name)
+		}
+		filter.Actions = append(filter.Actions, action)
+	}
+
+	lastEventID := c.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
+	}
+
+	key := c.IP()
+	if identity := Identity(c); identity != "" {
+		key = "identity:" + identity // behind a proxy every client has its IP
+	}
+	client, err := hub.Connect(key, filter, lastEventID)
+	switch {
+	case errors.Is(err, stream.ErrTooManyFromClient):
+		return nil, fiber.NewError(fiber.StatusTooManyRequests, err.Error())
+	case errors.Is(err, stream.ErrTooManyConnections), errors.Is(err, publisher.ErrClosed):
+		c.Set(fiber.HeaderRetryAfter, "10")
+		return nil, fiber.NewError(fiber.StatusServiceUnavailable, err.Error())
+	}
+	return client, err
+}
+
+// writeSSE writes message as an event with its ID, the data is the event JSON on one line
+func writeSSE(w *bufio.Writer, message stream.Message) {
+	data, err := json.Marshal(message.Event)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "id: %s\ndata: %s\n\n", message.ID, data)
+}
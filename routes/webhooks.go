@@ -0,0 +1,200 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gorepository/publisher"
+	"gorepository/repository"
+	"gorepository/validation"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Pinger sends a test event to a webhook subscription, e.g. a *publisher.Webhooks
+type Pinger interface {
+	Ping(ctx context.Context, subscription publisher.WebhookSubscription) (int, error)
+}
+
+// PingResult is the response of the ping endpoint
+type PingResult struct {
+	Status int    `json:"status"` // what the endpoint answered, 0 without an answer
+	Error  string `json:"error,omitempty"`
+}
+
+// CreatedWebhook is the response of POST /admin/webhooks, the only one showing the secret
+type CreatedWebhook struct {
+	publisher.WebhookSubscription
+	Secret string
+}
+
+// WebhookSecret is the body and the response of POST /admin/webhooks/:id/secret
+type WebhookSecret struct {
+	Secret string `validate:"max=200"` // generated when empty
+}
+
+// webhookFields are what clients set on a subscription, the failure counters belong to the server and the
+// secret is only set on create and by the secret endpoint
+var webhookFields = []string{"URL", "Events", "RetrySchedule", "DisableAfter", "Disabled"}
+
+// deliveryQuery is what the delivery log can be filtered and sorted by, e.g. ?filter[status]=failed
+var deliveryQuery = repository.NewQuerySpec[publisher.WebhookDelivery]("event_id", "event_type", "status", "created_at").
+	SortBy("-created_at")
+
+// setupWebhookRoutes registers the webhook subscription endpoints under admin
+//
+//	GET|POST /admin/webhooks, GET|PUT|PATCH|DELETE /admin/webhooks/:id
+//	GET  /admin/webhooks/:id/deliveries   the delivery log, paged like the resources
+//	POST /admin/webhooks/:id/ping         sends a webhook.ping event right away
+//	POST /admin/webhooks/:id/secret       replaces the secret, with a generated one without a body
+func setupWebhookRoutes(admin fiber.Router, repos *repository.Repositories, pinger Pinger) {
+	validator := validation.New()
+
+	// created here instead of by the resource, whose responses leave the secret out
+	admin.Post("/webhooks", func(c *fiber.Ctx) error {
+		var body CreatedWebhook
+		if err := json.Unmarshal(c.Body(), &body); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "cannot parse JSON")
+		}
+		subscription := publisher.WebhookSubscription{
+			URL:           body.URL,
+			Secret:        body.Secret,
+			Events:        body.Events,
+			RetrySchedule: body.RetrySchedule,
+			DisableAfter:  body.DisableAfter,
+			Disabled:      body.Disabled,
+		}
+		if err := validator.Validate(c.UserContext(), validation.Create, &subscription); err != nil {
+			return err
+		}
+		if err := validateWebhook(c, OpCreate, &subscription); err != nil {
+			return err
+		}
+
+		subscription, err := repos.WebhookRepo.WithContext(c.UserContext()).Create(subscription)
+		if err != nil {
+			return err
+		}
+		return c.JSON(CreatedWebhook{WebhookSubscription: subscription, Secret: subscription.Secret})
+	})
+
+	RegisterResource(admin, "/webhooks", repos.WebhookRepo, ResourceOptions[publisher.WebhookSubscription]{
+		Name:           "webhook",
+		WritableFields: webhookFields,
+		Validate:       validateWebhook,
+		Disabled:       []Operation{OpCreate, OpBulkCreate, OpBulkUpsert, OpBulkUpdate, OpBulkDelete},
+	})
+
+	admin.Post("/webhooks/:id/secret", func(c *fiber.Ctx) error {
+		subscription, err := findWebhook(c, repos)
+		if err != nil {
+			return err
+		}
+
+		var body WebhookSecret
+		if len(c.Body()) > 0 {
+			if err := json.Unmarshal(c.Body(), &body); err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, "cannot parse JSON")
+			}
+		}
+		if err := validator.Validate(c.UserContext(), validation.Update, &body); err != nil {
+			return err
+		}
+		if body.Secret == "" {
+			if body.Secret, err = publisher.NewWebhookSecret(); err != nil {
+				return err
+			}
+		}
+
+		subscription.Secret = body.Secret
+		if _, err := repos.WebhookRepo.WithContext(c.UserContext()).Update(subscription); err != nil {
+			return err
+		}
+		return c.JSON(body)
+	})
+
+	admin.Get("/webhooks/:id/deliveries", func(c *fiber.Ctx) error {
+		subscription, err := findWebhook(c, repos)
+		if err != nil {
+			return err
+		}
+
+		query, err := deliveryQuery.Parse(c.Queries())
+		if err != nil {
+			return err
+		}
+
+		req, err := pageRequest(c, query)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+
+		conditions := []func(*gorm.DB) *gorm.DB{
+			func(db *gorm.DB) *gorm.DB {
+				return db.Where("subscription_id = ?", subscription.ID)
+			},
+		}
+		page, err := repos.WebhookDeliveryRepo.WithContext(c.UserContext()).ListPage(conditions, req, query.FilterOptions()...)
+		if err != nil {
+			return err
+		}
+
+		setPageLinks(c, page)
+		return c.JSON(page)
+	})
+
+	admin.Post("/webhooks/:id/ping", func(c *fiber.Ctx) error {
+		subscription, err := findWebhook(c, repos)
+		if err != nil {
+			return err
+		}
+		status, err := pinger.Ping(c.UserContext(), subscription)
+		if err != nil {
+			return c.Status(fiber.StatusBadGateway).JSON(PingResult{Status: status, Error: err.Error()})
+		}
+		return c.JSON(PingResult{Status: status})
+	})
+}
+
+func findWebhook(c *fiber.Ctx, repos *repository.Repositories) (publisher.WebhookSubscription, error) {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 0)
+	if err != nil {
+		return publisher.WebhookSubscription{}, fiber.NewError(fiber.StatusBadRequest, "invalid ID")
+	}
+	subscription, err := repos.WebhookRepo.WithContext(c.UserContext()).FindByID(uint(id))
+	if errors.Is(err, repository.ErrNotFound) {
+		return subscription, fmt.Errorf("webhook %w", repository.ErrNotFound)
+	}
+	return subscription, err
+}
+
+// validateWebhook checks what the validate tags cannot: the URL, the event patterns and the retry schedule
+func validateWebhook(c *fiber.Ctx, op Operation, subscription *publisher.WebhookSubscription) error {
+	fields := map[string]string{}
+	if u, err := url.Parse(subscription.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		fields["URL"] = "must be an http or https URL"
+	}
+	if strings.TrimSpace(subscription.Events) != "" {
+		for _, pattern := range strings.Split(subscription.Events, ",") {
+			entityType, action, _ := strings.Cut(strings.TrimSpace(pattern), ".")
+			if entityType == "" || strings.Contains(action, ".") {
+				fields["Events"] = "must be patterns like post.created or user.*"
+			}
+		}
+	}
+	if _, err := subscription.Schedule(); err != nil {
+		fields["RetrySchedule"] = "must be durations like 1m,1h"
+	}
+	if subscription.DisableAfter < 0 {
+		fields["DisableAfter"] = "must not be negative"
+	}
+	if len(fields) > 0 {
+		return &repository.ErrValidation{Fields: fields}
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"gorepository/migrations"
+	"gorepository/model"
+	"gorepository/publisher"
+	"gorepository/repository"
+	"gorepository/routes"
+	"gorepository/stream"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// newApp registers the routes of the API, serve listens with it and listRoutes prints them
+func newApp(repos *repository.Repositories, replayer routes.Replayer, pinger routes.Pinger, hub *stream.Hub) *fiber.App {
+	config := fiber.Config{
+		AppName:      "identity front end api",
+		ErrorHandler: routes.ErrorHandler, // errors as application/problem+json
+	}
+	// behind the proxies of TRUSTED_PROXIES the IP of a request is the client's they set in X-Forwarded-For
+	if proxies := trustedProxies(); len(proxies) > 0 {
+		config.ProxyHeader = fiber.HeaderXForwardedFor
+		config.EnableTrustedProxyCheck = true
+		config.TrustedProxies = proxies
+		config.EnableIPValidation = true
+	}
+	app := fiber.New(config)
+
+	authenticate(app)
+	routes.SetupRoutes(app, repos)
+	routes.SetupAdminRoutes(app, repos, replayer, pinger)
+	routes.SetupStreamRoutes(app, hub)
+	return app
+}
+
+func serve(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	port := flags.String("port", envOr("PORT", "3000"), "port to listen on, PORT by default")
+	migrate := flags.Bool("migrate", true, "apply the pending migrations before starting")
+	flags.Parse(args)
+
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+
+	// Apply the pending migrations of migrations/sql, replicas starting together wait for each other
+	if *migrate {
+		migrator, err := migrations.New(db)
+		if err != nil {
+			return err
+		}
+		if _, err := migrator.Up(context.Background()); err != nil {
+			return err
+		}
+	}
+
+	// The broker events are relayed to, selected with PUBLISHER=nats|kafka|amqp, none by default
+	broker, err := publisher.Open(publisher.BrokerConfigFromEnv())
+	if err != nil {
+		return err
+	}
+	// while the broker is down events fail fast instead of waiting for timeouts
+	breaker := publisher.NewCircuitBreaker(broker)
+	defer publisher.Close(breaker)
+
+	// Background jobs stop with ctx on shutdown
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	// Webhooks stores a delivery per matching subscription of /admin/webhooks and sends them in the background
+	webhooks := publisher.NewWebhooks(db)
+	go webhooks.Run(ctx)
+
+	// The hub streams the changes to /events/stream and /events/ws. Every instance announces its changes
+	// with pg_notify and the hub of each gets them from its own listener, so the clients of every instance
+	// see the changes of all of them.
+	hub := stream.NewHub()
+	go publisher.NewListener(os.Getenv("DATABASE_URL"), hub, model.User{}, model.Post{}).Run(ctx)
+
+	// Events are written to the outbox together with the entity change, once for every target, and each
+	// target has its own relay, so one that fails is retried without the others getting the event again.
+	// Those the broker fails too often are dead lettered and can be replayed through /admin/dead-letters,
+	// the webhooks retry on their own schedule.
+	deadLetters := publisher.NewDeadLetterStore(db, breaker)
+	targets := map[string]publisher.Publisher{targetBroker: breaker, targetWebhooks: webhooks}
+	for _, target := range outboxTargets {
+		relay := publisher.NewOutboxRelay(db, targets[target])
+		relay.Target = target
+		if target == targetBroker {
+			relay.DeadLetters = deadLetters
+		}
+		go relay.Run(ctx)
+	}
+
+	// With PUBLISH_VIA=async events skip the outbox, they are queued in memory and published in batches,
+	// retried per target. Otherwise they are stored with the change and announced with pg_notify on commit.
+	pub := txPublisher(db)
+	var queue *publisher.Async
+	if os.Getenv("PUBLISH_VIA") == "async" {
+		queue = publisher.NewAsync(publisher.Fanout{
+			publisher.NewRetrying(breaker, deadLetters),
+			publisher.NewRetrying(webhooks, nil),
+			publisher.NewNotify(db),
+		}, 10000)
+		pub = queue
+	}
+
+	repos := newRepositories(db, pub)
+
+	// Soft deleted rows are purged once they are older than the retention window
+	retention, err := time.ParseDuration(os.Getenv("SOFT_DELETE_RETENTION"))
+	if err != nil {
+		retention = 30 * 24 * time.Hour
+	}
+	go repository.RunPurgeJob(ctx, time.Hour, retention, repos.UserRepo, repos.PostRepo)
+
+	// Outbox rows are deleted once they were delivered or dead lettered longer than OUTBOX_RETENTION ago
+	outboxRetention, err := time.ParseDuration(os.Getenv("OUTBOX_RETENTION"))
+	if err != nil {
+		outboxRetention = 7 * 24 * time.Hour
+	}
+	go publisher.RunPurgeJob(ctx, time.Hour, outboxRetention, publisher.NewOutbox(db))
+
+	// Delivered and failed webhook deliveries are shown by /admin/webhooks for WEBHOOK_DELIVERY_RETENTION
+	deliveryRetention, err := time.ParseDuration(os.Getenv("WEBHOOK_DELIVERY_RETENTION"))
+	if err != nil {
+		deliveryRetention = 30 * 24 * time.Hour
+	}
+	go publisher.RunPurgeJob(ctx, time.Hour, deliveryRetention, webhooks)
+
+	app := newApp(repos, deadLetters, webhooks, hub)
+
+	// SIGINT and SIGTERM stop accepting requests and let the running ones finish
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+		<-quit
+		hub.Close() // ends the streams, which would hold the shutdown up
+		app.ShutdownWithTimeout(10 * time.Second)
+	}()
+
+	err = app.Listen(":" + *port)
+
+	// publish what the last requests queued before the broker connection is closed
+	if queue != nil {
+		drainCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := queue.Shutdown(drainCtx); err != nil {
+			log.Printf("%d events not published: %v", queue.Len(), err)
+		}
+	}
+	return err
+}
+
+// envOr returns the environment variable key, or fallback when it is not set
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// authenticate identifies the callers of every route, their identity is the audit actor of their changes.
+// API_TOKENS=token:name,... requires a bearer token. Behind a proxy that authenticates the callers itself,
+// TRUSTED_PROXIES=ip,... accepts the identity it sets in X-Actor from those addresses instead.
+func authenticate(app *fiber.App) {
+	if proxies := trustedProxies(); len(proxies) > 0 {
+		app.Use(routes.Actor(routes.HeaderActor, proxies...))
+	}
+	if tokens := apiTokens(os.Getenv("API_TOKENS")); len(tokens) > 0 {
+		app.Use(routes.BearerAuth(tokens))
+	}
+}
+
+// trustedProxies returns the addresses of TRUSTED_PROXIES
+func trustedProxies() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
+
+// apiTokens parses token:name pairs separated by commas
+func apiTokens(s string) map[string]string {
+	tokens := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		token, name, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if ok && token != "" && name != "" {
+			tokens[token] = name
+		}
+	}
+	return tokens
+}
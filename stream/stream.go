@@ -0,0 +1,229 @@
+// Package stream hands published events to the clients of the SSE and WebSocket endpoints, see
+// routes.SetupStreamRoutes. A Hub keeps the last events, so a client that reconnects resumes where it stopped.
+package stream
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorepository/publisher"
+)
+
+var (
+	// ErrTooManyConnections is returned by Connect while MaxConnections clients are connected
+	ErrTooManyConnections = errors.New("too many connections")
+	// ErrTooManyFromClient is returned by Connect while a client has MaxPerClient connections
+	ErrTooManyFromClient = errors.New("too many connections from this client")
+)
+
+// Filter selects the events of a connection, an empty field selects every value
+type Filter struct {
+	EntityTypes []string
+	Actions     []publisher.Action
+	IDs         []string // entity IDs
+}
+
+// Matches reports whether event passes f
+func (f Filter) Matches(event publisher.Event) bool {
+	return matches(f.EntityTypes, event.EntityType) && matches(f.Actions, event.Action) && matches(f.IDs, event.Subject)
+}
+
+func matches[T comparable](values []T, value T) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Message is an event with its position in the stream of a hub, the ID a client resumes after
+type Message struct {
+	ID    string          `json:"id"`
+	Event publisher.Event `json:"event"`
+}
+
+// Hub is a Publisher sending every event to the connected clients whose filter it passes. A client that does
+// not keep up with ClientBuffer messages is disconnected, it resumes from the last BufferSize events when it
+// reconnects. Set the fields before the first Connect.
+type Hub struct {
+	BufferSize     int           // events kept to resume from
+	ClientBuffer   int           // messages waiting per client
+	MaxConnections int           // clients connected at once
+	MaxPerClient   int           // connections per client key, e.g. its IP
+	Heartbeat      time.Duration // time between keepalives of idle connections, see routes.SetupStreamRoutes
+
+	mu      sync.Mutex
+	epoch   string // tells the IDs of this hub from those of one before a restart
+	seq     uint64
+	ring    []Message
+	next    int // where the ring is written next
+	clients map[*Client]struct{}
+	perKey  map[string]int
+	closed  bool
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		BufferSize:     1000,
+		ClientBuffer:   100,
+		MaxConnections: 1000,
+		MaxPerClient:   10,
+		Heartbeat:      15 * time.Second,
+		epoch:          strconv.FormatInt(time.Now().UnixNano(), 36),
+		clients:        map[*Client]struct{}{},
+		perKey:         map[string]int{},
+	}
+}
+
+// Client is a connection of a hub
+type Client struct {
+	hub      *Hub
+	key      string
+	filter   Filter
+	messages chan Message
+
+	// Missed are the events since the ID the client resumed after, to be sent before Messages
+	Missed []Message
+	// Reset is set when the client cannot resume, the event it resumed after is no longer kept.
+	// It has to load what it shows again.
+	Reset bool
+}
+
+// Messages returns the events for c as they are published, it is closed once c is disconnected
+func (c *Client) Messages() <-chan Message {
+	return c.messages
+}
+
+// Close disconnects c
+func (c *Client) Close() {
+	c.hub.mu.Lock()
+	defer c.hub.mu.Unlock()
+	c.hub.remove(c)
+}
+
+// Connect registers a client with key, e.g. its IP, for the events passing filter. With lastEventID, the ID
+// of the last message it received, the events it missed since are in Missed.
+func (h *Hub) Connect(key string, filter Filter, lastEventID string) (*Client, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	switch {
+	case h.closed:
+		return nil, publisher.ErrClosed
+	case len(h.clients) >= h.MaxConnections:
+		return nil, ErrTooManyConnections
+	case h.perKey[key] >= h.MaxPerClient:
+		return nil, ErrTooManyFromClient
+	}
+
+	c := &Client{hub: h, key: key, filter: filter, messages: make(chan Message, h.ClientBuffer)}
+	if lastEventID != "" {
+		c.Missed, c.Reset = h.since(lastEventID, filter)
+	}
+	h.clients[c] = struct{}{}
+	h.perKey[key]++
+	return c, nil
+}
+
+func (h *Hub) Publish(event publisher.Event) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil // nobody to send it to, which is no reason to publish it again
+	}
+
+	h.seq++
+	message := Message{ID: h.epoch + "-" + strconv.FormatUint(h.seq, 10), Event: event}
+	if len(h.ring) < h.BufferSize {
+		h.ring = append(h.ring, message)
+	} else if h.BufferSize > 0 {
+		h.ring[h.next] = message
+	}
+	if h.BufferSize > 0 {
+		h.next = (h.next + 1) % h.BufferSize
+	}
+
+	for c := range h.clients {
+		if !c.filter.Matches(event) {
+			continue
+		}
+		select {
+		case c.messages <- message:
+		default:
+			h.remove(c) // too slow, it resumes when it reconnects
+		}
+	}
+	return nil
+}
+
+func (h *Hub) PublishBatch(events []publisher.Event) error {
+	for _, event := range events {
+		if err := h.Publish(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Connections returns how many clients are connected
+func (h *Hub) Connections() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.clients)
+}
+
+// Close disconnects every client and refuses new ones
+func (h *Hub) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for c := range h.clients {
+		h.remove(c)
+	}
+	return nil
+}
+
+func (h *Hub) remove(c *Client) {
+	if _, ok := h.clients[c]; !ok {
+		return
+	}
+	delete(h.clients, c)
+	if h.perKey[c.key]--; h.perKey[c.key] <= 0 {
+		delete(h.perKey, c.key)
+	}
+	close(c.messages)
+}
+
+// since returns the kept messages after the one with id that pass filter, or reset when they are not all kept
+func (h *Hub) since(id string, filter Filter) (missed []Message, reset bool) {
+	epoch, raw, _ := strings.Cut(id, "-")
+	seq, err := strconv.ParseUint(raw, 10, 64)
+	if err != nil || epoch != h.epoch || seq > h.seq {
+		return nil, true
+	}
+	oldest := h.seq - uint64(len(h.ring)) + 1
+	if seq+1 < oldest {
+		return nil, true
+	}
+
+	for i := range h.ring {
+		message := h.ring[(h.next+i)%len(h.ring)]
+		if messageSeq(message) > seq && filter.Matches(message.Event) {
+			missed = append(missed, message)
+		}
+	}
+	return missed, false
+}
+
+func messageSeq(message Message) uint64 {
+	_, raw, _ := strings.Cut(message.ID, "-")
+	seq, _ := strconv.ParseUint(raw, 10, 64)
+	return seq
+}
@@ -0,0 +1,145 @@
+package stream_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"gorepository/publisher"
+	"gorepository/stream"
+)
+
+type post struct {
+	ID uint
+}
+
+func publish(t *testing.T, hub *stream.Hub, ids ...string) {
+	t.Helper()
+	for _, id := range ids {
+		if err := hub.Publish(publisher.NewEvent(post{}, publisher.Updated, id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func subjects(messages []stream.Message) []string {
+	subjects := []string{}
+	for _, message := range messages {
+		subjects = append(subjects, message.Event.Subject)
+	}
+	return subjects
+}
+
+// receive reads the n messages waiting for client
+func receive(t *testing.T, client *stream.Client, n int) []stream.Message {
+	t.Helper()
+	var messages []stream.Message
+	for i := 0; i < n; i++ {
+		select {
+		case message := <-client.Messages():
+			messages = append(messages, message)
+		default:
+			t.Fatalf("received %d messages, want %d", i, n)
+		}
+	}
+	return messages
+}
+
+func TestResume(t *testing.T) {
+	hub := stream.NewHub()
+	hub.BufferSize = 4
+
+	client, err := hub.Connect("a", stream.Filter{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	publish(t, hub, "1", "2")
+	last := receive(t, client, 2)[1].ID
+	client.Close()
+	if _, ok := <-client.Messages(); ok {
+		t.Fatal("messages still open after Close")
+	}
+
+	// missed while disconnected
+	publish(t, hub, "3", "4")
+	client, err = hub.Connect("a", stream.Filter{}, last)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := subjects(client.Missed); client.Reset || !reflect.DeepEqual(got, []string{"3", "4"}) {
+		t.Fatalf("missed %v reset %v, want [3 4]", got, client.Reset)
+	}
+	client.Close()
+
+	// only those passing the filter
+	client, err = hub.Connect("a", stream.Filter{IDs: []string{"4"}}, last)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := subjects(client.Missed); !reflect.DeepEqual(got, []string{"4"}) {
+		t.Fatalf("missed %v, want [4]", got)
+	}
+	client.Close()
+
+	// 2 is no longer kept, the client has to start over
+	publish(t, hub, "5", "6", "7")
+	for _, id := range []string{last, "other-1", "x"} {
+		client, err := hub.Connect("a", stream.Filter{}, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !client.Reset || len(client.Missed) != 0 {
+			t.Fatalf("resume after %s: missed %v reset %v, want a reset", id, subjects(client.Missed), client.Reset)
+		}
+		client.Close()
+	}
+}
+
+func TestSlowClient(t *testing.T) {
+	hub := stream.NewHub()
+	hub.ClientBuffer = 2
+
+	client, err := hub.Connect("a", stream.Filter{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	publish(t, hub, "1", "2", "3")
+	receive(t, client, 2)
+	if _, ok := <-client.Messages(); ok {
+		t.Fatal("a client falling behind stays connected")
+	}
+	if n := hub.Connections(); n != 0 {
+		t.Fatalf("%d connections, want 0", n)
+	}
+}
+
+func TestConnectionLimits(t *testing.T) {
+	hub := stream.NewHub()
+	hub.MaxConnections, hub.MaxPerClient = 3, 2
+
+	connect := func(key string, want error) *stream.Client {
+		t.Helper()
+		client, err := hub.Connect(key, stream.Filter{}, "")
+		if !errors.Is(err, want) {
+			t.Fatalf("Connect(%s) = %v, want %v", key, err, want)
+		}
+		return client
+	}
+
+	a := connect("a", nil)
+	connect("a", nil)
+	connect("a", stream.ErrTooManyFromClient)
+	connect("b", nil)
+	connect("c", stream.ErrTooManyConnections)
+
+	a.Close()
+	a.Close() // closing twice frees one place
+	connect("c", nil)
+	connect("a", stream.ErrTooManyConnections)
+
+	hub.Close()
+	if n := hub.Connections(); n != 0 {
+		t.Fatalf("%d connections after Close, want 0", n)
+	}
+	connect("d", publisher.ErrClosed)
+}
@@ -0,0 +1,31 @@
+package validation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gorepository/repository"
+)
+
+// Exists is a rule for references, it fails when repo has no entity with the field's value as ID:
+//
+//	v.Register("user_exists", validation.Exists(repos.UserRepo))
+//
+// Soft deleted entities do not exist. Use it with required, as zero values are not checked.
+func Exists[T any, ID comparable](repo repository.GenericRepository[T, ID]) Func {
+	return func(ctx context.Context, value interface{}, _ string) (string, error) {
+		id, ok := value.(ID)
+		if !ok {
+			var err error
+			if id, err = repository.ParseID[ID](fmt.Sprint(value)); err != nil {
+				return "is not a valid ID", nil
+			}
+		}
+
+		_, err := repo.WithContext(ctx).FindByID(id)
+		if errors.Is(err, repository.ErrNotFound) {
+			return "does not exist", nil
+		}
+		return "", err
+	}
+}
@@ -0,0 +1,265 @@
+// Package validation checks model payloads against the rules in their `validate` struct tags:
+//
+//	type User struct {
+//		Name  string `validate:"required,max=100"`
+//		Email string `validate:"required,email"`
+//	}
+//
+// Rules are separated by commas, a rule prefixed with "create:" or "update:" only applies to that operation,
+// e.g. `validate:"create:required,max=200"`. Every rule except required passes for zero values.
+// Built in are required, email, min=n, max=n (length of strings and slices, value of numbers) and
+// oneof=a b c, more can be added with Validator.Register.
+package validation
+
+import (
+	"context"
+	"fmt"
+	"gorepository/repository"
+	"net/mail"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Set selects the rules of an operation
+type Set string
+
+const (
+	Create Set = "create"
+	Update Set = "update" // PUT and PATCH
+)
+
+// Func is a custom rule. It returns a problem like "does not exist" for an invalid value and "" for a valid one,
+// err is for failures to check at all, e.g. a lost database connection.
+type Func func(ctx context.Context, value interface{}, param string) (problem string, err error)
+
+// Validator holds the rules available to struct tags
+type Validator struct {
+	mu    sync.RWMutex
+	funcs map[string]Func
+	types sync.Map // reflect.Type => []fieldRules
+}
+
+// New returns a Validator with the built in rules
+func New() *Validator {
+	v := &Validator{funcs: map[string]Func{}}
+	v.Register("required", required)
+	v.Register("email", email)
+	v.Register("min", limit(func(n, min float64) bool { return n >= min }, "must be at least"))
+	v.Register("max", limit(func(n, max float64) bool { return n <= max }, "must be at most"))
+	v.Register("oneof", oneOf)
+	return v
+}
+
+// Register adds a rule or replaces one, e.g. v.Register("user_exists", validation.Exists(repos.UserRepo))
+func (v *Validator) Register(name string, fn Func) *Validator {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.funcs[name] = fn
+	return v
+}
+
+// Validate checks entity, a pointer to a struct, against the rules of set. Invalid fields are returned as
+// *repository.ErrValidation with a message per field, keyed by JSON name. Other errors mean it could not validate.
+func (v *Validator) Validate(ctx context.Context, set Set, entity interface{}) error {
+	return v.validate(ctx, set, entity, nil)
+}
+
+// ValidateFields is Validate for the named fields only (JSON names, case insensitive), e.g. the ones a bulk update writes
+func (v *Validator) ValidateFields(ctx context.Context, set Set, entity interface{}, fields []string) error {
+	return v.validate(ctx, set, entity, fields)
+}
+
+func (v *Validator) validate(ctx context.Context, set Set, entity interface{}, only []string) error {
+	value := reflect.Indirect(reflect.ValueOf(entity))
+	if value.Kind() != reflect.Struct {
+		return fmt.Errorf("validation: cannot validate %T", entity)
+	}
+
+	fields, err := v.rules(value.Type())
+	if err != nil {
+		return err
+	}
+
+	problems := map[string]string{}
+	for _, field := range fields {
+		if only != nil && !contains(only, field.name) {
+			continue
+		}
+		fieldValue := value.FieldByIndex(field.index)
+		for _, rule := range field.rules {
+			if rule.set != "" && rule.set != set {
+				continue
+			}
+			if rule.name != "required" && fieldValue.IsZero() {
+				continue
+			}
+
+			v.mu.RLock()
+			fn := v.funcs[rule.name]
+			v.mu.RUnlock()
+			if fn == nil {
+				return fmt.Errorf("validation: unknown rule %q on %s.%s", rule.name, value.Type().Name(), field.name)
+			}
+
+			problem, err := fn(ctx, fieldValue.Interface(), rule.param)
+			if err != nil {
+				return err
+			}
+			if problem != "" {
+				problems[field.name] = problem
+				break // one message per field
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return &repository.ErrValidation{Fields: problems}
+	}
+	return nil
+}
+
+type rule struct {
+	set   Set
+	name  string
+	param string
+}
+
+type fieldRules struct {
+	index []int
+	name  string // JSON name
+	rules []rule
+}
+
+// rules parses the validate tags of t, including those of embedded structs like gorm.Model
+func (v *Validator) rules(t reflect.Type) ([]fieldRules, error) {
+	if cached, ok := v.types.Load(t); ok {
+		return cached.([]fieldRules), nil
+	}
+
+	var fields []fieldRules
+	var walk func(t reflect.Type, index []int) error
+	walk = func(t reflect.Type, index []int) error {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			fieldIndex := append(append([]int{}, index...), i)
+			if f.Anonymous && f.Type.Kind() == reflect.Struct {
+				if err := walk(f.Type, fieldIndex); err != nil {
+					return err
+				}
+				continue
+			}
+
+			tag := f.Tag.Get("validate")
+			if tag == "" || !f.IsExported() {
+				continue
+			}
+			field := fieldRules{index: fieldIndex, name: jsonName(f)}
+			for _, item := range strings.Split(tag, ",") {
+				r := rule{}
+				item = strings.TrimSpace(item)
+				if set, rest, ok := strings.Cut(item, ":"); ok {
+					if Set(set) != Create && Set(set) != Update {
+						return fmt.Errorf("validation: unknown rule set %q on %s.%s", set, t.Name(), f.Name)
+					}
+					r.set, item = Set(set), rest
+				}
+				r.name, r.param, _ = strings.Cut(item, "=")
+				field.rules = append(field.rules, r)
+			}
+			fields = append(fields, field)
+		}
+		return nil
+	}
+	if err := walk(t, nil); err != nil {
+		return nil, err
+	}
+
+	v.types.Store(t, fields)
+	return fields, nil
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func jsonName(f reflect.StructField) string {
+	if name, _, _ := strings.Cut(f.Tag.Get("json"), ","); name != "" && name != "-" {
+		return name
+	}
+	return f.Name
+}
+
+func required(_ context.Context, value interface{}, _ string) (string, error) {
+	v := reflect.ValueOf(value)
+	if !v.IsValid() || v.IsZero() {
+		return "is required", nil
+	}
+	if v.Kind() == reflect.String && strings.TrimSpace(v.String()) == "" {
+		return "is required", nil
+	}
+	return "", nil
+}
+
+func email(_ context.Context, value interface{}, _ string) (string, error) {
+	s, ok := value.(string)
+	if !ok {
+		return "must be a string", nil
+	}
+	address, err := mail.ParseAddress(s)
+	if err != nil || address.Address != s {
+		return "must be an email address", nil
+	}
+	return "", nil
+}
+
+// limit builds min and max: the length of strings and slices or the value of numbers compared to the parameter
+func limit(ok func(n, limit float64) bool, message string) Func {
+	return func(_ context.Context, value interface{}, param string) (string, error) {
+		bound, err := strconv.ParseFloat(param, 64)
+		if err != nil {
+			return "", fmt.Errorf("validation: invalid limit %q", param)
+		}
+
+		v := reflect.Indirect(reflect.ValueOf(value))
+		var n float64
+		unit := ""
+		switch v.Kind() {
+		case reflect.String:
+			n, unit = float64(utf8.RuneCountInString(v.String())), " characters"
+		case reflect.Slice, reflect.Map, reflect.Array:
+			n, unit = float64(v.Len()), " items"
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n = float64(v.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			n = float64(v.Uint())
+		case reflect.Float32, reflect.Float64:
+			n = v.Float()
+		default:
+			return "", fmt.Errorf("validation: cannot compare %T to a limit", value)
+		}
+
+		if !ok(n, bound) {
+			return fmt.Sprintf("%s %s%s", message, param, unit), nil
+		}
+		return "", nil
+	}
+}
+
+func oneOf(_ context.Context, value interface{}, param string) (string, error) {
+	s := fmt.Sprint(reflect.Indirect(reflect.ValueOf(value)).Interface())
+	options := strings.Fields(param)
+	for _, option := range options {
+		if s == option {
+			return "", nil
+		}
+	}
+	return "must be one of " + strings.Join(options, ", "), nil
+}
@@ -0,0 +1,97 @@
+package validation_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"gorepository/model"
+	"gorepository/repository"
+	"gorepository/validation"
+)
+
+type account struct {
+	Name    string `validate:"required,max=5"`
+	Email   string `validate:"create:required,email"` // may be left out of an update
+	Role    string `json:"role" validate:"oneof=admin member"`
+	Age     int    `validate:"min=18"`
+	OwnerID uint   `validate:"update:required,user_exists"`
+}
+
+func TestValidate(t *testing.T) {
+	users := repository.NewMemoryRepository[model.User, uint](nil)
+	if _, err := users.Create(model.User{Name: "Ann", Email: "ann@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	v := validation.New().Register("user_exists", validation.Exists(users))
+
+	tests := []struct {
+		name    string
+		set     validation.Set
+		account account
+		want    map[string]string // the problems, nil when it is valid
+	}{
+		{name: "valid create", set: validation.Create, account: account{Name: "ann", Email: "ann@example.com"}},
+		{name: "required on create", set: validation.Create, account: account{Name: " "}, want: map[string]string{
+			"Name": "is required", "Email": "is required",
+		}},
+		{name: "create rules skipped on update", set: validation.Update, account: account{Name: "ann", OwnerID: 1}},
+		{name: "update rules skipped on create", set: validation.Create, account: account{Name: "ann", Email: "a@b.c"}},
+		{name: "required on update", set: validation.Update, account: account{Name: "ann"}, want: map[string]string{
+			"OwnerID": "is required",
+		}},
+		{name: "formats", set: validation.Update, account: account{Name: "annabel", Email: "Ann <ann@example.com>", Role: "root", Age: 17, OwnerID: 1}, want: map[string]string{
+			"Name": "must be at most 5 characters", "Email": "must be an email address", "role": "must be one of admin, member", "Age": "must be at least 18",
+		}},
+		{name: "reference", set: validation.Update, account: account{Name: "ann", OwnerID: 2}, want: map[string]string{
+			"OwnerID": "does not exist",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := v.Validate(context.Background(), tt.set, &tt.account)
+			var invalid *repository.ErrValidation
+			switch {
+			case tt.want == nil && err != nil:
+				t.Fatalf("Validate = %v, want it valid", err)
+			case tt.want != nil && !errors.As(err, &invalid):
+				t.Fatalf("Validate = %v, want *repository.ErrValidation", err)
+			case tt.want != nil && !reflect.DeepEqual(invalid.Fields, tt.want):
+				t.Fatalf("problems %v, want %v", invalid.Fields, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateFields(t *testing.T) {
+	v := validation.New().Register("user_exists", func(context.Context, interface{}, string) (string, error) { return "", nil })
+
+	// a bulk update writing only the role is not held to the other rules
+	err := v.ValidateFields(context.Background(), validation.Update, &account{Role: "member"}, []string{"ROLE"})
+	if err != nil {
+		t.Fatalf("ValidateFields = %v, want the role alone checked", err)
+	}
+	err = v.ValidateFields(context.Background(), validation.Update, &account{Role: "root"}, []string{"role"})
+	var invalid *repository.ErrValidation
+	if !errors.As(err, &invalid) || len(invalid.Fields) != 1 || invalid.Fields["role"] == "" {
+		t.Fatalf("ValidateFields = %v, want the invalid role alone", err)
+	}
+}
+
+func TestValidateErrors(t *testing.T) {
+	type unknown struct {
+		Name string `validate:"shouting"`
+	}
+	type badSet struct {
+		Name string `validate:"delete:required"`
+	}
+
+	v := validation.New()
+	for _, entity := range []interface{}{&unknown{Name: "x"}, &badSet{}, "ann"} {
+		var invalid *repository.ErrValidation
+		if err := v.Validate(context.Background(), validation.Create, entity); err == nil || errors.As(err, &invalid) {
+			t.Errorf("Validate(%T) = %v, want an error validating at all", entity, err)
+		}
+	}
+}